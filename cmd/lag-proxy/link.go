@@ -0,0 +1,141 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/rywk/minigoao/pkg/msgs"
+)
+
+// Link is how bad one direction of the connection is
+type Link struct {
+	Lag    time.Duration
+	Jitter time.Duration
+	// Dist is how the jitter is added to the lag:
+	// uniform is lag ± jitter, normal has jitter as the deviation
+	// and exp adds a long tail of spikes with jitter as the mean.
+	Dist string
+	// Bandwidth in bytes per second, 0 is no cap
+	Bandwidth int
+}
+
+func linkFlags(prefix, what string) *Link {
+	l := &Link{}
+	flag.DurationVar(&l.Lag, prefix+"-lag", 0, "base delay "+what)
+	flag.DurationVar(&l.Jitter, prefix+"-jitter", 0, "jitter "+what+", see -"+prefix+"-dist")
+	flag.StringVar(&l.Dist, prefix+"-dist", "uniform", "jitter distribution "+what+": uniform, normal or exp")
+	flag.IntVar(&l.Bandwidth, prefix+"-bw", 0, "bandwidth cap "+what+" in bytes per second, 0 for no cap")
+	return l
+}
+
+func (l *Link) Check() error {
+	switch l.Dist {
+	case "uniform", "normal", "exp":
+	default:
+		return fmt.Errorf("unknown jitter distribution %q", l.Dist)
+	}
+	if l.Lag < 0 || l.Jitter < 0 || l.Bandwidth < 0 {
+		return fmt.Errorf("lag, jitter and bandwidth cant be negative")
+	}
+	return nil
+}
+
+func (l *Link) String() string {
+	bw := "no cap"
+	if l.Bandwidth > 0 {
+		bw = fmt.Sprintf("%vB/s", l.Bandwidth)
+	}
+	return fmt.Sprintf("lag %v, jitter %v %v, %v", l.Lag, l.Jitter, l.Dist, bw)
+}
+
+// delay is the lag of one event
+func (l *Link) delay() time.Duration {
+	j := float64(l.Jitter)
+	var d float64
+	switch l.Dist {
+	case "uniform":
+		d = (rand.Float64()*2 - 1) * j
+	case "normal":
+		d = rand.NormFloat64() * j
+	case "exp":
+		d = rand.ExpFloat64() * j
+	}
+	return max(l.Lag+time.Duration(d), 0)
+}
+
+type delivery struct {
+	at time.Time
+	im *msgs.IncomingData
+}
+
+// pipe reads events from one side and writes them to the other one when
+// they are due, reading never waits for the writes so the lag doesnt
+// eat throughput. Nothing is reordered, an event that got a short delay
+// waits for the one before it. seen gets every event as it is read.
+func pipe(l *Link, from, to msgs.Msgs, seen func(*msgs.IncomingData)) error {
+	q := make(chan delivery, 4096)
+	done := make(chan error, 1)
+	go func() {
+		done <- deliver(q, to)
+	}()
+	var free, last time.Time
+	for {
+		im, err := from.Read()
+		if err != nil {
+			close(q)
+			return err
+		}
+		if im == nil {
+			continue
+		}
+		seen(im)
+		now := time.Now()
+		at := now
+		if l.Bandwidth > 0 {
+			// the link is busy sending the ones before this one
+			free = maxTime(free, now).Add(time.Duration(size(im)) * time.Second / time.Duration(l.Bandwidth))
+			at = free
+		}
+		at = maxTime(at.Add(l.delay()), last)
+		last = at
+		select {
+		case q <- delivery{at: at, im: im}:
+		case err := <-done:
+			return err
+		}
+	}
+}
+
+func deliver(q chan delivery, to msgs.Msgs) error {
+	for d := range q {
+		time.Sleep(time.Until(d.at))
+		if err := write(to, d.im); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func write(to msgs.Msgs, im *msgs.IncomingData) error {
+	if im.Event.Len() == -1 {
+		return to.WriteWithLen(im.Event, im.Data)
+	}
+	return to.Write(im.Event, im.Data)
+}
+
+// size is the bytes the event takes in the wire
+func size(im *msgs.IncomingData) int {
+	if im.Event.Len() == -1 {
+		return 3 + len(im.Data)
+	}
+	return 1 + len(im.Data)
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
@@ -1,86 +1,172 @@
 package main
 
 import (
+	"flag"
 	"log"
+	"math/rand/v2"
 	"net"
+	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/rywk/minigoao/pkg/msgs"
 )
 
-// go run ./cmd/lag-proxy/main.go 192.168.0.1:$1 127.0.0.1:$2 $3
+// lag-proxy goes between the clients and the server and makes the connection bad on purpose
+//
+//	go run ./cmd/lag-proxy -server 127.0.0.1:5555 -listen 127.0.0.1:5556 \
+//		-up-lag 40ms -down-lag 60ms -down-jitter 30ms -down-dist exp -down-bw 20000 -drop-every 5m
+//
+// with -ws-listen it also fronts the /upgrader of the web server for the browser client,
+// with -record everything that goes through is written to a capture file.
 func main() {
-	if len(os.Args) != 4 {
-		panic("usage: 127.0.0.1:5555 127.0.0.1:5555 10")
+	var (
+		serverAddr   = flag.String("server", "127.0.0.1:5555", "tcp address of the game server")
+		listenAddr   = flag.String("listen", "127.0.0.1:5556", "tcp address for the clients")
+		wsServerAddr = flag.String("ws-server", "127.0.0.1:8080", "web address of the game server, its /upgrader is what gets proxied")
+		wsListenAddr = flag.String("ws-listen", "", "web address for the browser clients, empty to not proxy websockets")
+		recordPath   = flag.String("record", "", "capture file to write every event to, empty to not record")
+		dropEvery    = flag.Duration("drop-every", 0, "drop each connection after a random time, this long on average, 0 never")
+		up           = linkFlags("up", "client to server")
+		down         = linkFlags("down", "server to client")
+	)
+	flag.Parse()
+	for _, l := range []*Link{up, down} {
+		if err := l.Check(); err != nil {
+			log.Fatal(err)
+		}
 	}
+	log.Printf("up: %v\n", up)
+	log.Printf("down: %v\n", down)
 
-	realAddr := os.Args[1]
-	proxyAddr := os.Args[2]
+	p := &Proxy{up: up, down: down, dropEvery: *dropEvery}
+	if *recordPath != "" {
+		capture, err := msgs.CreateCapture(*recordPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		p.capture = capture
+		log.Printf("Recording to %v\n", *recordPath)
+		go p.flushCapture()
+	}
 
-	ms, err := strconv.Atoi(os.Args[3])
-	if err != nil {
-		panic("need a number of milliseconds to lag, got: " + os.Args[3])
+	if *wsListenAddr != "" {
+		mws, upgrade := msgs.NewUpgraderMiddleware()
+		mux := http.NewServeMux()
+		mux.HandleFunc("/upgrader", upgrade)
+		go func() {
+			log.Fatal(http.ListenAndServe(*wsListenAddr, mux))
+		}()
+		log.Printf("Listening at %v/upgrader, proxying %v\n", *wsListenAddr, *wsServerAddr)
+		go p.accept(mws, func() (msgs.Msgs, error) {
+			c, _, err := websocket.DefaultDialer.Dial("ws://"+*wsServerAddr+"/upgrader", nil)
+			if err != nil {
+				return nil, err
+			}
+			return msgs.NewWS(c), nil
+		})
 	}
 
-	lag := time.Millisecond * time.Duration(ms)
-	server, err := net.Listen("tcp", proxyAddr)
+	mms, err := msgs.ListenTCP(*listenAddr)
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
 	}
-	log.Printf("Listening at %v\n", proxyAddr)
-	for {
-		conn, err := server.Accept()
-		go func(conn net.Conn) {
-			if err != nil {
-				panic(err)
-			}
-			game, err := net.Dial("tcp", realAddr)
-			if err != nil {
-				panic(err)
-			}
-			client := msgs.New(conn)
-			gameServer := msgs.New(game)
+	log.Printf("Listening at %v, proxying %v\n", mms.Address(), *serverAddr)
+	go p.accept(mms, func() (msgs.Msgs, error) {
+		c, err := net.Dial("tcp", *serverAddr)
+		if err != nil {
+			return nil, err
+		}
+		return msgs.New(c), nil
+	})
 
-			wg := &sync.WaitGroup{}
-			wg.Add(2)
-			go proxy(lag, wg, client, gameServer, "CLIENT -> SERVER")
-			go proxy(lag, wg, gameServer, client, "SERVER -> CLIENT")
-			wg.Wait()
-			gameServer.Close()
-		}(conn)
+	sigint := make(chan os.Signal, 1)
+	signal.Notify(sigint, os.Interrupt)
+	<-sigint
+	if p.capture != nil {
+		if err := p.capture.Close(); err != nil {
+			log.Printf("closing the capture: %v\n", err)
+		}
 	}
 }
 
-func proxy(lag time.Duration, wg *sync.WaitGroup, from, to *msgs.M, logs string) {
-	defer func() {
-		if r := recover(); r != nil {
-			// if e, ok := r.(error); ok {
-			// 	//fmt.Printf("Disconnected [%v]", strings.Join(strings.Split(e.Error(), ":")[:3], ":"))
-			// }
-		}
-		wg.Done()
-	}()
+type Proxy struct {
+	up, down  *Link
+	dropEvery time.Duration
+	capture   *msgs.CaptureWriter
+	conns     atomic.Uint32
+}
+
+func (p *Proxy) accept(mm msgs.MMsgs, dial func() (msgs.Msgs, error)) {
 	for {
-		im, err := from.Read()
+		client, err := mm.NewConn()
 		if err != nil {
-			to.Close()
-			panic(err)
+			log.Fatal(err)
 		}
-		time.Sleep(lag)
-		//log.Printf("%v: %v", logs, im.Event.String())
-		if im.Event.Len() == -1 {
-			err = to.WriteWithLen(im.Event, im.Data)
+		go func() {
+			game, err := dial()
 			if err != nil {
-				panic(err)
+				log.Printf("dial server: %v\n", err)
+				client.Close()
+				return
 			}
-			continue
+			p.proxy(client, game)
+		}()
+	}
+}
+
+// proxy runs until either side closes, or the drop timer says so
+func (p *Proxy) proxy(client, game msgs.Msgs) {
+	id := p.conns.Add(1)
+	var once sync.Once
+	closeBoth := func(why string) {
+		once.Do(func() {
+			log.Printf("[%v] %v closed: %v\n", id, client.IP(), why)
+			client.Close()
+			game.Close()
+		})
+	}
+	log.Printf("[%v] %v connected\n", id, client.IP())
+	if p.dropEvery > 0 {
+		drop := time.AfterFunc(time.Duration(rand.ExpFloat64()*float64(p.dropEvery)), func() {
+			closeBoth("dropped on purpose")
+		})
+		defer drop.Stop()
+	}
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		closeBoth("client: " + pipe(p.up, client, game, p.record(id, msgs.DirUp)).Error())
+	}()
+	go func() {
+		defer wg.Done()
+		closeBoth("server: " + pipe(p.down, game, client, p.record(id, msgs.DirDown)).Error())
+	}()
+	wg.Wait()
+}
+
+// record is what pipe calls with every event, it goes to the capture if there is one
+func (p *Proxy) record(conn uint32, dir msgs.Dir) func(*msgs.IncomingData) {
+	return func(im *msgs.IncomingData) {
+		if p.capture == nil {
+			return
 		}
-		err = to.Write(im.Event, im.Data)
-		if err != nil {
-			panic(err)
+		if err := p.capture.Record(conn, dir, im.Event, im.Data); err != nil {
+			log.Printf("record: %v\n", err)
+		}
+	}
+}
+
+// flushCapture keeps the file close to up to date in case the proxy gets killed
+func (p *Proxy) flushCapture() {
+	for range time.Tick(time.Second) {
+		if err := p.capture.Flush(); err != nil {
+			log.Printf("flush capture: %v\n", err)
 		}
 	}
 }
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/rywk/minigoao/pkg/msgs"
+)
+
+// msgdump prints the events in a capture, like the ones lag-proxy -record writes
+//
+//	go run ./cmd/msgdump -event EPlayerMoved,EMoveOk -player 3 -from 10s -to 1m capture.bin
+//	go run ./cmd/msgdump -raw -dir down -json stream.bin
+func main() {
+	var (
+		raw    = flag.Bool("raw", false, "the file is just events one after the other, like a tcp stream pulled out of a pcap")
+		rawDir = flag.String("dir", "down", "direction of a -raw stream: up (client to server) or down")
+		events = flag.String("event", "", "comma separated events to show, like EPlayerMoved,EMove, empty for all")
+		player = flag.Int("player", -1, "only events about this player id")
+		conn   = flag.Int("conn", -1, "only events of this conn")
+		from   = flag.Duration("from", 0, "skip the events before this time in the capture")
+		to     = flag.Duration("to", 0, "stop at this time in the capture, 0 for the end")
+		asJSON = flag.Bool("json", false, "one json object per event instead of text")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: msgdump [flags] <capture>\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	only, err := parseEvents(*events)
+	if err != nil {
+		log.Fatal(err)
+	}
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	var r *msgs.CaptureReader
+	if *raw {
+		dir := msgs.DirDown
+		if *rawDir == "up" {
+			dir = msgs.DirUp
+		}
+		r = msgs.NewRawCaptureReader(f, dir)
+	} else if r, err = msgs.NewCaptureReader(f); err != nil {
+		log.Fatal(err)
+	}
+	if !*asJSON && !r.Start.IsZero() {
+		fmt.Printf("capture started %v\n", r.Start.Format(time.DateTime))
+	}
+
+	out := json.NewEncoder(os.Stdout)
+	for n := 0; ; n++ {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			log.Fatalf("record %v: %v", n, err)
+		}
+		if *to != 0 && rec.T > *to {
+			return
+		}
+		if rec.T < *from ||
+			(*conn >= 0 && rec.Conn != uint32(*conn)) ||
+			(only != nil && !only[rec.Event]) {
+			continue
+		}
+		data, derr := msgs.Decode(rec.Event, rec.Data)
+		justID := rec.Event == msgs.EPlayerDespawned || rec.Event == msgs.EPlayerLeaveViewport
+		if *player >= 0 && (derr != nil || !mentions(reflect.ValueOf(data), uint16(*player), justID)) {
+			continue
+		}
+		if *asJSON {
+			line := jsonRecord{
+				T:     rec.T.Milliseconds(),
+				Conn:  rec.Conn,
+				Dir:   rec.Dir.String(),
+				Event: rec.Event.String(),
+				Data:  data,
+			}
+			if derr != nil {
+				line.Error, line.Raw = derr.Error(), rec.Data
+			}
+			out.Encode(line)
+			continue
+		}
+		what := describe(data)
+		if derr != nil {
+			what = fmt.Sprintf("bad data (%v) %x", derr, rec.Data)
+		}
+		fmt.Printf("%10.3fs [%v] %-4v %-22v %v\n", rec.T.Seconds(), rec.Conn, rec.Dir, rec.Event, what)
+	}
+}
+
+type jsonRecord struct {
+	T     int64 // ms since the capture started
+	Conn  uint32
+	Dir   string
+	Event string
+	Data  interface{} `json:",omitempty"`
+	Error string      `json:",omitempty"`
+	Raw   []byte      `json:",omitempty"`
+}
+
+func parseEvents(s string) (map[msgs.E]bool, error) {
+	if s == "" {
+		return nil, nil
+	}
+	only := map[msgs.E]bool{}
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		found := false
+		for e := msgs.ENone; e < msgs.ELen; e++ {
+			if strings.EqualFold(e.String(), name) || strings.EqualFold(e.String(), "E"+name) {
+				only[e], found = true, true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown event %q", name)
+		}
+	}
+	return only, nil
+}
+
+func describe(data interface{}) string {
+	if data == nil {
+		return ""
+	}
+	v := reflect.ValueOf(data)
+	if v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	return fmt.Sprintf("%+v", v.Interface())
+}
+
+// mentions is true if the event is about the player id, the ids are
+// the ID and From fields, the Removed ones of snapshots, or the
+// whole event when its just an id (top, for despawns and the like).
+func mentions(v reflect.Value, id uint16, top bool) bool {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return !v.IsNil() && mentions(v.Elem(), id, top)
+	case reflect.Uint16:
+		return top && uint16(v.Uint()) == id
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if mentions(v.Index(i), id, top) {
+				return true
+			}
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Field(i)
+			switch t.Field(i).Name {
+			case "ID", "From":
+				if f.Kind() == reflect.Uint16 && uint16(f.Uint()) == id {
+					return true
+				}
+			case "Removed":
+				if mentions(f, id, true) {
+					return true
+				}
+			default:
+				if f.Kind() == reflect.Slice && mentions(f, id, false) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
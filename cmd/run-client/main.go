@@ -2,6 +2,7 @@ package main
 
 import (
 	_ "embed"
+	"flag"
 
 	"github.com/rywk/minigoao/pkg/client"
 )
@@ -10,6 +11,14 @@ import (
 var config []byte
 
 func main() {
+	replay := flag.String("replay", "", "recording of the server to watch instead of playing")
+	flag.Parse()
+	if *replay != "" {
+		if err := client.RunReplay(*replay); err != nil {
+			panic(err)
+		}
+		return
+	}
 	if err := client.Run(false, string(config)); err != nil {
 		panic(err)
 	}
@@ -9,8 +9,8 @@ import (
 )
 
 func main() {
-	if len(os.Args) != 4 {
-		panic("must provide a port and exposure")
+	if len(os.Args) != 4 && len(os.Args) != 5 {
+		panic("must provide a port and exposure, and optionally a config file")
 	}
 	log.Printf("TCP port: %v", os.Args[1])
 	log.Printf("WEB port: %v", os.Args[2])
@@ -18,7 +18,15 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	if err := server.NewServer(os.Args[1], os.Args[2]).Start(exposed); err != nil {
+	cfgPath := "./server.json"
+	if len(os.Args) == 5 {
+		cfgPath = os.Args[4]
+	}
+	cfg, err := server.LoadConfig(cfgPath)
+	if err != nil {
+		panic(err)
+	}
+	if err := server.NewServer(os.Args[1], os.Args[2], cfg).Start(exposed); err != nil {
 		panic(err)
 	}
 }
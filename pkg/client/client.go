@@ -21,3 +21,16 @@ func Run(web bool, serverAddr string) error {
 	ebiten.SetWindowIcon([]image.Image{icon})
 	return ebiten.RunGame(g)
 }
+
+// RunReplay opens a window that plays the recording at path
+func RunReplay(path string) error {
+	icon := texture.Decode(Icon_png)
+	g := game.NewGame(false, "")
+	if err := g.StartReplay(path); err != nil {
+		return err
+	}
+	ebiten.SetWindowSize(game.ScreenWidth, game.ScreenHeight)
+	ebiten.SetWindowIcon([]image.Image{icon})
+	ebiten.SetWindowTitle("minigoao replay")
+	return ebiten.RunGame(g)
+}
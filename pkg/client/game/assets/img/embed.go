@@ -61,6 +61,25 @@ var (
 	//go:embed dead_head.png
 	DeadHead_png []byte
 
+	// NPCs
+
+	//go:embed skeleton_body.png
+	SkeletonBody_png []byte
+	//go:embed skeleton_head.png
+	SkeletonHead_png []byte
+	//go:embed skeleton_dead_body.png
+	SkeletonDeadBody_png []byte
+	//go:embed skeleton_dead_head.png
+	SkeletonDeadHead_png []byte
+	//go:embed dark_knight_body.png
+	DarkKnightBody_png []byte
+	//go:embed dark_knight_head.png
+	DarkKnightHead_png []byte
+	//go:embed dark_knight_dead_body.png
+	DarkKnightDeadBody_png []byte
+	//go:embed dark_knight_dead_head.png
+	DarkKnightDeadHead_png []byte
+
 	// Helmets
 
 	//go:embed hat_pro.png
@@ -0,0 +1,128 @@
+package game
+
+import (
+	"math"
+	"sort"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/rywk/minigoao/pkg/client/game/player"
+	"github.com/rywk/minigoao/pkg/constants"
+	"github.com/rywk/minigoao/pkg/typ"
+)
+
+// Camera is for when we are not playing, it goes wherever the
+// keys take it or follows a player around (tab or a click picks one).
+// It moves g.player, the player nobody sees, so the map, the sounds
+// and the rest still go around it like in the game.
+type Camera struct {
+	g      *Game
+	follow uint16 // 0 is free
+	at     typ.P  // tile of the last Moved
+}
+
+// CameraSpeed is in pixels per frame, shift goes twice as fast
+const CameraSpeed = 8
+
+func NewCamera(g *Game) *Camera {
+	return &Camera{g: g, at: typ.P{X: g.player.X, Y: g.player.Y}}
+}
+
+// Following is the player we follow, nil if the camera is free
+func (c *Camera) Following() *player.P {
+	if c.follow == 0 {
+		return nil
+	}
+	return c.g.players[c.follow]
+}
+
+func (c *Camera) Update() {
+	if inpututil.IsKeyJustPressed(ebiten.KeyTab) {
+		c.next()
+	}
+	c.g.mouseX, c.g.mouseY = ebiten.CursorPosition()
+	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) && !c.g.chat.Over(c.g.mouseX, c.g.mouseY) {
+		// click someone to follow them
+		if t := c.g.HoveredTile(); !t.Out(c.g.world.Space.Rect) {
+			if id := c.g.world.Space.GetSlot(0, t); c.g.players[id] != nil {
+				c.follow = id
+			}
+		}
+	}
+	dx, dy := 0.0, 0.0
+	if ebiten.IsKeyPressed(ebiten.KeyArrowLeft) || ebiten.IsKeyPressed(ebiten.KeyA) {
+		dx--
+	}
+	if ebiten.IsKeyPressed(ebiten.KeyArrowRight) || ebiten.IsKeyPressed(ebiten.KeyD) {
+		dx++
+	}
+	if ebiten.IsKeyPressed(ebiten.KeyArrowUp) || ebiten.IsKeyPressed(ebiten.KeyW) {
+		dy--
+	}
+	if ebiten.IsKeyPressed(ebiten.KeyArrowDown) || ebiten.IsKeyPressed(ebiten.KeyS) {
+		dy++
+	}
+	cam := c.g.player
+	if dx != 0 || dy != 0 {
+		// moving it by hand lets go of whoever we followed
+		c.follow = 0
+		speed := float64(CameraSpeed)
+		if ebiten.IsKeyPressed(ebiten.KeyShift) {
+			speed *= 2
+		}
+		cam.Pos[0] += dx * speed
+		cam.Pos[1] += dy * speed
+	} else if p := c.Following(); p != nil {
+		cam.Pos = p.Pos
+	} else {
+		c.follow = 0
+	}
+	rect := c.g.world.Space.Rect
+	cam.Pos[0] = max(0, min(cam.Pos[0], float64(rect.Max.X-1)*constants.TileSize))
+	cam.Pos[1] = max(0, min(cam.Pos[1], float64(rect.Max.Y-1)*constants.TileSize))
+	cam.X = int32(math.Round(cam.Pos[0] / constants.TileSize))
+	cam.Y = int32(math.Round(cam.Pos[1] / constants.TileSize))
+}
+
+// Moved is the tile the camera is on, ok if it changed since the last time
+func (c *Camera) Moved() (typ.P, bool) {
+	at := typ.P{X: c.g.player.X, Y: c.g.player.Y}
+	if at == c.at {
+		return at, false
+	}
+	c.at = at
+	return at, true
+}
+
+// Status is what the camera is doing, to show it
+func (c *Camera) Status() string {
+	if p := c.Following(); p != nil {
+		return "following " + p.Nick
+	}
+	return "free camera"
+}
+
+// next follows the player after the one we follow, by id
+func (c *Camera) next() {
+	ids := make([]uint16, 0, len(c.g.players))
+	for id := range c.g.players {
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		c.follow = 0
+		return
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	i := sort.Search(len(ids), func(i int) bool { return ids[i] > c.follow })
+	c.follow = ids[i%len(ids)]
+}
+
+// drawWatching draws the world and the players around the camera, without us
+func (g *Game) drawWatching(screen *ebiten.Image) {
+	g.world.Draw(g.CameraOrigin())
+	cam := g.world.Origin
+	for _, p := range g.playersY {
+		p.Draw(g.world.Image(), cam)
+	}
+	g.Render(g.world.Image(), screen)
+}
@@ -0,0 +1,225 @@
+package game
+
+import (
+	"fmt"
+	"image/color"
+	"strings"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/rywk/minigoao/pkg/client/game/text"
+	"github.com/rywk/minigoao/pkg/msgs"
+)
+
+// ChatLine is a line of the log, a message of any channel
+// or something that happened to us
+type ChatLine struct {
+	At      time.Time
+	Channel msgs.ChatChannel
+	Nick    string
+	To      string // whispers we sent
+	Msg     string
+}
+
+func (l *ChatLine) String() string {
+	switch {
+	case l.Channel == msgs.ChatSystem || l.Channel == msgs.ChatCombat:
+		return l.Msg
+	case l.To != "":
+		return fmt.Sprintf("[to %v] %v", l.To, l.Msg)
+	case l.Channel == msgs.ChatLocal:
+		return fmt.Sprintf("%v: %v", l.Nick, l.Msg)
+	}
+	return fmt.Sprintf("[%v] %v: %v", l.Channel, l.Nick, l.Msg)
+}
+
+var chatColors = [msgs.ChatLen]color.Color{
+	color.RGBA{230, 230, 230, 255}, // local
+	color.RGBA{110, 190, 255, 255}, // global
+	color.RGBA{110, 230, 120, 255}, // party
+	color.RGBA{235, 130, 235, 255}, // whisper
+	color.RGBA{240, 200, 70, 255},  // system
+	color.RGBA{235, 95, 80, 255},   // combat
+}
+
+func ChatColor(c msgs.ChatChannel) color.Color {
+	if c >= msgs.ChatLen {
+		return chatColors[msgs.ChatSystem]
+	}
+	return chatColors[c]
+}
+
+// ChatTab is a filter of the log by channel
+type ChatTab struct {
+	Name     string
+	channels []msgs.ChatChannel // nil is all of them
+}
+
+func (t *ChatTab) Has(c msgs.ChatChannel) bool {
+	if t.channels == nil {
+		return true
+	}
+	for _, tc := range t.channels {
+		if tc == c {
+			return true
+		}
+	}
+	return false
+}
+
+var ChatTabs = []ChatTab{
+	{Name: "All"},
+	{Name: "Chat", channels: []msgs.ChatChannel{msgs.ChatLocal, msgs.ChatGlobal, msgs.ChatParty, msgs.ChatWhisper, msgs.ChatSystem}},
+	{Name: "Party", channels: []msgs.ChatChannel{msgs.ChatParty}},
+	{Name: "Whisper", channels: []msgs.ChatChannel{msgs.ChatWhisper}},
+	{Name: "Combat", channels: []msgs.ChatChannel{msgs.ChatCombat}},
+}
+
+// logLine is a line as the panel shows it, a ChatLine can take many
+type logLine struct {
+	at      time.Time
+	channel msgs.ChatChannel
+	text    string
+	first   bool // the first line of the message has the time
+}
+
+// Chat is the log panel over the hud, it keeps the last ChatKeep lines,
+// the tabs filter them, the wheel (or page up and down) goes back in them.
+type Chat struct {
+	lines  []logLine
+	tab    int
+	scroll int // lines back from the newest of the tab
+	// where it was drawn last, for the mouse
+	x, y, w, h int
+	bg         *ebiten.Image
+}
+
+const (
+	// ChatKeep is how many lines we remember
+	ChatKeep = 500
+	// ChatShow is how many lines fit in the panel
+	ChatShow  = 8
+	ChatLineH = 16
+	ChatTabW  = 60
+	// ChatCols is how many characters fit in a line after the time
+	ChatCols = 70
+	ChatW    = 6*(ChatCols+9) + 8
+)
+
+func NewChat() *Chat {
+	bg := ebiten.NewImage(ChatW, ChatLineH*(ChatShow+1)+6)
+	bg.Fill(color.RGBA{0, 0, 0, 110})
+	return &Chat{bg: bg}
+}
+
+// Add puts the message at the end, one line per line of the message
+// and the ones too long for the panel are cut in more lines.
+func (c *Chat) Add(l ChatLine) {
+	if l.At.IsZero() {
+		l.At = time.Now()
+	}
+	first := true
+	for _, msg := range strings.Split(l.String(), "\n") {
+		// cut in characters, not bytes, so nothing gets split in half
+		rs := []rune(msg)
+		for {
+			line := rs[:min(len(rs), ChatCols)]
+			c.add(logLine{at: l.At, channel: l.Channel, text: string(line), first: first})
+			first = false
+			if len(rs) <= ChatCols {
+				break
+			}
+			rs = rs[ChatCols:]
+		}
+	}
+}
+
+func (c *Chat) add(l logLine) {
+	c.lines = append(c.lines, l)
+	if c.scroll > 0 && ChatTabs[c.tab].Has(l.channel) {
+		// keep looking at the same lines
+		c.scroll++
+	}
+	if over := len(c.lines) - ChatKeep; over > 0 {
+		c.lines = append(c.lines[:0], c.lines[over:]...)
+	}
+}
+
+// Combat logs something that happened to us
+func (c *Chat) Combat(format string, a ...interface{}) {
+	c.Add(ChatLine{Channel: msgs.ChatCombat, Msg: fmt.Sprintf(format, a...)})
+}
+
+// Over is true when x, y is on the panel
+func (c *Chat) Over(x, y int) bool {
+	return x >= c.x && x < c.x+c.w && y >= c.y && y < c.y+c.h
+}
+
+// tabLines are the lines of the current tab
+func (c *Chat) tabLines() []*logLine {
+	tab := &ChatTabs[c.tab]
+	ls := []*logLine{}
+	for i := range c.lines {
+		if tab.Has(c.lines[i].channel) {
+			ls = append(ls, &c.lines[i])
+		}
+	}
+	return ls
+}
+
+func (c *Chat) Update(mouseX, mouseY int) {
+	scroll := 0
+	if c.Over(mouseX, mouseY) {
+		if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) && mouseY < c.y+ChatLineH {
+			if t := (mouseX - c.x) / ChatTabW; t < len(ChatTabs) {
+				c.tab, c.scroll = t, 0
+			}
+		}
+		_, dy := ebiten.Wheel()
+		switch {
+		case dy > 0:
+			scroll = 1
+		case dy < 0:
+			scroll = -1
+		}
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyPageUp) {
+		scroll = ChatShow
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyPageDown) {
+		scroll = -ChatShow
+	}
+	if scroll != 0 {
+		c.scroll = max(0, min(c.scroll+scroll, len(c.tabLines())-ChatShow))
+	}
+}
+
+// Draw puts the panel with its bottom left corner at x, bottom
+func (c *Chat) Draw(screen *ebiten.Image, x, bottom int) {
+	c.w, c.h = c.bg.Bounds().Dx(), c.bg.Bounds().Dy()
+	c.x, c.y = x, bottom-c.h
+	op := &ebiten.DrawImageOptions{}
+	op.GeoM.Translate(float64(c.x), float64(c.y))
+	screen.DrawImage(c.bg, op)
+	for i, t := range ChatTabs {
+		col := color.Color(color.RGBA{140, 140, 140, 255})
+		if i == c.tab {
+			col = color.White
+		}
+		text.PrintColAt(screen, t.Name, c.x+4+i*ChatTabW, c.y+2, col)
+	}
+	if c.scroll > 0 {
+		text.PrintColAt(screen, fmt.Sprintf("%v newer", c.scroll), c.x+c.w-90, c.y+2, color.RGBA{240, 200, 70, 255})
+	}
+	ls := c.tabLines()
+	y := bottom - ChatLineH - 2
+	for i := len(ls) - 1 - c.scroll; i >= 0 && i >= len(ls)-c.scroll-ChatShow; i-- {
+		l := ls[i]
+		if l.first {
+			text.PrintColAt(screen, l.at.Format("15:04:05"), c.x+4, y, color.RGBA{140, 140, 140, 255})
+		}
+		text.PrintColAt(screen, l.text, c.x+4+6*9, y, ChatColor(l.channel))
+		y -= ChatLineH
+	}
+}
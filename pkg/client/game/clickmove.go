@@ -0,0 +1,99 @@
+package game
+
+import (
+	"errors"
+	"image/color"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/rywk/minigoao/pkg/constants"
+	"github.com/rywk/minigoao/pkg/constants/direction"
+	"github.com/rywk/minigoao/pkg/pathfind"
+	"github.com/rywk/minigoao/pkg/typ"
+)
+
+// ClickMove walks the player to the clicked tile, the path is found on
+// our copy of the map and every step goes through TryMove like the
+// keyboard ones, so the server still says yes or no to each of them.
+type ClickMove struct {
+	g         *Game
+	finder    *pathfind.Finder
+	route     []typ.P
+	clickDown bool
+
+	drawOp  *ebiten.DrawImageOptions
+	markImg *ebiten.Image
+}
+
+func NewClickMove(g *Game) *ClickMove {
+	c := &ClickMove{
+		g: g,
+		// no cache, other players move and the map chunks come and go
+		finder:  pathfind.NewFinder(g.world.Space, 0),
+		drawOp:  &ebiten.DrawImageOptions{},
+		markImg: ebiten.NewImage(constants.TileSize, constants.TileSize),
+	}
+	c.markImg.Fill(color.RGBA{255, 255, 255, 50})
+	return c
+}
+
+func (c *ClickMove) Cancel() {
+	c.route = nil
+}
+
+func (c *ClickMove) Walking() bool {
+	return len(c.route) != 0
+}
+
+// Listen starts a new route when the move input is clicked on a tile.
+func (c *ClickMove) Listen() {
+	if c.g.keys.keysLocked || !c.g.keys.cfg.ClickMove.IsPressed() {
+		c.clickDown = false
+		return
+	}
+	if c.clickDown {
+		return
+	}
+	c.clickDown = true
+	// clicks on the hud arent on the map
+	if c.g.mouseY >= int(c.g.stats.y) {
+		return
+	}
+	from := typ.P{X: c.g.player.X, Y: c.g.player.Y}
+	path, err := c.finder.Find(from, c.g.HoveredTile(), pathfind.Options{AvoidOccupied: true})
+	if err != nil && !errors.Is(err, pathfind.ErrBudget) {
+		c.Cancel()
+		return
+	}
+	c.route = path
+}
+
+// Next is the next step of the route, ok is false when there is
+// nothing to walk or the route got cancelled.
+func (c *ClickMove) Next() (next typ.P, ok bool) {
+	if len(c.route) == 0 {
+		return next, false
+	}
+	from := typ.P{X: c.g.player.X, Y: c.g.player.Y}
+	next = c.route[0]
+	if c.g.player.Inmobilized ||
+		pathfind.Direction(from, next) == direction.Still ||
+		pathfind.Step(from, pathfind.Direction(from, next)) != next ||
+		c.g.world.Space.GetSlot(0, next) != 0 ||
+		c.g.world.Space.GetSlot(1, next) != 0 {
+		c.Cancel()
+		return next, false
+	}
+	c.route = c.route[1:]
+	return next, true
+}
+
+func (c *ClickMove) Draw(screen *ebiten.Image) {
+	if len(c.route) == 0 {
+		return
+	}
+	cam := c.g.CameraOrigin()
+	to := c.route[len(c.route)-1]
+	c.drawOp.GeoM.Reset()
+	c.drawOp.GeoM.Translate(float64(to.X*constants.TileSize)-cam[0], float64(to.Y*constants.TileSize)-cam[1])
+	screen.DrawImage(c.markImg, c.drawOp)
+}
@@ -0,0 +1,50 @@
+package game
+
+import "time"
+
+// InterpDelay is how far behind the server clock we draw the other players (ms),
+// enough to usually have the next step before its time to walk it.
+const InterpDelay = 100
+
+// ServerClock guesses the server time from the times in its events,
+// the offset is the smallest one we saw, the message that was the least late.
+type ServerClock struct {
+	start  time.Time
+	offset int64 // local ms - server ms
+	synced bool
+}
+
+func NewServerClock() *ServerClock {
+	return &ServerClock{start: time.Now()}
+}
+
+func (c *ServerClock) local() int64 {
+	return time.Since(c.start).Milliseconds()
+}
+
+// Observe takes the server time of an event we just got
+func (c *ServerClock) Observe(t uint32) {
+	off := c.local() - int64(t)
+	if !c.synced || off < c.offset {
+		c.offset, c.synced = off, true
+		return
+	}
+	// creep up slowly, if the route got slower the smallest
+	// offset would have us drawing ahead of what we have forever
+	c.offset += (off - c.offset) / 64
+}
+
+// Now is our guess of the server clock
+func (c *ServerClock) Now() uint32 {
+	return uint32(c.local() - c.offset)
+}
+
+// Render is the server time the other players are drawn at, 0 until
+// we know the server clock. The server takes 0 as now, without it a
+// cast would be judged as far back in time as it allows.
+func (c *ServerClock) Render() uint32 {
+	if !c.synced {
+		return 0
+	}
+	return c.Now() - InterpDelay
+}
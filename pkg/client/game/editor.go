@@ -0,0 +1,181 @@
+package game
+
+import (
+	"fmt"
+	"image/color"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/rywk/minigoao/pkg/client/game/text"
+	"github.com/rywk/minigoao/pkg/constants"
+	"github.com/rywk/minigoao/pkg/constants/assets"
+	"github.com/rywk/minigoao/pkg/constants/npc"
+	"github.com/rywk/minigoao/pkg/msgs"
+	"github.com/rywk/minigoao/pkg/typ"
+)
+
+// the palettes are what assets.GroundTiles and StuffTiles allow,
+// the server refuses anything else
+type paletteItem struct {
+	a    assets.Image
+	name string
+}
+
+var (
+	groundPalette = []paletteItem{
+		{assets.Grass, "Grass"},
+		{assets.Tiletest, "Tiletest"},
+		{assets.Nothing, "Nothing"},
+	}
+	stuffPalette = []paletteItem{
+		{assets.Shroom, "Shroom"},
+		{assets.Nothing, "Nothing"},
+	}
+	editorTools = [msgs.MapEditLen]string{
+		"Ground",
+		"Stuff",
+		"Block",
+		"Spawn",
+		"NPC",
+	}
+)
+
+// Editor lets admins paint the map with the mouse, every edit goes
+// to the server and comes back as a EMapTile like for everyone else.
+type Editor struct {
+	g  *Game
+	On bool
+
+	tool      msgs.MapEditOp
+	ground    int
+	stuff     int
+	npc       npc.Type
+	clickDown bool
+	lastTile  typ.P
+
+	drawOp    *ebiten.DrawImageOptions
+	blockImg  *ebiten.Image
+	spawnImg  *ebiten.Image
+	npcImg    *ebiten.Image
+	cursorImg *ebiten.Image
+}
+
+func NewEditor(g *Game) *Editor {
+	e := &Editor{
+		g:         g,
+		drawOp:    &ebiten.DrawImageOptions{},
+		blockImg:  ebiten.NewImage(constants.TileSize, constants.TileSize),
+		spawnImg:  ebiten.NewImage(constants.TileSize, constants.TileSize),
+		npc:       npc.Skeleton,
+		npcImg:    ebiten.NewImage(constants.TileSize, constants.TileSize),
+		cursorImg: ebiten.NewImage(constants.TileSize, constants.TileSize),
+	}
+	e.blockImg.Fill(color.RGBA{200, 0, 0, 70})
+	e.spawnImg.Fill(color.RGBA{0, 90, 220, 110})
+	e.npcImg.Fill(color.RGBA{150, 0, 170, 110})
+	e.cursorImg.Fill(color.RGBA{255, 255, 255, 60})
+	return e
+}
+
+func (e *Editor) Update() {
+	if e.g.role != msgs.RoleAdmin || e.g.keys.keysLocked {
+		return
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyF2) {
+		e.On = !e.On
+	}
+	if !e.On {
+		return
+	}
+	for i, k := range []ebiten.Key{ebiten.Key1, ebiten.Key2, ebiten.Key3, ebiten.Key4, ebiten.Key5} {
+		if inpututil.IsKeyJustPressed(k) {
+			e.tool = msgs.MapEditOp(i)
+		}
+	}
+	next := 0
+	if inpututil.IsKeyJustPressed(ebiten.KeyE) {
+		next = 1
+	} else if inpututil.IsKeyJustPressed(ebiten.KeyQ) {
+		next = -1
+	}
+	switch e.tool {
+	case msgs.MapEditGround:
+		e.ground = (e.ground + next + len(groundPalette)) % len(groundPalette)
+	case msgs.MapEditStuff:
+		e.stuff = (e.stuff + next + len(stuffPalette)) % len(stuffPalette)
+	case msgs.MapEditNPC:
+		// skip npc.None
+		types := int(npc.Len) - 1
+		e.npc = npc.Type((int(e.npc)-1+next+types)%types) + 1
+	}
+
+	if !ebiten.IsMouseButtonPressed(ebiten.MouseButtonLeft) || e.g.mouseY >= ScreenHeight-64 {
+		e.clickDown = false
+		return
+	}
+	tile := e.g.HoveredTile()
+	// holding the click paints every new tile we go over, but only once
+	if e.clickDown && tile == e.lastTile {
+		return
+	}
+	e.clickDown = true
+	e.lastTile = tile
+	if tile.Out(e.g.world.Space.Rect) {
+		return
+	}
+	edit := &msgs.EventMapEdit{Op: e.tool, Pos: tile}
+	switch e.tool {
+	case msgs.MapEditGround:
+		edit.Asset = groundPalette[e.ground].a
+	case msgs.MapEditStuff:
+		edit.Asset = stuffPalette[e.stuff].a
+	case msgs.MapEditNPC:
+		edit.Asset = uint32(e.npc)
+	}
+	e.g.outQueue <- &GameMsg{E: msgs.EMapEdit, Data: edit}
+}
+
+func (e *Editor) Draw(screen *ebiten.Image) {
+	if !e.On {
+		return
+	}
+	cam := e.g.CameraOrigin()
+	minX, minY := int32(cam[0])/constants.TileSize, int32(cam[1])/constants.TileSize
+	maxX, maxY := minX+ScreenWidth/constants.TileSize+1, minY+ScreenHeight/constants.TileSize+1
+	for y := minY; y <= maxY; y++ {
+		for x := minX; x <= maxX; x++ {
+			p := typ.P{X: x, Y: y}
+			if p.Out(e.g.world.Space.Rect) {
+				continue
+			}
+			e.drawOp.GeoM.Reset()
+			e.drawOp.GeoM.Translate(float64(x*constants.TileSize)-cam[0], float64(y*constants.TileSize)-cam[1])
+			if e.g.world.Blocked(p) {
+				screen.DrawImage(e.blockImg, e.drawOp)
+			}
+			if e.g.world.IsSpawn(p) {
+				screen.DrawImage(e.spawnImg, e.drawOp)
+			}
+			if t := e.g.world.NPCAt(p); t != npc.None {
+				screen.DrawImage(e.npcImg, e.drawOp)
+				text.PrintAt(screen, t.String(), int(float64(x*constants.TileSize)-cam[0]), int(float64(y*constants.TileSize)-cam[1]))
+			}
+		}
+	}
+	hovered := e.g.HoveredTile()
+	e.drawOp.GeoM.Reset()
+	e.drawOp.GeoM.Translate(float64(hovered.X*constants.TileSize)-cam[0], float64(hovered.Y*constants.TileSize)-cam[1])
+	screen.DrawImage(e.cursorImg, e.drawOp)
+
+	selected := ""
+	switch e.tool {
+	case msgs.MapEditGround:
+		selected = groundPalette[e.ground].name
+	case msgs.MapEditStuff:
+		selected = stuffPalette[e.stuff].name
+	case msgs.MapEditNPC:
+		selected = e.npc.String()
+	}
+	text.PrintAtBg(screen, fmt.Sprintf("EDITOR [1-5] %v %v  [Q/E] change  [F2] exit  %v,%v",
+		editorTools[e.tool], selected, hovered.X, hovered.Y), HalfScreenX-200, 20)
+}
@@ -30,6 +30,7 @@ import (
 	"github.com/rywk/minigoao/pkg/constants/direction"
 	"github.com/rywk/minigoao/pkg/constants/spell"
 	"github.com/rywk/minigoao/pkg/msgs"
+	"github.com/rywk/minigoao/pkg/pathfind"
 	"github.com/rywk/minigoao/pkg/typ"
 	"golang.org/x/image/math/f64"
 )
@@ -40,15 +41,19 @@ const (
 	ModeRegister Mode = iota
 	ModeGame
 	ModeOptions
+	ModeReplay
+	ModeSpectate
 )
 
 type YSortable interface {
 	ValueY() float64
-	Draw(*ebiten.Image)
+	// Draw on an image that has its top left at the world pixel cam
+	Draw(*ebiten.Image, f64.Vec2)
 }
 
 type Login struct {
 	data *msgs.EventPlayerLogin
+	ms   msgs.Msgs
 	err  error
 }
 
@@ -73,22 +78,37 @@ type Game struct {
 	serverTyper                *typing.Typer
 	typingServer               bool
 	nickTyper                  *typing.Typer
+	passTyper                  *typing.Typer
+	typingPass                 bool
 	fsBtn                      *Checkbox
 	vsyncBtn                   *Checkbox
+	snapBtn                    *Checkbox
+	spectateBtn                *Checkbox
 	inputBox                   *ebiten.Image
 	fullscreen                 bool
 	vsync                      bool
+	snapshots                  bool
+	spectate                   bool
 	connErrorColorStart        int
+	connError                  string
 
 	// game
 	mouseX, mouseY int
 	latency        string
 	onlines        string
+	mutedUntil     time.Time
 	counter        int
 	ms             msgs.Msgs
 	world          *Map
 	sessionID      uint32
-	players        [constants.MaxConnCount]*player.P
+	role           msgs.Role
+	editor         *Editor
+	clickMove      *ClickMove
+	snaps          *Snapshots
+	clock          *ServerClock
+	resumeToken    string
+	reconnect      *reconnect
+	players        map[uint16]*player.P
 	playersY       []YSortable
 	player         *player.P
 	outQueue       chan *GameMsg
@@ -103,16 +123,20 @@ type Game struct {
 	dirOverwriteAttempt            bool
 	dirOverwriteAttemptLeftForMove float64
 
-	lastMove          time.Time
-	leftForMove       float64 // pixels left to complete tile change
-	lastDir           direction.D
-	soundPrevWalk     int
-	startForStep      time.Time
-	lastMoveConfirmed bool
+	lastMove      time.Time
+	leftForMove   float64 // pixels left to complete tile change
+	lastDir       direction.D
+	soundPrevWalk int
+	startForStep  time.Time
 
 	lastPotionUsed msgs.Item
 
-	steps []player.Step
+	predict *Prediction
+	chat    *Chat
+
+	// watching a recording instead of playing
+	replay *Replay
+	camera *Camera
 
 	SoundBoard audio2d.AudioMixer
 
@@ -148,6 +172,7 @@ func NewGame(web bool, serverAddr string) *Game {
 		web:         web,
 		connected:   make(chan Login),
 		nickTyper:   typing.NewTyper(),
+		passTyper:   typing.NewTyper(),
 		serverTyper: typing.NewTyper(serverAddr),
 		worldImgOp:  &ebiten.DrawImageOptions{},
 		inputBox:    texture.Decode(img.InputBox_png),
@@ -158,6 +183,8 @@ func NewGame(web bool, serverAddr string) *Game {
 	g.fsBtn = NewCheckbox(g)
 	g.vsyncBtn = NewCheckbox(g)
 	g.vsyncBtn.On = false
+	g.snapBtn = NewCheckbox(g)
+	g.spectateBtn = NewCheckbox(g)
 	g.SoundBoard = audio2d.NewSoundBoard(web)
 	return g
 }
@@ -169,6 +196,12 @@ func (g *Game) Update() error {
 	case ModeGame:
 		g.updateGame()
 	case ModeOptions:
+	case ModeReplay:
+		if err := g.updateReplay(); err != nil {
+			return err
+		}
+	case ModeSpectate:
+		g.updateSpectate()
 	}
 	if !g.debug {
 		return nil
@@ -187,6 +220,10 @@ func (g *Game) Draw(screen *ebiten.Image) {
 	case ModeGame:
 		g.drawGame(screen)
 	case ModeOptions:
+	case ModeReplay:
+		g.drawReplay(screen)
+	case ModeSpectate:
+		g.drawSpectate(screen)
 	}
 	if !g.debug {
 		return
@@ -226,11 +263,22 @@ func (g *Game) updateRegister() {
 	}
 	g.fullscreen = g.fsBtn.On
 	g.fsBtn.Update()
+	g.snapshots = g.snapBtn.On
+	g.snapBtn.Update()
+	g.spectate = g.spectateBtn.On
+	g.spectateBtn.Update()
 	// g.vsync = g.vsyncBtn.On
 	// g.vsyncBtn.Update()
+	if inpututil.IsKeyJustPressed(ebiten.KeyTab) {
+		g.typingPass = !g.typingPass
+	}
 	if g.typingServer {
 		g.serverTyper.Update()
+	} else if g.typingPass {
+		g.nickTyper.StopCursor()
+		g.passTyper.Update()
 	} else {
+		g.passTyper.StopCursor()
 		g.nickTyper.Update()
 	}
 
@@ -242,7 +290,12 @@ func (g *Game) updateRegister() {
 		g.connecting = false
 		if login.err != nil {
 			log.Println(login.err)
-			g.connErrorColorStart = 255
+			g.showConnError(login.err)
+			return
+		}
+		g.ms = login.ms
+		if login.data.Spectator {
+			g.StartSpectate(login.data)
 			return
 		}
 		g.StartGame(login.data)
@@ -251,15 +304,16 @@ func (g *Game) updateRegister() {
 
 	r := strings.NewReplacer("\n", "", " ", "")
 	nickText := g.nickTyper.String()
+	passText := g.passTyper.String()
 	addressText := g.serverTyper.String()
-	if !strings.HasSuffix(nickText, "\n") && !strings.HasSuffix(addressText, "\n") {
-		g.nickTyper.Text, g.serverTyper.Text = r.Replace(nickText), r.Replace(addressText)
+	if !strings.HasSuffix(nickText, "\n") && !strings.HasSuffix(passText, "\n") && !strings.HasSuffix(addressText, "\n") {
+		g.nickTyper.Text, g.passTyper.Text, g.serverTyper.Text = r.Replace(nickText), r.Replace(passText), r.Replace(addressText)
 		return
 	}
-	g.nickTyper.Text, g.serverTyper.Text = r.Replace(nickText), r.Replace(addressText)
+	g.nickTyper.Text, g.passTyper.Text, g.serverTyper.Text = r.Replace(nickText), r.Replace(passText), r.Replace(addressText)
 	if g.nickTyper.Text != "" && g.serverTyper.Text != "" {
 		g.connecting = true
-		go g.Connect(g.nickTyper.Text, g.serverTyper.Text)
+		go g.Connect(g.nickTyper.Text, g.passTyper.Text, g.serverTyper.Text, "", g.connected)
 	}
 }
 
@@ -270,50 +324,72 @@ func (g *Game) drawRegister(screen *ebiten.Image) {
 	op.GeoM.Translate(HalfScreenX-150, HalfScreenY-55)
 	screen.DrawImage(g.inputBox, op)
 	g.nickTyper.Draw(screen, HalfScreenX-130, HalfScreenY-42)
-	text.PrintBigAt(screen, "Fullscreen", HalfScreenX-95, HalfScreenY+93)
-	g.fsBtn.Draw(screen, HalfScreenX+46, HalfScreenY+92)
+	text.PrintBigAt(screen, "Password", HalfScreenX-144, HalfScreenY+10)
+	text.PrintAt(screen, "(only for accounts, tab to switch)", HalfScreenX-30, HalfScreenY+20)
+	op = &ebiten.DrawImageOptions{}
+	op.GeoM.Translate(HalfScreenX-150, HalfScreenY+50)
+	screen.DrawImage(g.inputBox, op)
+	masked := typing.NewTyper(strings.Repeat("*", len(g.passTyper.Text)))
+	masked.Counter = g.passTyper.Counter
+	masked.Draw(screen, HalfScreenX-130, HalfScreenY+63)
+	text.PrintBigAt(screen, "Fullscreen", HalfScreenX-95, HalfScreenY+163)
+	g.fsBtn.Draw(screen, HalfScreenX+46, HalfScreenY+162)
+	text.PrintBigAt(screen, "Snapshots", HalfScreenX-95, HalfScreenY+193)
+	g.snapBtn.Draw(screen, HalfScreenX+46, HalfScreenY+192)
+	text.PrintBigAt(screen, "Spectate", HalfScreenX-95, HalfScreenY+223)
+	g.spectateBtn.Draw(screen, HalfScreenX+46, HalfScreenY+222)
 	// text.PrintBigAt(screen, "Vsync", HalfScreenX-95, HalfScreenY+135)
 	// g.vsyncBtn.Draw(screen, HalfScreenX+46, HalfScreenY+132)
 	if g.connErrorColorStart > 0 {
-		text.PrintBigAtCol(screen, "Server offline", HalfScreenX-90, HalfScreenY+5, color.RGBA{178, 0, 16, uint8(g.connErrorColorStart)})
+		text.PrintBigAtCol(screen, g.connError, HalfScreenX-6-6*len(g.connError), HalfScreenY+115, color.RGBA{178, 0, 16, uint8(g.connErrorColorStart)})
 	}
 }
 
 func (g *Game) drawGame(screen *ebiten.Image) {
 
 	g.mouseX, g.mouseY = ebiten.CursorPosition()
-	g.world.Draw(typ.P{X: g.player.X, Y: g.player.Y})
+	g.world.Draw(g.CameraOrigin())
+	cam := g.world.Origin
 	for _, p := range g.playersY {
 		if p == nil {
 			continue
 		}
-		p.Draw(g.world.Image())
+		p.Draw(g.world.Image(), cam)
 	}
-	g.keys.DrawChat(g.world.Image(), int(g.player.Pos[0]+16), int(g.player.Pos[1]-40))
+	g.keys.DrawChat(g.world.Image(), int(g.player.Pos[0]+16-cam[0]), int(g.player.Pos[1]-40-cam[1]))
 	g.Render(g.world.Image(), screen)
+	g.clickMove.Draw(screen)
+	g.editor.Draw(screen)
 	g.stats.Draw(screen)
+	// over the zone name
+	g.chat.Draw(screen, 4, int(g.stats.y)-18)
 	text.PrintAt(screen, fmt.Sprintf("%vFPS\n%v", int(ebiten.ActualFPS()), g.latency), 0, 0)
 	text.PrintAt(screen, fmt.Sprintf("Online: %v", g.onlines), 50, 0)
+	g.drawReconnect(screen)
 }
 
 func (g *Game) updateGame() error {
 	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
-		g.Clear()
-		g.nickTyper = typing.NewTyper()
-		g.mode = ModeRegister
-		ebiten.SetFullscreen(false)
 		g.ms.Close()
-		return errors.New("esc exit")
+		return g.backToRegister(errors.New("esc exit"))
 	}
-	if err := g.ProcessEventQueue(); err != nil {
-		g.Clear()
-		g.nickTyper = typing.NewTyper()
-		g.mode = ModeRegister
-		ebiten.SetFullscreen(false)
-		return err
+	if g.reconnect == nil {
+		if err := g.ProcessEventQueue(); err != nil {
+			if g.resumeToken == "" || errors.As(err, &LoginDenied{}) {
+				return g.backToRegister(err)
+			}
+			g.startReconnect()
+		}
+	}
+	if g.reconnect != nil {
+		if err := g.updateReconnect(); err != nil {
+			return g.backToRegister(err)
+		}
+		return nil
 	}
 	g.pingServer()
 	g.SendChat()
+	g.chat.Update(g.mouseX, g.mouseY)
 	g.UpdateGamePos()
 	g.ListenInputs()
 	g.world.Update()
@@ -323,7 +399,7 @@ func (g *Game) updateGame() error {
 		if p == nil {
 			continue
 		}
-		p.WalkSteps(g.world.Space)
+		p.WalkSteps(g.world.Space, g.clock.Render())
 		p.Update(g.counter)
 		p.Effect.Update(g.counter)
 	}
@@ -335,31 +411,56 @@ func (g *Game) updateGame() error {
 	return nil
 }
 
-func (g *Game) Connect(nick string, address string) {
-	var err error
-	g.ms, err = msgs.DialServer(address, g.web, g.secureConn)
+// LoginDenied is the server not letting us in, and why
+type LoginDenied struct {
+	*msgs.EventLoginDenied
+}
+
+func (e LoginDenied) Error() string {
+	if e.Until == 0 {
+		return e.Reason
+	}
+	return fmt.Sprintf("%v, until %v", e.Reason, time.UnixMilli(e.Until).Format("Jan 2 15:04"))
+}
+
+// Connect dials the server and logs in, resuming the session behind
+// the token if there is one, the result goes to done.
+func (g *Game) Connect(nick, password, address, resume string, done chan Login) {
+	ms, err := msgs.DialServer(address, g.web, g.secureConn)
 	if err != nil {
-		g.connected <- Login{data: nil, err: err}
+		done <- Login{data: nil, err: err}
 		return
 	}
 	register := &msgs.EventRegister{
-		Nick: nick,
+		Nick:      nick,
+		Password:  password,
+		Snapshots: g.snapshots,
+		Resume:    resume,
+		Spectate:  g.spectate,
 	}
-	err = g.ms.EncodeAndWrite(msgs.ERegister, register)
+	err = ms.EncodeAndWrite(msgs.ERegister, register)
 	if err != nil {
-		g.connected <- Login{data: nil, err: err}
+		ms.Close()
+		done <- Login{data: nil, err: err}
 		return
 	}
-	im, err := g.ms.Read()
+	im, err := ms.Read()
 	if err != nil {
-		g.connected <- Login{data: nil, err: err}
+		ms.Close()
+		done <- Login{data: nil, err: err}
+		return
+	}
+	if im.Event == msgs.ELoginDenied {
+		ms.Close()
+		done <- Login{data: nil, err: LoginDenied{msgs.DecodeMsgpack(im.Data, &msgs.EventLoginDenied{})}}
 		return
 	}
 	if im.Event != msgs.EPlayerLogin {
-		g.connected <- Login{data: nil, err: fmt.Errorf("not login response")}
+		ms.Close()
+		done <- Login{data: nil, err: fmt.Errorf("not login response")}
 		return
 	}
-	g.connected <- Login{data: msgs.DecodeMsgpack(im.Data, &msgs.EventPlayerLogin{}), err: nil}
+	done <- Login{data: msgs.DecodeMsgpack(im.Data, &msgs.EventPlayerLogin{}), ms: ms, err: nil}
 }
 
 func (g *Game) StartGame(login *msgs.EventPlayerLogin) {
@@ -373,41 +474,69 @@ func (g *Game) StartGame(login *msgs.EventPlayerLogin) {
 	g.ViewPort = f64.Vec2{ScreenWidth, ScreenHeight}
 	//g.ZoomFactor = 1
 	g.lastMove = time.Now()
-	g.lastMoveConfirmed = true
+	g.predict = &Prediction{}
 	g.keys = NewKeys(g, nil)
 	g.keys.enterDown = true
+	g.editor = NewEditor(g)
+	g.clickMove = NewClickMove(g)
+	g.snaps = &Snapshots{}
+	g.clock = NewServerClock()
 	g.playersY = append(g.playersY, g.player)
 	g.stats = NewHud(g)
+	g.chat = NewChat()
 
 	g.eventQueue = make([]*GameMsg, 0, 100)
 	g.outQueue = make(chan *GameMsg, 100)
 	g.eventLock = sync.Mutex{}
-	go g.WriteEventQueue()
-	go g.WriteToServer()
+	g.startConn()
 
 	g.SoundBoard.Play(assets.Spawn)
 }
 
 func (g *Game) Login(e *msgs.EventPlayerLogin) {
+	g.world = NewMap(MapConfigFromPlayerLogin(e), func(chunk typ.P) {
+		g.outQueue <- &GameMsg{E: msgs.EMapChunkRequest, Data: chunk}
+	})
+	g.loginPlayers(e)
+}
+
+// loginPlayers sets us and the players around from the login
+func (g *Game) loginPlayers(e *msgs.EventPlayerLogin) {
 	g.sessionID = uint32(e.ID)
-	g.world = NewMap(MapConfigFromPlayerLogin(e))
+	g.role = e.Role
+	g.resumeToken = e.ResumeToken
 	g.player = player.NewLogin(e)
 	g.client = g.player.Client
+	g.players = make(map[uint16]*player.P)
 	for _, p := range e.VisiblePlayers {
 		g.AddToGame(&p)
 	}
 }
 
-func (g *Game) WriteToServer() {
-	for m := range g.outQueue {
-		g.ms.EncodeAndWrite(m.E, m.Data)
+// startConn starts reading and writing the connection in g.ms,
+// both stop when it fails.
+func (g *Game) startConn() {
+	done := make(chan struct{})
+	go g.WriteEventQueue(g.ms, done)
+	go g.WriteToServer(g.ms, done)
+}
+
+func (g *Game) WriteToServer(ms msgs.Msgs, done chan struct{}) {
+	for {
+		select {
+		case m := <-g.outQueue:
+			ms.EncodeAndWrite(m.E, m.Data)
+		case <-done:
+			return
+		}
 	}
 }
 
-func (g *Game) WriteEventQueue() {
+func (g *Game) WriteEventQueue(ms msgs.Msgs, done chan struct{}) {
 	for {
-		im, err := g.ms.Read()
+		im, err := ms.Read()
 		if err != nil {
+			close(done)
 			g.eventLock.Lock()
 			g.eventQueue = append(g.eventQueue, &GameMsg{E: msgs.EServerDisconnect})
 			g.eventLock.Unlock()
@@ -418,7 +547,7 @@ func (g *Game) WriteEventQueue() {
 		case msgs.EPingOk:
 			dim.Data = binary.BigEndian.Uint16(im.Data[:2])
 		case msgs.EMoveOk:
-			dim.Data = im.Data
+			dim.Data = msgs.DecodeEventMoveOk(im.Data)
 		case msgs.EMeleeOk:
 			dim.Data = msgs.DecodeEventMeleeOk(im.Data)
 		case msgs.ECastSpellOk:
@@ -451,6 +580,18 @@ func (g *Game) WriteEventQueue() {
 			msg := &msgs.EventBroadcastChat{}
 			msgs.DecodeMsgpack(im.Data, msg)
 			dim.Data = msg
+		case msgs.EPlayerLogin:
+			dim.Data = msgs.DecodeMsgpack(im.Data, &msgs.EventPlayerLogin{})
+		case msgs.EChatMuted:
+			dim.Data = msgs.DecodeMsgpack(im.Data, &msgs.EventChatMuted{})
+		case msgs.ELoginDenied:
+			dim.Data = msgs.DecodeMsgpack(im.Data, &msgs.EventLoginDenied{})
+		case msgs.EMapChunk:
+			dim.Data = msgs.DecodeMsgpack(im.Data, &msgs.EventMapChunk{})
+		case msgs.EMapTile:
+			dim.Data = msgs.DecodeMsgpack(im.Data, &msgs.EventMapTile{})
+		case msgs.ESnapshot:
+			dim.Data = msgs.DecodeMsgpack(im.Data, &msgs.EventSnapshot{})
 		}
 		g.eventLock.Lock()
 		g.eventQueue = append(g.eventQueue, &dim)
@@ -458,14 +599,45 @@ func (g *Game) WriteEventQueue() {
 	}
 }
 
+// showConnError puts why we cant play under the login for a while
+func (g *Game) showConnError(err error) {
+	g.connErrorColorStart = 255
+	g.connError = "Server offline"
+	if denied := (LoginDenied{}); errors.As(err, &denied) {
+		g.connError = denied.Error()
+	}
+}
+
+func (g *Game) backToRegister(err error) error {
+	if errors.As(err, &LoginDenied{}) {
+		g.showConnError(err)
+	}
+	g.Clear()
+	g.nickTyper = typing.NewTyper()
+	g.passTyper = typing.NewTyper()
+	g.mode = ModeRegister
+	ebiten.SetFullscreen(false)
+	return err
+}
+
 func (g *Game) Clear() {
 	g.sessionID = 0
+	g.resumeToken = ""
+	g.reconnect = nil
 	g.player = nil
-	g.players = [50]*player.P{}
+	g.players = map[uint16]*player.P{}
 	g.playersY = []YSortable{}
 }
 
+// AddToGame adds the player, or updates it if we already have it,
+// a spawn and a snapshot can both bring the same one.
 func (g *Game) AddToGame(event *msgs.EventNewPlayer) {
+	if p := g.players[event.ID]; p != nil {
+		p.Nick = event.Nick
+		p.Dead = event.Dead
+		p.SyncTo(g.world.Space, event.Pos, event.Dir)
+		return
+	}
 	g.world.Space.SetSlot(0, event.Pos, event.ID)
 	g.players[event.ID] = player.CreatePlayerSpawned(g.player, event)
 	g.players[event.ID].SetSoundboard(g.SoundBoard)
@@ -478,7 +650,14 @@ func (g *Game) ProcessEventQueue() error {
 		switch ev.E {
 		case msgs.EServerDisconnect:
 			log.Printf("Server disconnected\n")
+			g.eventQueue = g.eventQueue[:0]
+			g.eventLock.Unlock()
 			return errors.New("server disconnected")
+		case msgs.ELoginDenied:
+			// kicked, the session is gone so there is nothing to resume
+			g.eventQueue = g.eventQueue[:0]
+			g.eventLock.Unlock()
+			return LoginDenied{ev.Data.(*msgs.EventLoginDenied)}
 		case msgs.EPingOk:
 			g.WaitingPong = false
 			g.onlines = fmt.Sprintf("%d", ev.Data.(uint16))
@@ -502,9 +681,15 @@ func (g *Game) ProcessEventQueue() error {
 		case msgs.EPlayerMoved:
 			event := ev.Data.(*msgs.EventPlayerMoved)
 			log.Printf("Player [%v] moved\n", event.ID)
-			g.players[event.ID].AddStep(event)
+			g.clock.Observe(event.T)
+			// on udp a move can come before the player is in the viewport or after its gone
+			if p := g.players[event.ID]; p != nil {
+				p.AddStep(event)
+			}
 		case msgs.EMoveOk:
-			g.MovementResponse(ev.Data.([]byte))
+			g.MovementResponse(ev.Data.(*msgs.EventMoveOk))
+		case msgs.ESnapshot:
+			g.ApplySnapshot(ev.Data.(*msgs.EventSnapshot))
 		case msgs.EMeleeOk:
 			event := ev.Data.(*msgs.EventMeleeOk)
 			log.Printf("CastMeleeOk m: %#v\n", event)
@@ -519,42 +704,67 @@ func (g *Game) ProcessEventQueue() error {
 			g.player.Direction = event.Dir
 			g.SoundBoard.Play(assets.MeleeBlood)
 			g.player.Effect.NewAttackNumber(int(event.Damage), false)
-			g.players[event.ID].Effect.NewMeleeHit()
-			g.players[event.ID].Dead = event.Killed
+			if target := g.players[event.ID]; target != nil {
+				target.Effect.NewMeleeHit()
+				target.Dead = event.Killed
+			}
+			g.chat.Combat("you hit %v for %v", g.nick(event.ID), event.Damage)
+			if event.Killed {
+				g.chat.Combat("you killed %v", g.nick(event.ID))
+			}
 		case msgs.EPlayerMeleeRecieved:
 			event := ev.Data.(*msgs.EventPlayerMeleeRecieved)
 			g.SoundBoard.Play(assets.MeleeBlood)
 			g.player.Effect.NewMeleeHit()
 			log.Printf("RecivedMelee m: %#v\n", event)
-			g.players[event.ID].Effect.NewAttackNumber(int(event.Damage), false)
-			g.players[event.ID].Direction = event.Dir
+			// an invisible admin comes as 0, we dont know it
+			if from := g.players[event.ID]; from != nil {
+				from.Effect.NewAttackNumber(int(event.Damage), false)
+				from.Direction = event.Dir
+			}
 			g.player.Client.HP = int(event.NewHP)
-
+			g.chat.Combat("%v hit you for %v", g.nick(event.ID), event.Damage)
 			if g.player.Client.HP == 0 {
 				g.player.Dead = true
 				g.player.Inmobilized = false
+				g.chat.Combat("%v killed you", g.nick(event.ID))
 			}
 		case msgs.EPlayerMelee:
 			event := ev.Data.(*msgs.EventPlayerMelee)
 			log.Printf("EPlayerMelee m: %#v\n", event)
 			if !event.Hit {
-				g.players[event.From].Direction = event.Dir
-				g.SoundBoard.PlayFrom(assets.MeleeAir, g.player.X, g.player.Y, g.players[event.From].X, g.players[event.From].Y)
+				if from := g.players[event.From]; from != nil {
+					from.Direction = event.Dir
+					g.SoundBoard.PlayFrom(assets.MeleeAir, g.player.X, g.player.Y, from.X, from.Y)
+				}
 				break
 			}
-			g.players[event.ID].Direction = event.Dir
-			g.SoundBoard.PlayFrom(assets.MeleeBlood, g.player.X, g.player.Y, g.players[event.ID].X, g.players[event.ID].Y)
-			g.players[event.ID].Effect.NewMeleeHit()
-			g.players[event.ID].Dead = event.Killed
+			target := g.players[event.ID]
+			if target == nil {
+				break
+			}
+			target.Direction = event.Dir
+			g.SoundBoard.PlayFrom(assets.MeleeBlood, g.player.X, g.player.Y, target.X, target.Y)
+			target.Effect.NewMeleeHit()
+			if event.Killed && !target.Dead {
+				g.chat.Combat("%v killed %v", g.nick(event.From), target.Nick)
+			}
+			target.Dead = event.Killed
 		case msgs.ECastSpellOk:
 			event := ev.Data.(*msgs.EventCastSpellOk)
 			log.Printf("CastSpellOk m: %#v\n", event)
 			g.player.Client.MP = int(event.NewMP)
 			if uint32(event.ID) != g.sessionID {
 				g.player.Effect.NewAttackNumber(int(event.Damage), event.Spell == spell.HealWounds)
-				g.players[event.ID].Effect.NewSpellHit(event.Spell)
-				g.SoundBoard.PlayFrom(assets.SoundFromSpell(event.Spell), g.player.X, g.player.Y, g.players[event.ID].X, g.players[event.ID].Y)
-				g.players[event.ID].Dead = event.Killed
+				if target := g.players[event.ID]; target != nil {
+					target.Effect.NewSpellHit(event.Spell)
+					g.SoundBoard.PlayFrom(assets.SoundFromSpell(event.Spell), g.player.X, g.player.Y, target.X, target.Y)
+					target.Dead = event.Killed
+				}
+				g.chat.Combat("you cast %v on %v for %v", event.Spell, g.nick(event.ID), event.Damage)
+				if event.Killed {
+					g.chat.Combat("you killed %v", g.nick(event.ID))
+				}
 			}
 		case msgs.EPlayerSpellRecieved:
 			event := ev.Data.(*msgs.EventPlayerSpellRecieved)
@@ -573,33 +783,71 @@ func (g *Game) ProcessEventQueue() error {
 			}
 			g.SoundBoard.Play(assets.SoundFromSpell(event.Spell))
 			g.player.Effect.NewSpellHit(event.Spell)
-			caster.Effect.NewAttackNumber(int(event.Damage), event.Spell == spell.HealWounds)
+			if caster != nil {
+				caster.Effect.NewAttackNumber(int(event.Damage), event.Spell == spell.HealWounds)
+			}
 			g.player.Client.HP = int(event.NewHP)
+			g.chat.Combat("%v cast %v on you for %v", g.nick(event.ID), event.Spell, event.Damage)
 			if g.player.Client.HP == 0 {
 				g.player.Inmobilized = false
 				g.player.Dead = true
+				g.chat.Combat("%v killed you", g.nick(event.ID))
 			}
 		case msgs.EPlayerSpell:
 			event := ev.Data.(*msgs.EventPlayerSpell)
 			log.Printf("SpellHit m: %#v\n", event)
-			g.SoundBoard.PlayFrom(assets.SoundFromSpell(event.Spell), g.player.X, g.player.Y, g.players[event.ID].X, g.players[event.ID].Y)
-			g.players[event.ID].Effect.NewSpellHit(event.Spell)
-			g.players[event.ID].Dead = event.Killed
+			target := g.players[event.ID]
+			if target == nil {
+				break
+			}
+			g.SoundBoard.PlayFrom(assets.SoundFromSpell(event.Spell), g.player.X, g.player.Y, target.X, target.Y)
+			target.Effect.NewSpellHit(event.Spell)
+			if event.Killed && !target.Dead {
+				g.chat.Combat("%v died", target.Nick)
+			}
+			target.Dead = event.Killed
 		case msgs.EUseItemOk:
 			event := ev.Data.(*msgs.EventUseItemOk)
 			log.Printf("UsePotionOk m: %#v\n", event)
+			if event.Item == msgs.ItemNone {
+				// the server said why in the combat chat
+				break
+			}
 			switch event.Item {
 			case msgs.Item(msgs.ItemManaPotion):
 				g.player.Client.MP = int(event.Change)
+				g.chat.Combat("you drank a mana potion")
 			case msgs.Item(msgs.ItemHealthPotion):
 				g.player.Client.HP = int(event.Change)
+				g.chat.Combat("you drank a health potion")
 			}
 			g.stats.potionAlpha = 1
 			g.lastPotionUsed = event.Item
 			g.SoundBoard.Play(assets.Potion)
 		case msgs.EBroadcastChat:
 			event := ev.Data.(*msgs.EventBroadcastChat)
-			g.players[event.ID].SetChatMsg(event.Msg)
+			line := ChatLine{Channel: event.Channel, Nick: event.Nick, To: event.To, Msg: event.Msg}
+			if p := g.players[event.ID]; p != nil && event.Channel == msgs.ChatLocal {
+				p.SetChatMsg(event.Msg)
+				line.Nick = p.Nick
+			}
+			g.chat.Add(line)
+		case msgs.EChatMuted:
+			event := ev.Data.(*msgs.EventChatMuted)
+			g.mutedUntil = time.UnixMilli(event.Until)
+			if event.Until == 0 {
+				g.mutedUntil = time.Time{}
+				g.chat.Add(ChatLine{Channel: msgs.ChatSystem, Msg: "you can chat again"})
+				break
+			}
+			g.chat.Add(ChatLine{Channel: msgs.ChatSystem, Msg: fmt.Sprintf("you are muted until %v: %v", g.mutedUntil.Format("15:04:05"), event.Reason)})
+		case msgs.EPlayerLogin:
+			// the server took us somewhere else
+			g.relogin(ev.Data.(*msgs.EventPlayerLogin))
+		case msgs.EMapChunk:
+			g.world.LoadChunk(ev.Data.(*msgs.EventMapChunk))
+		case msgs.EMapTile:
+			g.world.ApplyTile(ev.Data.(*msgs.EventMapTile))
 		}
 	}
 	g.eventQueue = g.eventQueue[:0]
@@ -616,9 +864,23 @@ func (g *Game) pingServer() {
 	g.LastPing = time.Now()
 }
 
+// nick is who id is for the log
+func (g *Game) nick(id uint16) string {
+	if uint32(id) == g.sessionID {
+		return "you"
+	}
+	if p := g.players[id]; p != nil {
+		return p.Nick
+	}
+	return "someone"
+}
+
 func (g *Game) DespawnPlayer(pid uint16) {
 	p := g.players[pid]
-	g.players[pid] = nil
+	if p == nil {
+		return
+	}
+	delete(g.players, pid)
 	if !p.Dead {
 		g.world.Space.Set(0, typ.P{X: int32(p.X), Y: int32(p.Y)}, 0)
 	}
@@ -632,6 +894,17 @@ func (g *Game) DespawnPlayer(pid uint16) {
 }
 func (g *Game) SendChat() {
 	if msg := g.keys.ChatMessage(); msg != "" {
+		if strings.HasPrefix(msg, "/") {
+			// commands dont go over our head
+			g.keys.sentChat = ""
+		} else if time.Now().Before(g.mutedUntil) {
+			// the server wont say it either
+			g.keys.sentChat = ""
+			g.chat.Add(ChatLine{Channel: msgs.ChatSystem, Msg: fmt.Sprintf("you are muted until %v", g.mutedUntil.Format("15:04:05"))})
+			return
+		} else {
+			g.chat.Add(ChatLine{Nick: g.player.Nick, Msg: msg})
+		}
 		g.outQueue <- &GameMsg{
 			E:    msgs.ESendChat,
 			Data: &msgs.EventSendChat{Msg: msg},
@@ -653,66 +926,12 @@ func DirToNewPos(p *player.P, d direction.D) typ.P {
 	return typ.P{X: p.X, Y: p.Y}
 }
 
-func (g *Game) MovementResponse(data []byte) {
-
-	g.lastMoveConfirmed = true
-	allowed := data[0] != 0
-	dir := direction.D(data[1])
-	log.Printf("MovementResponse %v  %v\n", allowed, dir)
-	if len(g.steps) == 0 {
-		g.player.Walking = false
-		log.Printf("move ok arrived without having a step sent\n")
-		return
-	}
-	step := g.steps[0]
-	g.steps = g.steps[1:]
-	//log.Printf("MOVE:[%v][%v] %v %vms\n", allowed, step.Expect, direction.S(g.player.Direction), time.Since(g.startForStep).Milliseconds())
-
-	if allowed == step.Expect {
-		// if it was expected, we already did it
-		return
-	}
-	if !allowed && step.Expect {
-		// if it wasnt expected to fail we need to go back
-		goBackPx := float64(constants.TileSize - g.leftForMove)
-		log.Println("move not allowed, go back", goBackPx, "px", *g.player)
-		switch dir {
-		case direction.Front:
-			g.player.Y--
-			g.player.Pos[1] -= goBackPx
-		case direction.Back:
-			g.player.Y++
-			g.player.Pos[1] += goBackPx
-		case direction.Left:
-			g.player.X++
-			g.player.Pos[0] += goBackPx
-		case direction.Right:
-			g.player.X--
-			g.player.Pos[0] -= goBackPx
-		}
-		g.player.Walking = false
-		g.leftForMove = 0
-		return
-	}
-
-	if allowed && !step.Expect {
-		g.player.Inmobilized = false // if were allowed to move then we can change this already in any case
-		g.lastDir = dir
-		g.Move(dir, step.To)
-		return
-	}
-
-}
-
-func (g *Game) TryMove(d direction.D, np typ.P, confident bool) {
-	g.lastMoveConfirmed = false
-	g.outQueue <- &GameMsg{E: msgs.EMove, Data: d}
-	g.steps = append(g.steps, player.Step{
-		To:     np,
-		Dir:    d,
-		Expect: confident,
-	})
-	if confident {
+// TryMove sends the move and walks it already if we think the server will let us
+func (g *Game) TryMove(d direction.D) {
+	np, ok := g.predictStep(typ.P{X: g.player.X, Y: g.player.Y}, d)
+	seq := g.predict.Add(d)
+	g.outQueue <- &GameMsg{E: msgs.EMove, Data: &msgs.EventMove{Seq: seq, Dir: d}}
+	if ok {
 		g.Move(d, np)
 	}
 }
@@ -751,12 +970,8 @@ func (g *Game) AddGameStep(d direction.D) {
 	if stuff != 0 && d == g.lastDir {
 		return
 	}
-
-	playerLayer := g.world.Space.GetSlot(0, np)
-
-	confident := !outWorld && !g.player.Inmobilized && playerLayer == 0 && stuff == 0
 	g.lastDir = d
-	g.TryMove(d, np, confident)
+	g.TryMove(d)
 }
 
 func (g *Game) UpdateGamePos() {
@@ -783,27 +998,43 @@ func (g *Game) UpdateGamePos() {
 
 func (g *Game) ListenInputs() {
 	g.keys.ListenMovement()
-	g.keys.ListenSpell()
+	g.editor.Update()
+	if !g.editor.On {
+		g.keys.ListenSpell()
+	}
 
-	d := g.keys.MovingTo()
+	if !g.editor.On {
+		g.clickMove.Listen()
+	}
 
-	if d == direction.Still {
+	d := g.keys.MovingTo()
+	if d != direction.Still {
+		// the keyboard always wins over a click
+		g.clickMove.Cancel()
+	}
+	if g.predict.Full() || g.leftForMove != 0 || time.Since(g.startForStep).Milliseconds() <= 60 {
 		goto COMBAT
 	}
-	if g.lastMoveConfirmed && g.leftForMove == 0 && (time.Since(g.startForStep).Milliseconds() > 60) {
+	if d != direction.Still {
 		g.AddGameStep(d)
+	} else if next, ok := g.clickMove.Next(); ok {
+		g.AddGameStep(pathfind.Direction(typ.P{X: g.player.X, Y: g.player.Y}, next))
 	}
 COMBAT:
 	{
+		if g.editor.On {
+			return
+		}
 		if g.keys.MeleeHit() {
 			g.outQueue <- &GameMsg{E: msgs.EMelee, Data: d}
 		}
-		if ok, spellType, x, y := g.keys.CastSpell(); ok {
+		if ok, spellType, x, y := g.keys.CastSpell(); ok && !g.chat.Over(x, y) {
 			worldX, worldY := g.ScreenToWorld(x, y)
 			g.outQueue <- &GameMsg{E: msgs.ECastSpell, Data: &msgs.EventCastSpell{
 				PX:    uint32(worldX),
 				PY:    uint32(worldY),
 				Spell: spellType,
+				T:     g.clock.Render(),
 			}}
 		}
 		if pressedPotion := g.keys.PressedPotion(); pressedPotion != msgs.ItemNone {
@@ -820,12 +1051,26 @@ func (g *Game) updateWorldMatrix() {
 	g.worldImgOp.GeoM.Translate(-g.player.Pos[0]+HalfScreenX-16, -g.player.Pos[1]+HalfScreenY-48)
 }
 
+// CameraOrigin is the world pixel at the top left of the screen.
+func (g *Game) CameraOrigin() f64.Vec2 {
+	return f64.Vec2{g.player.Pos[0] - HalfScreenX + 16, g.player.Pos[1] - HalfScreenY + 48}
+}
+
 func (g *Game) Render(world, screen *ebiten.Image) {
 	g.updateWorldMatrix()
-
+	// the world image only covers the viewport, it starts at the map origin
+	g.worldImgOp.GeoM.Translate(g.world.Origin[0], g.world.Origin[1])
 	screen.DrawImage(world, g.worldImgOp)
 }
 
+func (g *Game) HoveredTile() typ.P {
+	x, y := g.ScreenToWorld(g.mouseX, g.mouseY)
+	return typ.P{
+		X: int32(math.Floor(x / constants.TileSize)),
+		Y: int32(math.Floor(y / constants.TileSize)),
+	}
+}
+
 func (g *Game) ScreenToWorld(posX, posY int) (float64, float64) {
 	g.updateWorldMatrix()
 	if g.worldImgOp.GeoM.IsInvertible() {
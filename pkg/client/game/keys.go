@@ -26,6 +26,9 @@ type KeyConfig struct {
 
 	Melee *Input
 
+	// Walk to the clicked tile
+	ClickMove *Input
+
 	// Spell picker
 	PickParalize          *Input
 	PickParalizeRm        *Input
@@ -60,7 +63,9 @@ var DefaultConfig = KeyConfig{
 	PotionHP: NewInputPtr(ebiten.MouseButtonRight),
 	PotionMP: NewInputPtr(ebiten.KeyF),
 
-	Melee:          NewInputPtr(ebiten.KeySpace),
+	Melee: NewInputPtr(ebiten.KeySpace),
+	// right click is the red potion
+	ClickMove:      NewInputPtr(ebiten.MouseButtonMiddle),
 	PotionCooldown: time.Millisecond * 300,
 
 	CooldownAction: time.Millisecond * 400,
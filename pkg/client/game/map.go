@@ -2,74 +2,87 @@ package game
 
 import (
 	"image"
+	"image/color"
 	"log"
+	"math"
 
 	"github.com/hajimehoshi/ebiten/v2"
 	"github.com/rywk/minigoao/pkg/client/game/player"
 	"github.com/rywk/minigoao/pkg/client/game/texture"
 	"github.com/rywk/minigoao/pkg/constants"
 	"github.com/rywk/minigoao/pkg/constants/assets"
+	"github.com/rywk/minigoao/pkg/constants/npc"
 	"github.com/rywk/minigoao/pkg/grid"
 	"github.com/rywk/minigoao/pkg/msgs"
 	"github.com/rywk/minigoao/pkg/typ"
+	"github.com/rywk/minigoao/pkg/world"
+	"golang.org/x/image/math/f64"
 )
 
 type MapConfig struct {
-	Width, Height         int
+	Width, Height         int // in tiles
 	StartX, StartY        int
 	ViewWidth, ViewHeight int
-	GroundMapTextures     [][]assets.Image
-	StuffMapTextures      [][]assets.Image
+	Zones                 []msgs.Zone
 }
 
 func MapConfigFromPlayerLogin(p *msgs.EventPlayerLogin) *MapConfig {
 	mc := MapConfig{}
 	log.Println(p.ID)
-	mc.Width, mc.Height = constants.PixelWorldX, constants.PixelWorldY
+	mc.Width, mc.Height = int(p.MapW), int(p.MapH)
 	mc.StartX, mc.StartY = int(p.Pos.X)*constants.TileSize, int(p.Pos.Y)*constants.TileSize
 	mc.ViewWidth, mc.ViewHeight = int(constants.GridViewportX), int(constants.GridViewportX)
-	mc.GroundMapTextures = MapLayers[Ground]
-	mc.StuffMapTextures = MapLayers[Stuff]
+	mc.Zones = p.Zones
 	return &mc
 }
 
+// The world is split in square chunks of tiles, the server streams
+// us the chunks around the camera, each is rendered into its own
+// cached image and gets rebuilt when something in it changes.
+// Chunks that go far from the camera are dropped and requested again
+// when we come back, so we dont keep stale edits around.
+const (
+	ChunkTiles = world.ChunkTiles
+	ChunkSize  = ChunkTiles * constants.TileSize
+
+	// chunks outside the view that we keep built, so walking into
+	// a new chunk doesnt have to render it on that same frame
+	chunkMargin = 1
+	// max chunks outside the view built in a single frame
+	chunkBuildsXFrame = 1
+)
+
+type chunk struct {
+	img    *ebiten.Image
+	loaded bool // the server sent us its data
+	dirty  bool
+}
+
 type Map struct {
-	world      *ebiten.Image
-	floorTiles [][]texture.T
-	stuffTiles [][]texture.T
-	Space      *grid.Grid
-	drawOp     *ebiten.DrawImageOptions
+	terrain  *world.Map
+	textures map[assets.Image]texture.T
+	chunks   map[typ.P]*chunk
+	request  func(chunk typ.P)
+
+	// viewport sized render target, Origin is the world pixel at its top left
+	view   *ebiten.Image
+	Origin f64.Vec2
+
+	Space  *grid.Grid
+	drawOp *ebiten.DrawImageOptions
 }
 
-func NewMap(c *MapConfig) *Map {
+func NewMap(c *MapConfig, request func(chunk typ.P)) *Map {
 	m := &Map{
-		drawOp: &ebiten.DrawImageOptions{},
-		Space:  grid.NewGrid(int32(constants.WorldX), int32(constants.WorldY), 3),
-	}
-	// Use config to load textures for floor tiles
-	m.floorTiles = make([][]texture.T, constants.PixelWorldX/texture.GrassTextureSize)
-	for i := range m.floorTiles {
-		m.floorTiles[i] = make([]texture.T, constants.PixelWorldY/texture.GrassTextureSize)
-		for j := range m.floorTiles[i] {
-			m.floorTiles[i][j] = texture.LoadTexture(c.GroundMapTextures[i][j])
-			if m.floorTiles[i][j] == nil {
-				log.Println("asdasd", c.GroundMapTextures[i][j])
-			}
-		}
+		terrain:  world.New(int32(c.Width), int32(c.Height)),
+		textures: make(map[assets.Image]texture.T),
+		chunks:   make(map[typ.P]*chunk),
+		request:  request,
+		view:     ebiten.NewImage(ScreenWidth, ScreenHeight),
+		Space:    grid.NewGrid(int32(c.Width), int32(c.Height), 3),
+		drawOp:   &ebiten.DrawImageOptions{},
 	}
-
-	// Use config to load textures for stuff over floor
-	m.stuffTiles = make([][]texture.T, len(c.StuffMapTextures))
-	for i, y := range c.StuffMapTextures {
-		m.stuffTiles[i] = make([]texture.T, len(c.StuffMapTextures))
-		for j, t := range y {
-			m.stuffTiles[i][j] = texture.LoadTexture(t)
-			if assets.IsSolid(t) {
-				m.Space.Set(1, typ.P{X: int32(j), Y: int32(i)}, uint16(t))
-			}
-		}
-	}
-	m.world = ebiten.NewImage(len(m.stuffTiles[0])*constants.TileSize, len(m.stuffTiles)*constants.TileSize)
+	m.terrain.Zones = c.Zones
 	return m
 }
 
@@ -77,143 +90,226 @@ func (m *Map) Update() {
 	// ? eventually npcs?
 }
 
-const (
-	pixelXView = constants.GridViewportX * constants.TileSize
-	pixelYView = constants.GridViewportY * constants.TileSize
-)
+func (m *Map) texture(a assets.Image) texture.T {
+	t, ok := m.textures[a]
+	if !ok {
+		t = texture.LoadTexture(a)
+		m.textures[a] = t
+	}
+	return t
+}
+
+func (m *Map) setBlocked(p typ.P, blocked bool) {
+	m.terrain.Blocked[p.Y][p.X] = blocked
+	if blocked {
+		m.Space.SetSlot(1, p, 1)
+	} else {
+		m.Space.SetSlot(1, p, 0)
+	}
+}
 
-func (m *Map) Draw(pos typ.P) {
-	minX, minY := pos.X*constants.TileSize-pixelXView, pos.Y*constants.TileSize-pixelYView
-	maxX, maxY := pos.X*constants.TileSize+pixelXView, pos.Y*constants.TileSize+pixelYView
-	for y := range m.floorTiles {
-		ypx := int32(y * texture.GrassTextureSize)
-		if ypx < minY || ypx > maxY {
-			continue
+// LoadChunk stores the data of a chunk sent by the server.
+func (m *Map) LoadChunk(ev *msgs.EventMapChunk) {
+	r := ev.Rect
+	if r.Min.Out(m.Space.Rect) || r.Max.X > m.terrain.W || r.Max.Y > m.terrain.H {
+		log.Printf("chunk out of the map %v\n", r)
+		return
+	}
+	w := r.Max.X - r.Min.X
+	if int(w*(r.Max.Y-r.Min.Y)) != len(ev.Ground) || len(ev.Ground) != len(ev.Stuff) || len(ev.Ground) != len(ev.Blocked) {
+		log.Printf("bad chunk %v\n", ev.Chunk)
+		return
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			i := (y-r.Min.Y)*w + (x - r.Min.X)
+			m.terrain.Ground[y][x] = ev.Ground[i]
+			m.terrain.Stuff[y][x] = ev.Stuff[i]
+			m.setBlocked(typ.P{X: x, Y: y}, ev.Blocked[i])
 		}
-		for x := range m.floorTiles[y] {
-			xpx := int32(x * texture.GrassTextureSize)
-			if xpx < minX || xpx > maxX {
-				continue
-			}
-			m.drawOp.GeoM.Reset()
-			m.drawOp.GeoM.Translate(float64(xpx), float64(ypx))
-			if m.floorTiles[y][x] == nil {
-				continue
-			}
-			m.floorTiles[y][x].Draw(m.world, m.drawOp)
+	}
+	spawns := m.terrain.Spawns[:0]
+	for _, s := range m.terrain.Spawns {
+		if s.Out(r) {
+			spawns = append(spawns, s)
 		}
 	}
-	for y := range m.stuffTiles {
-		ypx := int32(y * constants.TileSize)
-		if ypx < minY || ypx > maxY {
-			continue
+	m.terrain.Spawns = append(spawns, ev.Spawns...)
+	npcs := m.terrain.NPCs[:0]
+	for _, n := range m.terrain.NPCs {
+		if n.Pos.Out(r) {
+			npcs = append(npcs, n)
 		}
-		for x := range m.stuffTiles[y] {
-			xpx := int32(x * constants.TileSize)
-			if xpx < minX || xpx > maxX {
-				continue
-			}
+	}
+	m.terrain.NPCs = append(npcs, ev.NPCs...)
+	if c, ok := m.chunks[ev.Chunk]; ok {
+		c.loaded = true
+		c.dirty = true
+	}
+}
 
-			m.drawOp.GeoM.Reset()
-			m.drawOp.GeoM.Translate(float64(xpx), float64(ypx))
-			m.stuffTiles[y][x].Draw(m.world, m.drawOp)
+// ApplyTile updates a tile that was edited.
+func (m *Map) ApplyTile(ev *msgs.EventMapTile) {
+	p := ev.Pos
+	if p.Out(m.Space.Rect) {
+		return
+	}
+	m.terrain.Ground[p.Y][p.X] = ev.Ground
+	m.terrain.Stuff[p.Y][p.X] = ev.Stuff
+	m.setBlocked(p, ev.Blocked)
+	if ev.Spawn != m.terrain.IsSpawn(p) {
+		m.terrain.Edit(&msgs.EventMapEdit{Op: msgs.MapEditSpawn, Pos: p})
+	}
+	if t := m.terrain.NPCAt(p); ev.NPC != t {
+		// toggling the one we have removes it
+		if t != npc.None {
+			m.terrain.Edit(&msgs.EventMapEdit{Op: msgs.MapEditNPC, Pos: p, Asset: uint32(t)})
+		}
+		if ev.NPC != npc.None {
+			m.terrain.Edit(&msgs.EventMapEdit{Op: msgs.MapEditNPC, Pos: p, Asset: uint32(ev.NPC)})
 		}
 	}
+	if c, ok := m.chunks[world.ChunkOf(p)]; ok {
+		c.dirty = true
+	}
 }
 
-func (m *Map) Image() *ebiten.Image {
-	return m.world
+func (m *Map) Blocked(p typ.P) bool {
+	return p.In(m.Space.Rect) && m.terrain.Blocked[p.Y][p.X]
 }
 
-func MapSoundToPlayer(p *player.P, x, y int) (float64, float64) {
-	diffX, diffY := p.X-int32(x), p.Y-int32(y)
-	return float64(diffX) * 0.08, float64(diffY) * 0.08
+func (m *Map) IsSpawn(p typ.P) bool {
+	return m.terrain.IsSpawn(p)
 }
 
-// Map Layers
-// - Ground
-// - Stuff
-type Layer uint8
+func (m *Map) NPCAt(p typ.P) npc.Type {
+	return m.terrain.NPCAt(p)
+}
 
-const (
-	Ground Layer = iota
-	Stuff
-	layerTypes
-)
+func (m *Map) ZoneAt(p typ.P) *msgs.Zone {
+	return m.terrain.ZoneAt(p)
+}
 
-var MapLayers = [layerTypes][][]assets.Image{
-	FillLayer(constants.WorldX, constants.WorldY, assets.Grass),
-	RandomShroomLayer(constants.WorldX, constants.WorldY),
+func (m *Map) chunkRange(margin int32) typ.Rect {
+	r := typ.Rect{
+		Min: typ.P{X: int32(m.Origin[0])/ChunkSize - margin, Y: int32(m.Origin[1])/ChunkSize - margin},
+		Max: typ.P{X: (int32(m.Origin[0])+ScreenWidth)/ChunkSize + margin + 1, Y: (int32(m.Origin[1])+ScreenHeight)/ChunkSize + margin + 1},
+	}
+	chunks := m.terrain.Chunks()
+	r.Min.X, r.Min.Y = max(r.Min.X, 0), max(r.Min.Y, 0)
+	r.Max.X, r.Max.Y = min(r.Max.X, chunks.X), min(r.Max.Y, chunks.Y)
+	return r
 }
 
-func RandomShroomLayer(width, height int) [][]assets.Image {
-	layer := make([][]assets.Image, height)
-	for y := 0; y < height; y++ {
-		layer[y] = make([]assets.Image, width)
-		layer[y][0] = assets.Shroom
-		layer[y][width-1] = assets.Shroom
+// build renders the ground and stuff layers of a chunk into its image.
+func (m *Map) build(cp typ.P, c *chunk) {
+	if c.img == nil {
+		c.img = ebiten.NewImage(ChunkSize, ChunkSize)
 	}
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			layer[0][x] = assets.Shroom
-			layer[height-1][x] = assets.Shroom
-			if x%25 == 0 && y%25 == 0 {
-				layer[y][x] = assets.Shroom
+	c.img.Clear()
+	c.dirty = false
+	r := m.terrain.ChunkRect(cp)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			a := m.terrain.Ground[y][x]
+			if a == assets.Nothing {
+				continue
 			}
-
+			// ground textures can be bigger than a tile, we clip the part
+			// of the texture that lands on this tile so they repeat seamlessly
+			lx, ly := int((x-r.Min.X)*constants.TileSize), int((y-r.Min.Y)*constants.TileSize)
+			tw, th := texture.Size(a)
+			if tw == 0 || th == 0 {
+				tw, th = constants.TileSize, constants.TileSize
+			}
+			offX, offY := int(x*constants.TileSize)%tw, int(y*constants.TileSize)%th
+			tile := c.img.SubImage(image.Rect(lx, ly, lx+constants.TileSize, ly+constants.TileSize)).(*ebiten.Image)
+			m.drawOp.GeoM.Reset()
+			m.drawOp.GeoM.Translate(float64(lx-offX), float64(ly-offY))
+			m.texture(a).Draw(tile, m.drawOp)
 		}
 	}
-	arena1v1 := image.Rect(0, 0, 8, 8)
-	arena2v2 := image.Rect(0, 0, 16, 12)
-	arena1v1n1 := arena1v1.Add(image.Point{X: 25, Y: 29})
-	arena2v2n1 := arena2v2.Add(image.Point{X: 40, Y: 29})
-
-	for y := arena1v1n1.Min.Y; y < arena1v1n1.Max.Y; y++ {
-		layer[y][arena1v1n1.Min.X] = assets.Shroom
-		layer[y][arena1v1n1.Max.X] = assets.Shroom
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			a := m.terrain.Stuff[y][x]
+			if a == assets.Nothing {
+				continue
+			}
+			m.drawOp.GeoM.Reset()
+			m.drawOp.GeoM.Translate(float64((x-r.Min.X)*constants.TileSize), float64((y-r.Min.Y)*constants.TileSize))
+			m.texture(a).Draw(c.img, m.drawOp)
+		}
 	}
-	for x := arena1v1n1.Min.X; x < arena1v1n1.Max.X; x++ {
-		layer[arena1v1n1.Min.Y][x] = assets.Shroom
-		layer[arena1v1n1.Max.Y][x] = assets.Shroom
+}
 
-	}
-	layer[37][32] = assets.Nothing
+// Draw renders the chunks visible from origin (world pixel at the top left of the screen)
+// into the viewport image, requesting and building the ones missing and dropping the ones far away.
+func (m *Map) Draw(origin f64.Vec2) {
+	m.Origin = f64.Vec2{math.Floor(origin[0]), math.Floor(origin[1])}
+	visible := m.chunkRange(0)
+	keep := m.chunkRange(chunkMargin)
 
-	for y := arena2v2n1.Min.Y; y < arena2v2n1.Max.Y; y++ {
-		layer[y][arena2v2n1.Min.X] = assets.Shroom
-		layer[y][arena2v2n1.Max.X] = assets.Shroom
+	for cp, c := range m.chunks {
+		if cp.Out(keep) {
+			if c.img != nil {
+				c.img.Dispose()
+			}
+			delete(m.chunks, cp)
+		}
+	}
+	builds := 0
+	for y := keep.Min.Y; y < keep.Max.Y; y++ {
+		for x := keep.Min.X; x < keep.Max.X; x++ {
+			cp := typ.P{X: x, Y: y}
+			c, ok := m.chunks[cp]
+			if !ok {
+				m.chunks[cp] = &chunk{}
+				m.request(cp)
+				continue
+			}
+			if !c.loaded || (c.img != nil && !c.dirty) {
+				continue
+			}
+			if cp.Out(visible) {
+				if builds >= chunkBuildsXFrame {
+					continue
+				}
+				builds++
+			}
+			m.build(cp, c)
+		}
 	}
-	for x := arena2v2n1.Min.X; x < arena2v2n1.Max.X; x++ {
-		layer[arena2v2n1.Min.Y][x] = assets.Shroom
-		layer[arena2v2n1.Max.Y][x] = assets.Shroom
 
+	m.view.Fill(color.Black)
+	for y := visible.Min.Y; y < visible.Max.Y; y++ {
+		for x := visible.Min.X; x < visible.Max.X; x++ {
+			c := m.chunks[typ.P{X: x, Y: y}]
+			if c.img == nil {
+				continue
+			}
+			m.drawOp.GeoM.Reset()
+			m.drawOp.GeoM.Translate(float64(x*ChunkSize)-m.Origin[0], float64(y*ChunkSize)-m.Origin[1])
+			m.view.DrawImage(c.img, m.drawOp)
+		}
 	}
-	layer[41][55] = assets.Nothing
-	layer[41][54] = assets.Nothing
-	layer[41][40] = assets.Nothing
-	layer[41][41] = assets.Nothing
-	return layer
 }
 
-func FillLayer(width, height int, a assets.Image) [][]assets.Image {
-	layer := make([][]assets.Image, height)
-	for y := 0; y < height; y++ {
-		layer[y] = make([]assets.Image, width)
-		for x := 0; x < width; x++ {
-			layer[y][x] = a
+// DropPending forgets the chunks we asked for and didnt get yet,
+// after a resume the requests are lost with the old conn and Draw
+// only asks for chunks it has no entry for.
+func (m *Map) DropPending() {
+	for cp, c := range m.chunks {
+		if !c.loaded {
+			delete(m.chunks, cp)
 		}
 	}
-	return layer
 }
 
-// func FillBlockers(aa [][]assets.Image, g *tile.Grid[thing.Thing]) {
-// 	for y := 0; y < len(aa); y++ {
-// 		for x := 0; x < len(aa[y]); x++ {
-// 			if assets.IsSolid(aa[y][x]) {
-// 				t, _ := g.At(int16(x), int16(y))
-// 				// we dont care about this pointer because it should never be deleted
-// 				t.Add(&thing.Solid{})
-// 			}
-// 		}
-// 	}
-// }
+func (m *Map) Image() *ebiten.Image {
+	return m.view
+}
+
+func MapSoundToPlayer(p *player.P, x, y int) (float64, float64) {
+	diffX, diffY := p.X-int32(x), p.Y-int32(y)
+	return float64(diffX) * 0.08, float64(diffY) * 0.08
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/rywk/minigoao/pkg/constants"
 	"github.com/rywk/minigoao/pkg/constants/assets"
 	"github.com/rywk/minigoao/pkg/constants/direction"
+	"github.com/rywk/minigoao/pkg/constants/npc"
 	"github.com/rywk/minigoao/pkg/constants/spell"
 	"github.com/rywk/minigoao/pkg/grid"
 	"github.com/rywk/minigoao/pkg/msgs"
@@ -48,6 +49,7 @@ type P struct {
 	leftForMove  float64
 	lastDir      direction.D
 	steps        []Step
+	walkT        uint32 // server time of the last WalkSteps
 
 	chatMsg      string
 	chatMsgStart time.Time
@@ -118,15 +120,16 @@ func (p *P) SetChatMsg(msg string) {
 const PlayerDrawOffsetX, PlayerDrawOffsetY = 3, -14
 const PlayerHeadDrawOffsetX, PlayerHeadDrawOffsetY = 4, -9
 
-func (p *P) Draw(screen *ebiten.Image) {
+func (p *P) Draw(screen *ebiten.Image, cam f64.Vec2) {
+	x, y := p.Pos[0]-cam[0], p.Pos[1]-cam[1]
 	p.drawOp.GeoM.Reset()
-	p.drawOp.GeoM.Translate(p.Pos[0]+PlayerDrawOffsetX, p.Pos[1]+PlayerDrawOffsetY)
+	p.drawOp.GeoM.Translate(x+PlayerDrawOffsetX, y+PlayerDrawOffsetY)
 	if p.Dead {
 		p.drawOp.GeoM.Translate(2, 5)
 		screen.DrawImage(p.DeadBody.Frame(), p.drawOp)
 		p.drawOp.GeoM.Translate(PlayerHeadDrawOffsetX, PlayerHeadDrawOffsetY)
 		screen.DrawImage(p.DeadHead.Frame(), p.drawOp)
-		p.Effect.Draw(screen)
+		p.Effect.Draw(screen, cam)
 		return
 	}
 	if p.Direction == direction.Left || p.Direction == direction.Front {
@@ -158,27 +161,28 @@ func (p *P) Draw(screen *ebiten.Image) {
 		screen.DrawImage(p.Helmet.Frame(), p.drawOp)
 	}
 	if p.local == nil {
-		p.DrawPlayerHPMP(screen)
+		p.DrawPlayerHPMP(screen, cam)
 
 	} else {
-		p.DrawNick(screen)
+		p.DrawNick(screen, cam)
 	}
-	p.Effect.Draw(screen)
+	p.Effect.Draw(screen, cam)
 	if p.chatMsg != "" {
 		off := len(p.chatMsg) * 3
-		text.PrintAt(screen, p.chatMsg, int(p.Pos[0])+16-off, int(p.Pos[1]-40))
+		text.PrintAt(screen, p.chatMsg, int(x)+16-off, int(y-40))
 	}
 }
 
-func (p *P) DrawNick(screen *ebiten.Image) {
-	tx, ty := int(p.Pos[0]+14), int(p.Pos[1]+26)
+func (p *P) DrawNick(screen *ebiten.Image, cam f64.Vec2) {
+	tx, ty := int(p.Pos[0]-cam[0]+14), int(p.Pos[1]-cam[1]+26)
 	xoff := (len(p.Nick) * 3)
 	ebitenutil.DebugPrintAt(screen, p.Nick, tx-xoff, ty)
 }
 
-func (p *P) DrawPlayerHPMP(screen *ebiten.Image) {
+func (p *P) DrawPlayerHPMP(screen *ebiten.Image, cam f64.Vec2) {
+	x, y := p.Pos[0]-cam[0], p.Pos[1]-cam[1]
 	p.drawOp.GeoM.Reset()
-	p.drawOp.GeoM.Translate(p.Pos[0]+PlayerDrawOffsetX, p.Pos[1]+PlayerDrawOffsetY)
+	p.drawOp.GeoM.Translate(x+PlayerDrawOffsetX, y+PlayerDrawOffsetY)
 	p.drawOp.GeoM.Translate(-2, 45)
 	hpx, mpx := p.HPImg.Bounds().Max.X, p.MPImg.Bounds().Max.X
 	hpx, mpx = p.Client.HP*hpx/p.Client.MaxHP, p.Client.MP*mpx/p.Client.MaxMP
@@ -190,7 +194,7 @@ func (p *P) DrawPlayerHPMP(screen *ebiten.Image) {
 	screen.DrawImage(p.HPImg.SubImage(hpRect).(*ebiten.Image), p.drawOp)
 	p.drawOp.GeoM.Translate(0, 5)
 	screen.DrawImage(p.MPImg.SubImage(mpRect).(*ebiten.Image), p.drawOp)
-	tx, ty := int(p.Pos[0]+14), int(p.Pos[1]+38)
+	tx, ty := int(x+14), int(y+38)
 	xoff := (len(p.Nick) * 3) - 1
 	ebitenutil.DebugPrintAt(screen, p.Nick, tx-xoff, ty)
 }
@@ -248,16 +252,31 @@ func (p *P) SetSoundboard(sb audio2d.AudioMixer) {
 }
 
 type Step struct {
-	To        typ.P
-	Dir       direction.D
-	Expect    bool
-	Allowed   bool
-	Confirmed bool
-}
+	To  typ.P
+	Dir direction.D
+	// T is the server time of the step, 0 to walk it as soon as possible
+	T uint32
+}
+
+const (
+	// frameMs is what MoveSpeed (pixels per frame) is measured in
+	frameMs = 1000.0 / 60
+	// CatchUp is how much faster we walk for each step waiting in the queue
+	CatchUp = 0.25
+	// MaxCatchUp is the fastest we walk to catch up
+	MaxCatchUp = 2.0
+)
 
-func (p *P) WalkSteps(g *grid.Grid) {
+// WalkSteps walks the steps, now is the server time we are drawing at,
+// a step doesnt start before its time and if they pile up we walk faster.
+func (p *P) WalkSteps(g *grid.Grid, now uint32) {
+	elapsed := float64(int32(now-p.walkT)) / frameMs
+	if p.walkT == 0 || elapsed < 0 || elapsed > 4 {
+		elapsed = 1
+	}
+	p.walkT = now
 	if p.leftForMove > 0 {
-		vel := p.MoveSpeed
+		vel := p.MoveSpeed * elapsed * min(1+CatchUp*float64(len(p.steps)), MaxCatchUp)
 		if p.leftForMove < vel {
 			vel = p.leftForMove
 		}
@@ -281,6 +300,10 @@ func (p *P) WalkSteps(g *grid.Grid) {
 		return
 	}
 	step := p.steps[0]
+	if step.T != 0 && int32(now-step.T) < 0 {
+		p.Walking = false
+		return
+	}
 	p.steps = p.steps[1:]
 	if p.X == step.To.X && p.Y == step.To.Y {
 		p.Direction = step.Dir
@@ -310,10 +333,39 @@ func (p *P) WalkSteps(g *grid.Grid) {
 	p.X, p.Y = step.To.X, step.To.Y
 }
 
+// SyncTo makes the player end up at to, walking there if its
+// next to where its already going, jumping there if not.
+func (p *P) SyncTo(g *grid.Grid, to typ.P, dir direction.D) {
+	last, lastDir := typ.P{X: p.X, Y: p.Y}, p.Direction
+	if len(p.steps) != 0 {
+		last, lastDir = p.steps[len(p.steps)-1].To, p.steps[len(p.steps)-1].Dir
+	}
+	if last == to && lastDir == dir {
+		return
+	}
+	dx, dy := last.X-to.X, last.Y-to.Y
+	if last == to || dx*dx+dy*dy == 1 {
+		p.steps = append(p.steps, Step{To: to, Dir: dir})
+		return
+	}
+	p.steps = p.steps[:0]
+	if g.GetSlot(0, typ.P{X: p.X, Y: p.Y}) == uint16(p.ID) {
+		g.SetSlot(0, typ.P{X: p.X, Y: p.Y}, 0)
+	}
+	g.SetSlot(0, to, uint16(p.ID))
+	p.X, p.Y = to.X, to.Y
+	p.Pos[0] = float64(p.X * constants.TileSize)
+	p.Pos[1] = float64(p.Y * constants.TileSize)
+	p.leftForMove = 0
+	p.Walking = false
+	p.Direction = dir
+}
+
 func (p *P) AddStep(e *msgs.EventPlayerMoved) {
 	p.steps = append(p.steps, Step{
 		To:  e.Pos,
 		Dir: e.Dir,
+		T:   e.T,
 	})
 }
 
@@ -392,6 +444,9 @@ func CreateFromLogin(local *P, a *msgs.EventNewPlayer) *P {
 		p:      p,
 		active: make([]texture.Effect, 0),
 	}
+	if a.NPC != npc.None {
+		p.dressNPC(a.NPC)
+	}
 	return p
 }
 
@@ -423,9 +478,47 @@ func CreatePlayerSpawned(local *P, a *msgs.EventPlayerSpawned) *P {
 		p:      p,
 		active: make([]texture.Effect, 0),
 	}
+	if a.NPC != npc.None {
+		p.dressNPC(a.NPC)
+	}
 	return p
 }
 
+// npcLook is the skin of an npc and the gear it carries
+type npcLook struct {
+	Body, Head, DeadBody, DeadHead assets.Image
+	Weapon, Shield                 assets.Image
+}
+
+var npcLooks = [npc.Len]npcLook{
+	npc.Skeleton: {
+		Body: assets.SkeletonBody, Head: assets.SkeletonHead,
+		DeadBody: assets.SkeletonDeadBody, DeadHead: assets.SkeletonDeadHead,
+		Weapon: assets.WarAxe,
+	},
+	npc.DarkKnight: {
+		Body: assets.DarkKnightBody, Head: assets.DarkKnightHead,
+		DeadBody: assets.DarkKnightDeadBody, DeadHead: assets.DarkKnightDeadHead,
+		Weapon: assets.WarAxe, Shield: assets.TowerShield,
+	},
+}
+
+// dressNPC puts the skin of its kind on the npc, its armor is in the body
+func (p *P) dressNPC(t npc.Type) {
+	look := npcLooks[t]
+	p.Armor, p.Helmet, p.Weapon, p.Shield = nil, nil, nil, nil
+	p.NakedBody = texture.LoadAnimation(look.Body)
+	p.Head = texture.LoadStill(look.Head)
+	p.DeadBody = texture.LoadAnimation(look.DeadBody)
+	p.DeadHead = texture.LoadStill(look.DeadHead)
+	if look.Weapon != assets.Nothing {
+		p.Weapon = texture.LoadAnimation(look.Weapon)
+	}
+	if look.Shield != assets.Nothing {
+		p.Shield = texture.LoadAnimation(look.Shield)
+	}
+}
+
 // maps.YSortable
 func (p *P) ValueY() float64 { return p.Pos[1] }
 
@@ -536,10 +629,10 @@ func (pfx *PEffects) Update(counter int) {
 	}
 }
 
-func (pfx *PEffects) Draw(screen *ebiten.Image) {
+func (pfx *PEffects) Draw(screen *ebiten.Image, cam f64.Vec2) {
 	for _, fx := range pfx.active {
 		pfx.drawOp.GeoM.Reset()
-		pfx.drawOp.GeoM.Translate(pfx.p.Pos[0], pfx.p.Pos[1])
+		pfx.drawOp.GeoM.Translate(pfx.p.Pos[0]-cam[0], pfx.p.Pos[1]-cam[1])
 		screen.DrawImage(fx.EffectFrame(), fx.EffectOpt(pfx.drawOp))
 	}
 }
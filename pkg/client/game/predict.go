@@ -0,0 +1,129 @@
+package game
+
+import (
+	"log"
+	"math"
+
+	"github.com/rywk/minigoao/pkg/constants"
+	"github.com/rywk/minigoao/pkg/constants/direction"
+	"github.com/rywk/minigoao/pkg/msgs"
+	"github.com/rywk/minigoao/pkg/pathfind"
+	"github.com/rywk/minigoao/pkg/typ"
+)
+
+// MaxPendingMoves is how many moves we walk ahead of the server acks
+const MaxPendingMoves = 4
+
+type pendingMove struct {
+	seq uint16
+	dir direction.D
+}
+
+// Prediction has the moves we sent that the server didnt answer yet.
+// We walk them right away, when an answer comes we start over from the
+// position the server says and walk again the ones still pending.
+type Prediction struct {
+	seq     uint16
+	pending []pendingMove
+	// last seq answered or sent before a Reset, answers up to it are stale
+	done uint16
+}
+
+func (pr *Prediction) Add(d direction.D) uint16 {
+	pr.seq++
+	pr.pending = append(pr.pending, pendingMove{seq: pr.seq, dir: d})
+	return pr.seq
+}
+
+// Ack drops the moves up to seq, the seq wraps so its compared as a difference
+func (pr *Prediction) Ack(seq uint16) {
+	pr.done = seq
+	i := 0
+	for i < len(pr.pending) && int16(pr.pending[i].seq-seq) <= 0 {
+		i++
+	}
+	pr.pending = pr.pending[i:]
+}
+
+// Reset drops the pending moves, the seq goes on so a late
+// answer to one of them isnt taken for one of the new ones
+func (pr *Prediction) Reset() {
+	pr.pending = pr.pending[:0]
+	pr.done = pr.seq
+}
+
+// Stale is true for an answer to a move that was already answered or reset
+func (pr *Prediction) Stale(seq uint16) bool {
+	return int16(seq-pr.done) <= 0
+}
+
+func (pr *Prediction) Full() bool {
+	return len(pr.pending) >= MaxPendingMoves
+}
+
+// predictStep is where a move from to d should end, false if we think the server says no
+func (g *Game) predictStep(from typ.P, d direction.D) (typ.P, bool) {
+	np := pathfind.Step(from, d)
+	if np.Out(g.world.Space.Rect) ||
+		g.player.Inmobilized ||
+		g.world.Space.GetSlot(1, np) != 0 ||
+		g.world.Space.GetSlot(0, np) != 0 {
+		return from, false
+	}
+	return np, true
+}
+
+func (g *Game) MovementResponse(ev *msgs.EventMoveOk) {
+	if g.predict.Stale(ev.Seq) {
+		// from before a relogin, where it puts us is old news
+		return
+	}
+	g.predict.Ack(ev.Seq)
+	if ev.Ok {
+		// if were allowed to move then we can change this already in any case
+		g.player.Inmobilized = false
+	}
+	g.reconcile(ev.Pos)
+}
+
+// reconcile walks the pending moves again from the confirmed position,
+// if we end up somewhere else than where we are the prediction was wrong.
+func (g *Game) reconcile(confirmed typ.P) {
+	pos := confirmed
+	for _, m := range g.predict.pending {
+		pos, _ = g.predictStep(pos, m.dir)
+	}
+	if pos.X == g.player.X && pos.Y == g.player.Y {
+		return
+	}
+	log.Printf("prediction missed, at %v should be %v\n", typ.P{X: g.player.X, Y: g.player.Y}, pos)
+	g.clickMove.Cancel()
+	g.correctTo(pos)
+}
+
+// correctTo puts the player in tile to, walking there if its
+// one straight step from where we are drawing it, if not it jumps.
+func (g *Game) correctTo(to typ.P) {
+	g.player.X, g.player.Y = to.X, to.Y
+	tx, ty := float64(to.X*constants.TileSize), float64(to.Y*constants.TileSize)
+	dx, dy := tx-g.player.Pos[0], ty-g.player.Pos[1]
+	dist := math.Abs(dx) + math.Abs(dy)
+	if (dx != 0 && dy != 0) || dist > constants.TileSize {
+		g.player.Pos[0], g.player.Pos[1] = tx, ty
+		g.leftForMove = 0
+		g.player.Walking = false
+		return
+	}
+	switch {
+	case dy > 0:
+		g.lastDir = direction.Front
+	case dy < 0:
+		g.lastDir = direction.Back
+	case dx < 0:
+		g.lastDir = direction.Left
+	case dx > 0:
+		g.lastDir = direction.Right
+	}
+	g.leftForMove = dist
+	g.player.Walking = dist != 0
+}
@@ -0,0 +1,25 @@
+package game
+
+import (
+	"testing"
+
+	"github.com/rywk/minigoao/pkg/constants/direction"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPredictionReset(t *testing.T) {
+	pr := &Prediction{}
+	old := pr.Add(direction.Left)
+	pr.Add(direction.Left)
+	pr.Reset()
+	require.Empty(t, pr.pending)
+
+	// the answer to a move from before the reset doesnt touch the new ones
+	seq := pr.Add(direction.Right)
+	require.Greater(t, seq, old)
+	require.True(t, pr.Stale(old))
+	require.False(t, pr.Stale(seq))
+	pr.Ack(seq)
+	require.Empty(t, pr.pending)
+	require.True(t, pr.Stale(seq))
+}
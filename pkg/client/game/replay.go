@@ -0,0 +1,205 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/rywk/minigoao/pkg/client/game/text"
+	"github.com/rywk/minigoao/pkg/msgs"
+	"github.com/rywk/minigoao/pkg/typ"
+	"golang.org/x/image/math/f64"
+)
+
+// Replay plays a recording of the server, its events go through
+// ProcessEventQueue like the ones of a live game, at the time
+// they happened in the recording.
+type Replay struct {
+	name  string
+	f     *os.File
+	r     *msgs.CaptureReader
+	next  *msgs.CaptureRecord
+	login *msgs.EventPlayerLogin
+
+	// time in the recording, it goes speed times the real time
+	t      time.Duration
+	last   time.Time
+	speed  int // index in ReplaySpeeds
+	paused bool
+	ended  bool
+
+	// the map chunks we got so far, the map asks for them again
+	// when the camera goes away and comes back
+	chunks map[typ.P]*msgs.EventMapChunk
+}
+
+// ReplaySpeeds are the speeds we can watch at, players walk too
+// fast for WalkSteps past 4.
+var ReplaySpeeds = []float64{0.25, 0.5, 1, 2, 4}
+
+const replayNormalSpeed = 2
+
+// OpenReplay reads a recording up to its login, the first record
+func OpenReplay(path string) (*Replay, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := msgs.NewCaptureReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	rec, err := r.Next()
+	if err != nil || rec.Event != msgs.EPlayerLogin {
+		f.Close()
+		return nil, errors.New("recording doesnt start with a login")
+	}
+	login, err := msgs.Decode(rec.Event, rec.Data)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	rp := &Replay{
+		f:      f,
+		r:      r,
+		login:  login.(*msgs.EventPlayerLogin),
+		speed:  replayNormalSpeed,
+		chunks: make(map[typ.P]*msgs.EventMapChunk),
+	}
+	rp.name = rp.login.Nick
+	rp.read()
+	return rp, nil
+}
+
+// read gets the next record, a bad or cut one ends the replay
+func (rp *Replay) read() {
+	rec, err := rp.r.Next()
+	if err != nil {
+		if err != io.EOF {
+			log.Printf("replay %v: %v\n", rp.name, err)
+		}
+		rp.next, rp.ended = nil, true
+		return
+	}
+	rp.next = rec
+}
+
+// Now is the time in the recording in ms, what the players walk with
+func (rp *Replay) Now() uint32 {
+	return uint32(rp.t.Milliseconds())
+}
+
+func (rp *Replay) Close() {
+	rp.f.Close()
+}
+
+// StartReplay opens the recording at path and watches it instead of playing
+func (g *Game) StartReplay(path string) error {
+	rp, err := OpenReplay(path)
+	if err != nil {
+		return err
+	}
+	ebiten.SetVsyncEnabled(true)
+	g.mode = ModeReplay
+	g.replay = rp
+	g.world = NewMap(MapConfigFromPlayerLogin(rp.login), func(chunk typ.P) {
+		if c := rp.chunks[chunk]; c != nil {
+			g.eventLock.Lock()
+			g.eventQueue = append(g.eventQueue, &GameMsg{E: msgs.EMapChunk, Data: c})
+			g.eventLock.Unlock()
+		}
+	})
+	// g.player is the camera, it never goes in playersY so its not drawn
+	g.loginPlayers(rp.login)
+	g.ViewPort = f64.Vec2{ScreenWidth, ScreenHeight}
+	g.clock = NewServerClock()
+	g.camera = NewCamera(g)
+	g.chat = NewChat()
+	g.eventQueue = make([]*GameMsg, 0, 100)
+	rp.last = time.Now()
+	return nil
+}
+
+func (g *Game) updateReplay() error {
+	rp := g.replay
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		rp.Close()
+		return ebiten.Termination
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeySpace) {
+		rp.paused = !rp.paused
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyEqual) || inpututil.IsKeyJustPressed(ebiten.KeyNumpadAdd) {
+		rp.speed = min(rp.speed+1, len(ReplaySpeeds)-1)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyMinus) || inpututil.IsKeyJustPressed(ebiten.KeyNumpadSubtract) {
+		rp.speed = max(rp.speed-1, 0)
+	}
+	now := time.Now()
+	// it keeps going after the end so the players finish their steps
+	if !rp.paused {
+		rp.t += time.Duration(float64(now.Sub(rp.last)) * ReplaySpeeds[rp.speed])
+	}
+	rp.last = now
+
+	g.eventLock.Lock()
+	for rp.next != nil && rp.next.T <= rp.t {
+		g.feedReplay(rp.next)
+		rp.read()
+	}
+	g.eventLock.Unlock()
+	// there is no server to disconnect
+	g.ProcessEventQueue()
+
+	g.camera.Update()
+	g.chat.Update(g.mouseX, g.mouseY)
+	for _, p := range g.players {
+		p.WalkSteps(g.world.Space, rp.Now())
+		p.Update(g.counter)
+		p.Effect.Update(g.counter)
+	}
+	sort.Slice(g.playersY, func(i, j int) bool {
+		return g.playersY[i].ValueY() < g.playersY[j].ValueY()
+	})
+	g.counter++
+	return nil
+}
+
+// feedReplay queues a record like WriteEventQueue does with what the server sends
+func (g *Game) feedReplay(rec *msgs.CaptureRecord) {
+	data, err := msgs.Decode(rec.Event, rec.Data)
+	if err != nil {
+		log.Printf("replay %v: %v\n", g.replay.name, err)
+		return
+	}
+	switch ev := data.(type) {
+	case *msgs.EventPlayerMoved:
+		// walked at the time of the recording, not of the server
+		ev.T = uint32(rec.T.Milliseconds())
+	case *msgs.EventMapChunk:
+		g.replay.chunks[ev.Chunk] = ev
+	}
+	g.eventQueue = append(g.eventQueue, &GameMsg{E: rec.Event, Data: data})
+}
+
+func (g *Game) drawReplay(screen *ebiten.Image) {
+	rp := g.replay
+	g.drawWatching(screen)
+	g.chat.Draw(screen, 8, ScreenHeight-8)
+
+	state := fmt.Sprintf("x%v", ReplaySpeeds[rp.speed])
+	if rp.paused {
+		state = "paused"
+	} else if rp.ended {
+		state = "the end"
+	}
+	text.PrintAt(screen, fmt.Sprintf("Replay %v  %v  %v  %v\nspace pause, +/- speed, wasd/arrows move, tab or click follow, esc quit",
+		rp.name, rp.t.Truncate(100*time.Millisecond), state, g.camera.Status()), 0, 0)
+}
@@ -0,0 +1,112 @@
+package game
+
+import (
+	"errors"
+	"image/color"
+	"log"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/rywk/minigoao/pkg/client/game/text"
+	"github.com/rywk/minigoao/pkg/conc"
+	"github.com/rywk/minigoao/pkg/msgs"
+	"github.com/rywk/minigoao/pkg/typ"
+)
+
+// ResumeGrace is how long the server keeps our player after the connection drops
+const ResumeGrace = 30 * time.Second
+
+// reconnect is the state while we try to get our session back
+type reconnect struct {
+	since   time.Time
+	next    time.Time
+	wait    time.Duration
+	tries   int
+	dialing bool
+	result  chan Login
+}
+
+func (g *Game) startReconnect() {
+	log.Printf("connection lost, trying to resume\n")
+	g.clickMove.Cancel()
+	g.reconnect = &reconnect{
+		since:  time.Now(),
+		next:   time.Now(),
+		wait:   500 * time.Millisecond,
+		result: make(chan Login, 1),
+	}
+}
+
+// updateReconnect keeps trying to resume until it works or the server already forgot about us
+func (g *Game) updateReconnect() error {
+	r := g.reconnect
+	if r.dialing {
+		login, ok := conc.Check(r.result)
+		if !ok {
+			return nil
+		}
+		r.dialing = false
+		if login.err == nil {
+			g.ms = login.ms
+			g.Resume(login.data)
+			g.reconnect = nil
+			return nil
+		}
+		log.Printf("resume try %v: %v\n", r.tries, login.err)
+		if errors.As(login.err, &LoginDenied{}) {
+			return login.err
+		}
+	}
+	if time.Since(r.since) > ResumeGrace {
+		return errors.New("could not resume the session")
+	}
+	if time.Now().Before(r.next) {
+		return nil
+	}
+	r.tries++
+	r.dialing = true
+	r.next = time.Now().Add(r.wait)
+	r.wait = min(r.wait*2, 5*time.Second)
+	go g.Connect(g.nickTyper.Text, g.passTyper.Text, g.serverTyper.Text, g.resumeToken, r.result)
+	return nil
+}
+
+// Resume starts over from the login we got back, keeping the map we already have.
+func (g *Game) Resume(e *msgs.EventPlayerLogin) {
+	g.relogin(e)
+	g.world.DropPending()
+	g.WaitingPong = false
+
+	g.eventLock.Lock()
+	g.eventQueue = g.eventQueue[:0]
+	g.eventLock.Unlock()
+	g.startConn()
+}
+
+// relogin starts over from a login keeping the map, after a resume
+// or when the server moves us somewhere else.
+func (g *Game) relogin(e *msgs.EventPlayerLogin) {
+	for y := int32(0); y < g.world.Space.Rect.Max.Y; y++ {
+		for x := int32(0); x < g.world.Space.Rect.Max.X; x++ {
+			g.world.Space.SetSlot(0, typ.P{X: x, Y: y}, 0)
+		}
+	}
+	g.playersY = g.playersY[:0]
+	g.loginPlayers(e)
+	g.playersY = append(g.playersY, g.player)
+	g.snaps = &Snapshots{}
+	g.clock = NewServerClock()
+	g.predict.Reset()
+	g.leftForMove = 0
+	g.lastMove = time.Now()
+	g.clickMove.Cancel()
+}
+
+func (g *Game) drawReconnect(screen *ebiten.Image) {
+	if g.reconnect == nil {
+		return
+	}
+	left := ResumeGrace - time.Since(g.reconnect.since)
+	text.PrintBigAtCol(screen, "Reconnecting...", HalfScreenX-80, HalfScreenY-120, color.RGBA{230, 200, 60, 255})
+	text.PrintAt(screen, left.Round(time.Second).String()+" left", HalfScreenX-30, HalfScreenY-95)
+}
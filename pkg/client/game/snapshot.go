@@ -0,0 +1,49 @@
+package game
+
+import (
+	"log"
+
+	"github.com/rywk/minigoao/pkg/msgs"
+)
+
+// snapshotHistory has to cover the snapshots sent while our ack gets to the server
+const snapshotHistory = 32
+
+// Snapshots keeps the last snapshots we applied, the server
+// sends deltas against the last one we acked.
+type Snapshots struct {
+	history [snapshotHistory]struct {
+		seq uint32
+		s   msgs.Snapshot
+	}
+}
+
+func (g *Game) ApplySnapshot(ev *msgs.EventSnapshot) {
+	var base msgs.Snapshot
+	if ev.Base != 0 {
+		h := g.snaps.history[ev.Base%snapshotHistory]
+		if h.seq != ev.Base {
+			log.Printf("snapshot %v base %v is gone, asking for a full one\n", ev.Seq, ev.Base)
+			g.outQueue <- &GameMsg{E: msgs.ESnapshotAck, Data: uint32(0)}
+			return
+		}
+		base = h.s
+	}
+	s := ev.Apply(base)
+	h := &g.snaps.history[ev.Seq%snapshotHistory]
+	h.seq, h.s = ev.Seq, s
+	g.syncPlayers(s)
+	g.outQueue <- &GameMsg{E: msgs.ESnapshotAck, Data: ev.Seq}
+}
+
+// syncPlayers makes the players we have match the snapshot
+func (g *Game) syncPlayers(s msgs.Snapshot) {
+	for id := range g.players {
+		if _, ok := s[id]; !ok {
+			g.DespawnPlayer(id)
+		}
+	}
+	for _, e := range s {
+		g.AddToGame(&e)
+	}
+}
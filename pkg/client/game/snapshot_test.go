@@ -0,0 +1,39 @@
+package game
+
+import (
+	"testing"
+
+	"github.com/rywk/minigoao/pkg/client/game/player"
+	"github.com/rywk/minigoao/pkg/constants/direction"
+	"github.com/rywk/minigoao/pkg/msgs"
+	"github.com/rywk/minigoao/pkg/typ"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpawnAndSnapshotSamePlayer(t *testing.T) {
+	g := &Game{
+		world:    NewMap(&MapConfig{Width: 32, Height: 32}, func(typ.P) {}),
+		players:  make(map[uint16]*player.P),
+		snaps:    &Snapshots{},
+		outQueue: make(chan *GameMsg, 10),
+	}
+	spawn := msgs.EventNewPlayer{ID: 7, Nick: "someone", Pos: typ.P{X: 5, Y: 5}, Dir: direction.Front}
+	g.AddToGame(&spawn)
+
+	moved := spawn
+	moved.Pos, moved.Dir = typ.P{X: 10, Y: 10}, direction.Back
+	g.ApplySnapshot(&msgs.EventSnapshot{Seq: 1, Spawned: []msgs.EventNewPlayer{moved}})
+
+	require.Len(t, g.players, 1)
+	require.Len(t, g.playersY, 1)
+	p := g.players[7]
+	require.Same(t, p, g.playersY[0])
+	require.Equal(t, typ.P{X: 10, Y: 10}, typ.P{X: p.X, Y: p.Y})
+	require.Equal(t, uint16(7), g.world.Space.GetSlot(0, typ.P{X: 10, Y: 10}))
+	require.Equal(t, uint16(0), g.world.Space.GetSlot(0, typ.P{X: 5, Y: 5}))
+
+	// and the spawn again after the snapshot
+	g.AddToGame(&spawn)
+	require.Len(t, g.playersY, 1)
+	require.Equal(t, uint16(7), g.world.Space.GetSlot(0, typ.P{X: 5, Y: 5}))
+}
@@ -0,0 +1,67 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/rywk/minigoao/pkg/client/game/text"
+	"github.com/rywk/minigoao/pkg/msgs"
+	"golang.org/x/image/math/f64"
+)
+
+// StartSpectate is StartGame for when we only watch, the server
+// gives us no character, g.player is just the camera and it tells
+// the server where it is so we get what happens around it.
+func (g *Game) StartSpectate(login *msgs.EventPlayerLogin) {
+	ebiten.SetVsyncEnabled(true)
+	ebiten.SetFullscreen(g.fullscreen)
+	g.mode = ModeSpectate
+	g.eventQueue = make([]*GameMsg, 0, 100)
+	g.outQueue = make(chan *GameMsg, 100)
+	g.eventLock = sync.Mutex{}
+	// g.player never goes in playersY so its not drawn
+	g.Login(login)
+	g.ViewPort = f64.Vec2{ScreenWidth, ScreenHeight}
+	g.clock = NewServerClock()
+	g.camera = NewCamera(g)
+	g.chat = NewChat()
+	g.startConn()
+}
+
+func (g *Game) updateSpectate() error {
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		g.ms.Close()
+		return g.backToRegister(errors.New("esc exit"))
+	}
+	if err := g.ProcessEventQueue(); err != nil {
+		return g.backToRegister(err)
+	}
+	g.pingServer()
+	g.camera.Update()
+	g.chat.Update(g.mouseX, g.mouseY)
+	if at, ok := g.camera.Moved(); ok {
+		g.outQueue <- &GameMsg{E: msgs.ECameraMove, Data: at}
+	}
+	for _, p := range g.players {
+		p.WalkSteps(g.world.Space, g.clock.Render())
+		p.Update(g.counter)
+		p.Effect.Update(g.counter)
+	}
+	sort.Slice(g.playersY, func(i, j int) bool {
+		return g.playersY[i].ValueY() < g.playersY[j].ValueY()
+	})
+	g.counter++
+	return nil
+}
+
+func (g *Game) drawSpectate(screen *ebiten.Image) {
+	g.drawWatching(screen)
+	text.PrintAt(screen, fmt.Sprintf("%vFPS\n%v", int(ebiten.ActualFPS()), g.latency), 0, 0)
+	text.PrintAt(screen, fmt.Sprintf("Online: %v", g.onlines), 50, 0)
+	g.chat.Draw(screen, 8, ScreenHeight-8)
+	text.PrintAt(screen, fmt.Sprintf("Spectating, %v\nwasd/arrows move, tab or click follow, esc quit", g.camera.Status()), 0, 30)
+}
@@ -419,6 +419,10 @@ type Hud struct {
 
 	lastHudPotion     time.Time
 	hudPotionCooldown time.Duration
+
+	zone            *msgs.Zone
+	zoneBanner      string
+	zoneBannerAlpha float64
 }
 
 func NewHud(g *Game) *Hud {
@@ -638,6 +642,7 @@ func (s *Hud) Update() {
 	if s.potionAlpha > 0 {
 		s.potionAlpha -= .04
 	}
+	s.updateZone()
 
 	hp := mapValue(float64(s.g.client.HP), 0, float64(s.g.client.MaxHP), float64(s.barOffsetStart), float64(s.hpBar.Bounds().Max.X-s.barOffsetEnd))
 	s.hpBarRect = image.Rect(s.hpBar.Bounds().Min.X, s.hpBar.Bounds().Min.Y, int(hp), s.hpBar.Bounds().Max.Y)
@@ -700,6 +705,53 @@ func (s *Hud) Draw(screen *ebiten.Image) {
 	if s.optionsOpen {
 		s.options.Draw(screen)
 	}
+	s.drawZone(screen)
+}
+
+func zoneName(z *msgs.Zone) string {
+	if z == nil {
+		return ""
+	}
+	return z.Name
+}
+
+// updateZone checks if we walked into another zone to show it for a bit
+func (s *Hud) updateZone() {
+	if s.zoneBannerAlpha > 0 {
+		s.zoneBannerAlpha -= .005
+	}
+	z := s.g.world.ZoneAt(typ.P{X: s.g.player.X, Y: s.g.player.Y})
+	if zoneName(z) == zoneName(s.zone) && z.Safe() == s.zone.Safe() {
+		s.zone = z
+		return
+	}
+	switch {
+	case z != nil && z.Safe():
+		s.zoneBanner = fmt.Sprintf("Entering %v - Safe zone", z.Name)
+	case z != nil:
+		s.zoneBanner = fmt.Sprintf("Entering %v", z.Name)
+	case s.zone.Safe():
+		s.zoneBanner = fmt.Sprintf("Leaving %v - PvP", s.zone.Name)
+	default:
+		s.zoneBanner = fmt.Sprintf("Leaving %v", s.zone.Name)
+	}
+	s.zone = z
+	s.zoneBannerAlpha = 1
+}
+
+func (s *Hud) drawZone(screen *ebiten.Image) {
+	if s.zoneBannerAlpha > 0 {
+		x := HalfScreenX - len(s.zoneBanner)*12/2
+		text.PrintBigAtCol(screen, s.zoneBanner, x, 60, color.RGBA{255, 255, 255, uint8(255 * s.zoneBannerAlpha)})
+	}
+	if s.zone == nil {
+		return
+	}
+	y := int(s.y) - 16
+	text.PrintAt(screen, s.zone.Name, 6, y)
+	if s.zone.Safe() {
+		text.PrintColAt(screen, "Safe zone", 12+len(s.zone.Name)*6, y, color.RGBA{60, 200, 80, 255})
+	}
 }
 
 const (
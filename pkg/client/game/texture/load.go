@@ -326,6 +326,29 @@ var (
 	}
 )
 
+// npcSkins have the frames of the default skin they take the place of
+var npcSkins = []struct {
+	a, like asset.Image
+	img     []byte
+}{
+	{asset.SkeletonBody, asset.NakedBody, img.SkeletonBody_png},
+	{asset.SkeletonHead, asset.Head, img.SkeletonHead_png},
+	{asset.SkeletonDeadBody, asset.DeadBody, img.SkeletonDeadBody_png},
+	{asset.SkeletonDeadHead, asset.DeadHead, img.SkeletonDeadHead_png},
+	{asset.DarkKnightBody, asset.NakedBody, img.DarkKnightBody_png},
+	{asset.DarkKnightHead, asset.Head, img.DarkKnightHead_png},
+	{asset.DarkKnightDeadBody, asset.DeadBody, img.DarkKnightDeadBody_png},
+	{asset.DarkKnightDeadHead, asset.DeadHead, img.DarkKnightDeadHead_png},
+}
+
+func init() {
+	for _, s := range npcSkins {
+		cfg := assetConfig[s.like]
+		cfg.img = s.img
+		assetConfig[s.a] = cfg
+	}
+}
+
 var loaded = &sync.Map{}
 
 func LoadAnimation(a asset.Image) A {
@@ -376,6 +399,12 @@ func LoadTexture(a asset.Image) T {
 	return NewTexture(ei, cfg.c)
 }
 
+// Size returns the width and height in pixels of the texture of an asset.
+func Size(a asset.Image) (int, int) {
+	c := assetConfig[a].c
+	return c.Width, c.Height
+}
+
 func LoadEffect(a asset.Image) Effect {
 	v, ok := loaded.Load(a)
 	if ok {
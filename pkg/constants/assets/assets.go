@@ -43,13 +43,33 @@ const (
 	Shroom
 	Tree1
 	// ---
+	// Mark
+	// Solid blocks end here, new ones go right before it
+	SolidBlocksEnd
+
+	// NPC skins, after the blocks so the ids saved in the maps dont move
+	SkeletonBody
+	SkeletonHead
+	SkeletonDeadBody
+	SkeletonDeadHead
+	DarkKnightBody
+	DarkKnightHead
+	DarkKnightDeadBody
+	DarkKnightDeadHead
 
 	// Can be used as the total of assests
 	Len
 )
 
+// What the map editor can paint on each layer, the clients have no
+// texture for anything else there.
+var (
+	GroundTiles = []Image{Nothing, Grass, Tiletest}
+	StuffTiles  = []Image{Nothing, Shroom}
+)
+
 func IsSolid(a Image) bool {
-	return a > SolidBlocks && a < Len
+	return a > SolidBlocks && a < SolidBlocksEnd
 }
 
 func AssetName(a Image) string {
@@ -0,0 +1,20 @@
+package npc
+
+type Type uint8
+
+const (
+	None Type = iota
+	Skeleton
+	DarkKnight
+	Len
+)
+
+var npcs = [Len]string{
+	"None",
+	"Skeleton",
+	"Dark Knight",
+}
+
+func (t Type) String() string {
+	return npcs[t]
+}
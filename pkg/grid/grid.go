@@ -32,7 +32,7 @@ func NewGrid(w, h int32, layers uint8) *Grid {
 		for j := range s.grid[i] {
 			s.grid[i][j].p.Set(int32(i), int32(j))
 			s.grid[i][j].Layers = make([]uint16, layers)
-			s.grid[i][j].obs = []chan Event{}
+			s.grid[i][j].obs = []*Obs{}
 		}
 	}
 	return &s
@@ -60,28 +60,32 @@ type Tile struct {
 	Layers []uint16
 
 	obsLock sync.RWMutex
-	obs     []chan Event
+	obs     []*Obs
 }
 
 // since the server was re arched we are not accessing this concurrently we process 1 event after the other
 
-func (l *Tile) AddObserver(c chan Event) {
+func (l *Tile) AddObserver(o *Obs) {
+	if o.notify == nil {
+		return
+	}
 	//l.obsLock.Lock()
 	//defer l.obsLock.Unlock()
-	l.obs = append(l.obs, c)
+	l.obs = append(l.obs, o)
 }
 
-func (l *Tile) RemoveObserver(c chan Event) {
+func (l *Tile) RemoveObserver(o *Obs) {
 	//l.obsLock.Lock()
 	//defer l.obsLock.Unlock()
-	l.obs = removeObs(l.obs, c)
+	l.obs = removeObs(l.obs, o)
 }
 
 func (l *Tile) NotifyTile(ev Event) {
 	//l.obsLock.RLock()
 	//defer l.obsLock.RUnlock()
-	for _, c := range l.obs {
-		c <- ev
+	// notify must not block, it runs inside the game loop
+	for _, o := range l.obs {
+		o.notify(ev)
 	}
 }
 
@@ -145,7 +149,7 @@ func (g *Grid) Unset(layer int, p typ.P) error {
 	return nil
 }
 
-func removeObs(obss []chan Event, obs chan Event) []chan Event {
+func removeObs(obss []*Obs, obs *Obs) []*Obs {
 	i := 0
 	for _, o := range obss {
 		if obs != o {
@@ -175,10 +179,10 @@ type Obs struct {
 	Width, WidthR   int32
 	Height, HeightR int32
 	View            typ.Rect
-	Events          chan Event
+	notify          func(Event)
 }
 
-func NewObserver(s *Grid, pos typ.P, w, h int32) *Obs {
+func NewObserver(s *Grid, pos typ.P, w, h int32, notify func(Event)) *Obs {
 	if w%2 == 0 || h%2 == 0 {
 		panic("observer must have an odd width and height")
 	}
@@ -189,31 +193,36 @@ func NewObserver(s *Grid, pos typ.P, w, h int32) *Obs {
 		WidthR:  w >> 1,
 		Height:  h,
 		HeightR: h >> 1,
-		Events:  make(chan Event, w*h), // every tile sends a message at the same time we dont block
-	}
-	sx, sy := pos.X-o.WidthR, pos.Y-o.HeightR
-	ex, ey := pos.X+o.WidthR, pos.Y+o.HeightR
-	if sx < 0 {
-		sx = 0
-	}
-	if sy < 0 {
-		sy = 0
+		notify:  notify,
 	}
-	if ex >= int32(s.w) {
-		ex = int32(s.w - 1)
+	o.updateView()
+	for x := o.View.Min.X; x <= o.View.Max.X; x++ {
+		for y := o.View.Min.Y; y <= o.View.Max.Y; y++ {
+			s.grid[x][y].AddObserver(o)
+		}
 	}
-	if ey >= int32(s.h) {
-		ey = int32(s.h - 1)
+	return o
+}
+
+// NewBlindObserver is an observer that doesnt get the events of the tiles,
+// MoveOne still tells what comes in and out of its view.
+func NewBlindObserver(s *Grid, pos typ.P, w, h int32) *Obs {
+	if w%2 == 0 || h%2 == 0 {
+		panic("observer must have an odd width and height")
 	}
-	for x := sx; x <= ex; x++ {
-		for y := sy; y <= ey; y++ {
-			s.grid[x][y].AddObserver(o.Events)
-		}
+	o := &Obs{
+		s:       s,
+		Pos:     pos,
+		Width:   w,
+		WidthR:  w >> 1,
+		Height:  h,
+		HeightR: h >> 1,
 	}
+	o.updateView()
 	return o
 }
 
-func NewObserverRange(s *Grid, pos typ.P, w, h int32, fn func(*Tile)) *Obs {
+func NewObserverRange(s *Grid, pos typ.P, w, h int32, fn func(*Tile), notify func(Event)) *Obs {
 	if w%2 == 0 || h%2 == 0 {
 		panic("observer must have an odd width and height")
 	}
@@ -224,7 +233,7 @@ func NewObserverRange(s *Grid, pos typ.P, w, h int32, fn func(*Tile)) *Obs {
 		WidthR:  w >> 1,
 		Height:  h,
 		HeightR: h >> 1,
-		Events:  make(chan Event, w*h), // every tile sends a message at the same time we dont block
+		notify:  notify,
 	}
 	sx, sy := pos.X-o.WidthR, pos.Y-o.HeightR
 	ex, ey := pos.X+o.WidthR, pos.Y+o.HeightR
@@ -249,13 +258,14 @@ func NewObserverRange(s *Grid, pos typ.P, w, h int32, fn func(*Tile)) *Obs {
 			t, unlock := s.Get(typ.P{X: x, Y: y})
 			fn(t)
 			unlock()
-			t.AddObserver(o.Events)
+			t.AddObserver(o)
 		}
 	}
 	return o
 }
 
 func (o *Obs) MoveOne(d direction.D, fnin, fnout func(x, y int32)) {
+	defer o.updateView()
 	oldPos := o.Pos
 	topX := oldPos.X + o.WidthR
 	topY := oldPos.Y + o.HeightR
@@ -279,29 +289,27 @@ func (o *Obs) MoveOne(d direction.D, fnin, fnout func(x, y int32)) {
 		if d == direction.Front {
 			out, in = botY, topY+1
 			o.Pos.Y++
-			o.View.Move(typ.P{X: 0, Y: 1})
 		} else {
 			out, in = topY, botY-1
 			o.Pos.Y--
-			o.View.Move(typ.P{X: 0, Y: -1})
 		}
 		if in < 0 || in >= o.s.h {
 			for x := botX; x <= topX; x++ {
-				o.s.grid[x][out].RemoveObserver(o.Events)
+				o.s.grid[x][out].RemoveObserver(o)
 				fnout(x, out)
 			}
 			return
 		}
 		if out < 0 || out >= o.s.h {
 			for x := botX; x <= topX; x++ {
-				o.s.grid[x][in].AddObserver(o.Events)
+				o.s.grid[x][in].AddObserver(o)
 				fnin(x, in)
 			}
 			return
 		}
 		for x := botX; x <= topX; x++ {
-			o.s.grid[x][out].RemoveObserver(o.Events)
-			o.s.grid[x][in].AddObserver(o.Events)
+			o.s.grid[x][out].RemoveObserver(o)
+			o.s.grid[x][in].AddObserver(o)
 			fnin(x, in)
 			fnout(x, out)
 		}
@@ -309,29 +317,27 @@ func (o *Obs) MoveOne(d direction.D, fnin, fnout func(x, y int32)) {
 		if d == direction.Right {
 			out, in = botX, topX+1
 			o.Pos.X++
-			o.View.Move(typ.P{X: 1, Y: 0})
 		} else {
 			out, in = topX, botX-1
 			o.Pos.X--
-			o.View.Move(typ.P{X: -1, Y: 0})
 		}
 		if in < 0 || in >= o.s.w {
 			for y := botY; y <= topY; y++ {
-				o.s.grid[out][y].RemoveObserver(o.Events)
+				o.s.grid[out][y].RemoveObserver(o)
 				fnout(out, y)
 			}
 			return
 		}
 		if out < 0 || out >= o.s.w {
 			for y := botY; y <= topY; y++ {
-				o.s.grid[in][y].AddObserver(o.Events)
+				o.s.grid[in][y].AddObserver(o)
 				fnin(in, y)
 			}
 			return
 		}
 		for y := botY; y <= topY; y++ {
-			o.s.grid[out][y].RemoveObserver(o.Events)
-			o.s.grid[in][y].AddObserver(o.Events)
+			o.s.grid[out][y].RemoveObserver(o)
+			o.s.grid[in][y].AddObserver(o)
 			fnin(in, y)
 			fnout(out, y)
 		}
@@ -340,6 +346,16 @@ func (o *Obs) MoveOne(d direction.D, fnin, fnout func(x, y int32)) {
 	}
 }
 
+// updateView sets View to the tiles around Pos that are inside the grid
+func (o *Obs) updateView() {
+	sx, sy := o.Pos.X-o.WidthR, o.Pos.Y-o.HeightR
+	ex, ey := o.Pos.X+o.WidthR, o.Pos.Y+o.HeightR
+	o.View = typ.Rect{
+		Min: typ.P{X: max(sx, 0), Y: max(sy, 0)},
+		Max: typ.P{X: min(ex, o.s.w-1), Y: min(ey, o.s.h-1)},
+	}
+}
+
 func (o *Obs) Nuke() {
 	sx, sy := o.Pos.X-o.WidthR, o.Pos.Y-o.HeightR
 	ex, ey := o.Pos.X+o.WidthR, o.Pos.Y+o.HeightR
@@ -358,10 +374,9 @@ func (o *Obs) Nuke() {
 
 	for y := sy; y <= ey; y++ {
 		for x := sx; x <= ex; x++ {
-			o.s.grid[x][y].RemoveObserver(o.Events)
+			o.s.grid[x][y].RemoveObserver(o)
 		}
 	}
-	close(o.Events)
 }
 
 type Event struct {
@@ -0,0 +1,48 @@
+package msgs
+
+import (
+	"bytes"
+)
+
+// eventWriter is anything encodeAndWrite can put events into,
+// a connection or a Batch.
+type eventWriter interface {
+	Write(event E, data []byte) error
+	WriteWithLen(event E, data []byte) error
+}
+
+// Batch collects encoded events so a bunch of them go out
+// in a single write (one websocket frame), the readers
+// just keep reading events until the frame is done.
+type Batch struct {
+	buf bytes.Buffer
+	n   int
+}
+
+func (b *Batch) Write(event E, data []byte) error {
+	b.n++
+	return write(&b.buf, event, data)
+}
+
+func (b *Batch) WriteWithLen(event E, data []byte) error {
+	b.n++
+	return writeWithLen(&b.buf, event, data)
+}
+
+func (b *Batch) EncodeAndWrite(e E, msg interface{}) error {
+	return encodeAndWrite(b, e, msg)
+}
+
+// Len is the number of events in the batch
+func (b *Batch) Len() int {
+	return b.n
+}
+
+func (b *Batch) Bytes() []byte {
+	return b.buf.Bytes()
+}
+
+func (b *Batch) Reset() {
+	b.buf.Reset()
+	b.n = 0
+}
@@ -0,0 +1,71 @@
+package msgs_test
+
+import (
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/rywk/minigoao/pkg/constants/direction"
+	"github.com/rywk/minigoao/pkg/msgs"
+	"github.com/rywk/minigoao/pkg/typ"
+	"github.com/stretchr/testify/require"
+)
+
+func testBatch(t *testing.T) *msgs.Batch {
+	b := &msgs.Batch{}
+	require.NoError(t, b.EncodeAndWrite(msgs.EPlayerMoved, &msgs.EventPlayerMoved{
+		Dir: direction.Left,
+		ID:  7,
+		Pos: typ.P{X: 3, Y: 4},
+	}))
+	require.NoError(t, b.EncodeAndWrite(msgs.EBroadcastChat, &msgs.EventBroadcastChat{ID: 7, Msg: "hola"}))
+	require.NoError(t, b.EncodeAndWrite(msgs.EPlayerLeaveViewport, uint16(9)))
+	require.Equal(t, 3, b.Len())
+	return b
+}
+
+func requireBatch(t *testing.T, m msgs.Msgs) {
+	im, err := m.Read()
+	require.NoError(t, err)
+	require.Equal(t, msgs.EPlayerMoved, im.Event)
+	require.Equal(t, typ.P{X: 3, Y: 4}, msgs.DecodeEventPlayerMoved(im.Data).Pos)
+
+	im, err = m.Read()
+	require.NoError(t, err)
+	require.Equal(t, msgs.EBroadcastChat, im.Event)
+	chat := msgs.DecodeMsgpack(im.Data, &msgs.EventBroadcastChat{})
+	require.Equal(t, "hola", chat.Msg)
+
+	im, err = m.Read()
+	require.NoError(t, err)
+	require.Equal(t, msgs.EPlayerLeaveViewport, im.Event)
+}
+
+func TestBatchTCP(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	go msgs.New(a).WriteBatch(testBatch(t))
+	requireBatch(t, msgs.New(b))
+}
+
+func TestBatchWebSocketFrame(t *testing.T) {
+	mms, upgrade := msgs.NewUpgraderMiddleware()
+	srv := httptest.NewServer(upgrade)
+	defer srv.Close()
+
+	c, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	require.NoError(t, err)
+	defer c.Close()
+	m, err := mms.NewConn()
+	require.NoError(t, err)
+	defer m.Close()
+
+	// all the events in one frame, then one more frame after it
+	require.NoError(t, c.WriteMessage(websocket.BinaryMessage, testBatch(t).Bytes()))
+	require.NoError(t, c.WriteMessage(websocket.BinaryMessage, testBatch(t).Bytes()))
+	requireBatch(t, m)
+	requireBatch(t, m)
+}
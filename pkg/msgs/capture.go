@@ -0,0 +1,187 @@
+package msgs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// A capture is a file of events with when they happened, the header is
+// the magic and the start time (unix ms), then every record is
+// 4 bytes (uint32) ms since the start, 4 bytes (uint32) conn, 1 byte dir
+// and the event as it goes in the wire.
+var captureMagic = []byte("MGCAP1")
+
+var ErrNotCapture = errors.New("not a capture file")
+
+// Dir is which way an event went
+type Dir uint8
+
+const (
+	DirUp   Dir = iota // client to server
+	DirDown            // server to client
+)
+
+func (d Dir) String() string {
+	if d == DirUp {
+		return "up"
+	}
+	return "down"
+}
+
+type CaptureRecord struct {
+	T     time.Duration
+	Conn  uint32
+	Dir   Dir
+	Event E
+	Data  []byte
+}
+
+// CaptureWriter writes a capture, it can be used from many goroutines
+type CaptureWriter struct {
+	mu    sync.Mutex
+	w     *bufio.Writer
+	c     io.Closer
+	start time.Time
+}
+
+func CreateCapture(path string) (*CaptureWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewCaptureWriter(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	c.c = f
+	return c, nil
+}
+
+func NewCaptureWriter(w io.Writer) (*CaptureWriter, error) {
+	c := &CaptureWriter{w: bufio.NewWriter(w), start: time.Now()}
+	head := append([]byte{}, captureMagic...)
+	head = binary.BigEndian.AppendUint64(head, uint64(c.start.UnixMilli()))
+	if _, err := c.w.Write(head); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Record writes the event with the time since the capture started
+func (c *CaptureWriter) Record(conn uint32, dir Dir, e E, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	head := make([]byte, 4+4+1)
+	binary.BigEndian.PutUint32(head[0:4], uint32(time.Since(c.start).Milliseconds()))
+	binary.BigEndian.PutUint32(head[4:8], conn)
+	head[8] = byte(dir)
+	if _, err := c.w.Write(head); err != nil {
+		return err
+	}
+	if e.Len() == -1 {
+		return writeWithLen(c.w, e, data)
+	}
+	return write(c.w, e, data)
+}
+
+// RecordEvent encodes the event like it goes in the wire and records it
+func (c *CaptureWriter) RecordEvent(conn uint32, dir Dir, e E, msg interface{}) error {
+	return encodeAndWrite(&captureEvents{c: c, conn: conn, dir: dir}, e, msg)
+}
+
+// captureEvents is the eventWriter of RecordEvent, Record already knows
+// which events go with their len.
+type captureEvents struct {
+	c    *CaptureWriter
+	conn uint32
+	dir  Dir
+}
+
+func (w *captureEvents) Write(e E, data []byte) error {
+	return w.c.Record(w.conn, w.dir, e, data)
+}
+
+func (w *captureEvents) WriteWithLen(e E, data []byte) error {
+	return w.c.Record(w.conn, w.dir, e, data)
+}
+
+func (c *CaptureWriter) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.w.Flush()
+}
+
+func (c *CaptureWriter) Close() error {
+	err := c.Flush()
+	if c.c != nil {
+		if cerr := c.c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+type CaptureReader struct {
+	r *bufio.Reader
+	// Start is when the capture started
+	Start time.Time
+	// raw streams are just events one after the other, all going dir
+	raw bool
+	dir Dir
+}
+
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+	c := &CaptureReader{r: bufio.NewReader(r)}
+	head := make([]byte, len(captureMagic)+8)
+	if _, err := io.ReadFull(c.r, head); err != nil {
+		return nil, ErrNotCapture
+	}
+	if !bytes.Equal(head[:len(captureMagic)], captureMagic) {
+		return nil, ErrNotCapture
+	}
+	c.Start = time.UnixMilli(int64(binary.BigEndian.Uint64(head[len(captureMagic):])))
+	return c, nil
+}
+
+// NewRawCaptureReader reads a stream of events with nothing around them,
+// like a tcp stream pulled out of a pcap, the records have no time.
+func NewRawCaptureReader(r io.Reader, dir Dir) *CaptureReader {
+	return &CaptureReader{r: bufio.NewReader(r), raw: true, dir: dir}
+}
+
+// Next is the next record, io.EOF when there are no more
+func (c *CaptureReader) Next() (*CaptureRecord, error) {
+	head := make([]byte, 4+4+1)
+	if c.raw {
+		head[8] = byte(c.dir)
+	} else if _, err := io.ReadFull(c.r, head); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			// cut in the middle of a record, like when it was still being written
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	im, err := readMsg(c.r)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	rec := &CaptureRecord{
+		T:    time.Duration(binary.BigEndian.Uint32(head[0:4])) * time.Millisecond,
+		Conn: binary.BigEndian.Uint32(head[4:8]),
+		Dir:  Dir(head[8]),
+	}
+	if im != nil {
+		rec.Event, rec.Data = im.Event, im.Data
+	}
+	return rec, nil
+}
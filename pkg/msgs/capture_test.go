@@ -0,0 +1,42 @@
+package msgs_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/rywk/minigoao/pkg/msgs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapture(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, err := msgs.NewCaptureWriter(buf)
+	require.NoError(t, err)
+	moved := msgs.EncodeEventPlayerMoved(&msgs.EventPlayerMoved{ID: 3})
+	chat := msgs.EncodeMsgpack(&msgs.EventBroadcastChat{ID: 3, Msg: "hola"})
+	require.NoError(t, w.Record(1, msgs.DirDown, msgs.EPlayerMoved, moved))
+	require.NoError(t, w.Record(2, msgs.DirUp, msgs.EBroadcastChat, chat))
+	require.NoError(t, w.Close())
+
+	r, err := msgs.NewCaptureReader(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	rec, err := r.Next()
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), rec.Conn)
+	require.Equal(t, msgs.DirDown, rec.Dir)
+	require.Equal(t, msgs.EPlayerMoved, rec.Event)
+	require.Equal(t, moved, rec.Data)
+	ev, err := msgs.Decode(rec.Event, rec.Data)
+	require.NoError(t, err)
+	require.Equal(t, uint16(3), ev.(*msgs.EventPlayerMoved).ID)
+	rec, err = r.Next()
+	require.NoError(t, err)
+	require.Equal(t, msgs.DirUp, rec.Dir)
+	require.Equal(t, chat, rec.Data)
+	_, err = r.Next()
+	require.Equal(t, io.EOF, err)
+
+	_, err = msgs.NewCaptureReader(bytes.NewReader([]byte("nope")))
+	require.ErrorIs(t, err, msgs.ErrNotCapture)
+}
@@ -1,9 +1,11 @@
 package msgs
 
 import (
+	"io"
 	"log"
 	"net"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -56,13 +58,19 @@ func NewUpgraderMiddleware() (MMsgs, http.HandlerFunc) {
 			log.Print("upgrade:", err)
 			return
 		}
-		wss.newConns <- &WSM{c}
+		wss.newConns <- NewWS(c)
 		log.Print("upgraded")
 	}
 }
 
+func NewWS(c *websocket.Conn) *WSM {
+	return &WSM{c: c}
+}
+
 type WSM struct {
 	c *websocket.Conn
+	// reader of the current frame, a frame can have many events
+	r io.Reader
 }
 
 func (ws *WSM) IP() string {
@@ -73,12 +81,26 @@ func (ws *WSM) Close() {
 	ws.c.Close()
 }
 
+func (ws *WSM) SetWriteDeadline(t time.Time) error {
+	return ws.c.SetWriteDeadline(t)
+}
+
 func (ws *WSM) Read() (*IncomingData, error) {
-	_, r, err := ws.c.NextReader()
-	if err != nil {
-		return nil, err
+	for {
+		if ws.r == nil {
+			_, r, err := ws.c.NextReader()
+			if err != nil {
+				return nil, err
+			}
+			ws.r = r
+		}
+		im, err := readMsg(ws.r)
+		if err == io.EOF {
+			ws.r = nil
+			continue
+		}
+		return im, err
 	}
-	return readMsg(r)
 }
 func (ws *WSM) Write(event E, data []byte) error {
 	w, err := ws.c.NextWriter(websocket.BinaryMessage)
@@ -102,6 +124,10 @@ func (ws *WSM) EncodeAndWrite(e E, msg interface{}) error {
 	return encodeAndWrite(ws, e, msg)
 }
 
+func (ws *WSM) WriteBatch(b *Batch) error {
+	return ws.c.WriteMessage(websocket.BinaryMessage, b.Bytes())
+}
+
 func (s *WSServer) Address() string {
 	return s.addr
 }
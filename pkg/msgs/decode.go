@@ -0,0 +1,89 @@
+package msgs
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/rywk/minigoao/pkg/constants/direction"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Decode turns the data of any event into what it carries, for
+// tools that need to look at every event, the game decodes the
+// ones it cares about itself.
+func Decode(e E, data []byte) (interface{}, error) {
+	if l := e.Len(); l != -1 && len(data) != l {
+		return nil, fmt.Errorf("%v: %v bytes, want %v", e, len(data), l)
+	}
+	switch e {
+	case EPing, EServerDisconnect, EPlayerConnect, EPlayerLogout, EGameTick, EConsole:
+		return nil, nil
+	case ERegister:
+		return decodeMsgpack(data, &EventRegister{})
+	case EMove:
+		return DecodeEventMove(data), nil
+	case ECastSpell:
+		return DecodeEventCastSpell(data), nil
+	case EMelee:
+		return direction.D(data[0]), nil
+	case EUseItem:
+		return Item(data[0]), nil
+	case ESendChat:
+		return decodeMsgpack(data, &EventSendChat{})
+	case EMapChunkRequest:
+		return DecodeEventMapChunkRequest(data), nil
+	case EMapEdit:
+		return decodeMsgpack(data, &EventMapEdit{})
+	case EPingOk:
+		return binary.BigEndian.Uint16(data), nil
+	case EMoveOk:
+		return DecodeEventMoveOk(data), nil
+	case ECastSpellOk:
+		return DecodeEventCastSpellOk(data), nil
+	case EMeleeOk:
+		return DecodeEventMeleeOk(data), nil
+	case EUseItemOk:
+		return DecodeEventUseItemOk(data), nil
+	case EPlayerLogin:
+		return decodeMsgpack(data, &EventPlayerLogin{})
+	case EPlayerSpawned, EPlayerEnterViewport:
+		return decodeMsgpack(data, &EventNewPlayer{})
+	case EPlayerDespawned, EPlayerLeaveViewport:
+		return binary.BigEndian.Uint16(data), nil
+	case EBroadcastChat:
+		return decodeMsgpack(data, &EventBroadcastChat{})
+	case EPlayerMoved:
+		return DecodeEventPlayerMoved(data), nil
+	case EPlayerSpell:
+		return DecodeEventPlayerSpell(data), nil
+	case EPlayerSpellRecieved:
+		return DecodeEventPlayerSpellRecieved(data), nil
+	case EPlayerMelee:
+		return DecodeEventPlayerMelee(data), nil
+	case EPlayerMeleeRecieved:
+		return DecodeEventPlayerMeleeRecieved(data), nil
+	case EMapChunk:
+		return decodeMsgpack(data, &EventMapChunk{})
+	case EMapTile:
+		return decodeMsgpack(data, &EventMapTile{})
+	case ESnapshot:
+		return decodeMsgpack(data, &EventSnapshot{})
+	case ESnapshotAck:
+		return binary.BigEndian.Uint32(data), nil
+	case ECameraMove:
+		return DecodeEventCameraMove(data), nil
+	case EChatMuted:
+		return decodeMsgpack(data, &EventChatMuted{})
+	case ELoginDenied:
+		return decodeMsgpack(data, &EventLoginDenied{})
+	}
+	return nil, fmt.Errorf("unknown event %v", e)
+}
+
+// decodeMsgpack is DecodeMsgpack without the panic, data here can be anything
+func decodeMsgpack[T any](data []byte, to *T) (*T, error) {
+	if err := msgpack.Unmarshal(data, to); err != nil {
+		return nil, err
+	}
+	return to, nil
+}
@@ -7,9 +7,11 @@ import (
 	"io"
 	"log"
 	"net"
+	"time"
 	"unsafe"
 
 	"github.com/rywk/minigoao/pkg/constants/direction"
+	"github.com/rywk/minigoao/pkg/constants/npc"
 	"github.com/rywk/minigoao/pkg/constants/spell"
 	"github.com/rywk/minigoao/pkg/typ"
 	"github.com/vmihailenco/msgpack/v5"
@@ -22,6 +24,10 @@ type Msgs interface {
 	Write(event E, data []byte) error
 	WriteWithLen(event E, data []byte) error
 	EncodeAndWrite(e E, msg interface{}) error
+	// WriteBatch sends all the events in b in one write
+	WriteBatch(b *Batch) error
+	// SetWriteDeadline makes writes fail if they take past t, zero means no deadline
+	SetWriteDeadline(t time.Time) error
 }
 
 type M struct {
@@ -48,15 +54,21 @@ func (m *M) Close() {
 	m.c.Close()
 }
 
+func (m *M) SetWriteDeadline(t time.Time) error {
+	return m.c.SetWriteDeadline(t)
+}
+
 var ErrBadData = errors.New("bad data")
 
 func readMsg(r io.Reader) (*IncomingData, error) {
 	eventByte := make([]byte, eventTypeLen)
 
-	_, err := r.Read(eventByte)
+	_, err := io.ReadFull(r, eventByte)
 	if err != nil {
-		log.Printf("BAD 0 byte!!!! %d", err)
-
+		// EOF is just the end of a frame when reading a batch
+		if err != io.EOF {
+			log.Printf("BAD 0 byte!!!! %d", err)
+		}
 		return nil, err
 	}
 	event := E(eventByte[0])
@@ -77,18 +89,18 @@ func readMsg(r io.Reader) (*IncomingData, error) {
 	}
 	if incd.Event.Len() != -1 {
 		incd.Data = make([]byte, incd.Event.Len())
-		_, err = r.Read(incd.Data)
+		_, err = io.ReadFull(r, incd.Data)
 		return incd, err
 	}
 
 	msgSizeBs := make([]byte, 2)
-	_, err = r.Read(msgSizeBs)
+	_, err = io.ReadFull(r, msgSizeBs)
 	if err != nil {
 		return nil, err
 	}
 	msgSize := binary.BigEndian.Uint16(msgSizeBs)
 	incd.Data = make([]byte, msgSize)
-	_, err = r.Read(incd.Data)
+	_, err = io.ReadFull(r, incd.Data)
 	return incd, err
 }
 
@@ -100,12 +112,6 @@ func (m *M) Read() (*IncomingData, error) {
 // Write sends the event to the connection
 func write(w io.Writer, event E, data []byte) error {
 	buf := []byte{byte(event)}
-	if data == nil {
-		_, err := w.Write(buf)
-		if err != nil {
-			return err
-		}
-	}
 	_, err := w.Write(append(buf, data...))
 	if err != nil {
 		return err
@@ -130,6 +136,11 @@ func (m *M) Write(event E, data []byte) error {
 	return write(m.c, event, data)
 }
 
+func (m *M) WriteBatch(b *Batch) error {
+	_, err := m.c.Write(b.Bytes())
+	return err
+}
+
 // Write sends the event to the connection
 func (m *M) WriteWithLen(event E, data []byte) error {
 	return writeWithLen(m.c, event, data)
@@ -159,6 +170,8 @@ const (
 	EMelee
 	EUseItem
 	ESendChat
+	EMapChunkRequest
+	EMapEdit
 
 	EPingOk
 	EMoveOk
@@ -181,6 +194,22 @@ const (
 	EPlayerMelee         // A Player in the viewport recieved a melee
 	EPlayerMeleeRecieved // Player recieved a melee
 
+	EMapChunk // Map data of a chunk requested by the client
+	EMapTile  // A tile in the viewport was edited
+
+	EGameTick // Just used internally to move the things that move on their own, like npcs
+
+	ESnapshot    // Players in the viewport, as a delta against the last acked snapshot
+	ESnapshotAck // Client applied a snapshot
+
+	ECameraMove // A spectator moved its camera to another tile
+
+	EChatMuted // The player cant chat for a while, or can again
+
+	EConsole // Just used internally, a line typed in the server console
+
+	ELoginDenied // The server wont let the player in, or takes it out, and why
+
 	ELen
 )
 
@@ -188,17 +217,19 @@ const mapCoordinateSize = int(unsafe.Sizeof(uint32(0)))
 
 var eventLen = [ELen]int{
 	0,
-	1,       // EPing
-	-1,      // ERegister
-	0,       // EServerDisconnect
-	1,       // EMove - 1 byte (uint8) to define the direction.
-	1 + 4*2, // ECastSpell - 1 byte (uint8) to define the spell picked in the client side. x, y map coords are 2 uint32
-	1,       // EMelee - signals user used the melee key
-	1,       // EUseItem - 1 byte (uint8) to define the item id
-	-1,      // ESendChat
+	1,           // EPing
+	-1,          // ERegister
+	0,           // EServerDisconnect
+	2 + 1,       // EMove - 2 bytes (uint16) move seq, 1 byte (uint8) to define the direction.
+	1 + 4*2 + 4, // ECastSpell - 1 byte (uint8) to define the spell picked in the client side. x, y map coords are 2 uint32, 4 bytes (uint32) server ms the client was drawing
+	1,           // EMelee - signals user used the melee key
+	1,           // EUseItem - 1 byte (uint8) to define the item id
+	-1,          // ESendChat
+	2 + 2,       // EMapChunkRequest - 2 bytes (uint16) x, 2 bytes (uint16) y of the chunk
+	-1,          // EMapEdit
 
 	2,                 // EPingOk
-	2,                 // EMoveOk - 1 byte (bool) move, 1 byte (bool) direction
+	2 + 1 + 1 + 4*2,   // EMoveOk - 2 bytes (uint16) seq of the move, 1 byte (bool) move, 1 byte (uint8) direction, 2 uint32 position after the move
 	1 + 2 + 4 + 4 + 1, // ECastSpellOk - 1 byte (uint8) spell, 2 bytes (uint16) to define the player id, 4 bytes (uint32) damage,  4 bytes (uint32) new mp,  1 byte (bool) killed target
 	1 + 1 + 1 + 2 + 4, // EMeleeOk -  1 byte (uint8) direction,  1 byte (bool) hit/miss, 1 byte (bool) killed target, 2 bytes (uint16) to define the player id, 4 bytes (uint32) damage
 	1 + 4,             // EUseItemOk - 1 byte (uint8) item, 4 byte (uint32) to define value changed (mana/health)
@@ -212,11 +243,27 @@ var eventLen = [ELen]int{
 	2,  // EPlayerLeaveViewport - 2 bytes (uint16) to define the player id
 	-1, // EBroadcastChat
 
-	11,                // EPlayerMoved - 1 byte (uint8) direction, 2 bytes (uint16) player id, 8 bytes (uint32, uint32) x y
+	11 + 4,            // EPlayerMoved - 1 byte (uint8) direction, 2 bytes (uint16) player id, 8 bytes (uint32, uint32) x y, 4 bytes (uint32) server ms
 	2 + 1 + 1,         // EPlayerSpell - 2 bytes (uint16) to define the target player id, 1 byte (uint8) to define the spell, 1 byte (bool) killed target
 	1 + 2 + 4 + 4,     // EPlayerSpellRecieved - 1 byte (uint8) to define the spell, 2 bytes (uint16) to define the (caster) player id, 4 bytes (uint32) to define the new hp, 4 bytes (uint32) to define the damage
 	1 + 1 + 1 + 2 + 2, // EPlayerMelee - 1 byte (bool) hit/miss, 1 byte (bool) killed target, 2 bytes (uint16) to define the target player id, 2 bytes (uint16) to define the attacker
 	1 + 2 + 4 + 4,     // EPlayerMeleeRecieved - 2 bytes (uint16) to define the (caster) player id, 4 bytes (uint32) to define the new hp, 4 bytes (uint32) to define the damage
+
+	-1, // EMapChunk
+	-1, // EMapTile
+
+	0, // EGameTick
+
+	-1, // ESnapshot
+	4,  // ESnapshotAck - 4 bytes (uint32) seq of the snapshot
+
+	2 + 2, // ECameraMove - 2 bytes (uint16) x, 2 bytes (uint16) y of the tile
+
+	-1, // EChatMuted
+
+	0, // EConsole
+
+	-1, // ELoginDenied
 }
 
 var eventString = [ELen]string{
@@ -228,6 +275,9 @@ var eventString = [ELen]string{
 	"ECastSpell",
 	"EMelee",
 	"EUseItem",
+	"ESendChat",
+	"EMapChunkRequest",
+	"EMapEdit",
 
 	"EPingOk",
 	"EMoveOk",
@@ -242,12 +292,29 @@ var eventString = [ELen]string{
 	"EPlayerDespawned",
 	"EPlayerEnterViewport",
 	"EPlayerLeaveViewport",
+	"EBroadcastChat",
 
 	"EPlayerMoved",
 	"EPlayerSpell",
 	"EPlayerSpellRecieved",
 	"EPlayerMelee",
 	"EPlayerMeleeRecieved",
+
+	"EMapChunk",
+	"EMapTile",
+
+	"EGameTick",
+
+	"ESnapshot",
+	"ESnapshotAck",
+
+	"ECameraMove",
+
+	"EChatMuted",
+
+	"EConsole",
+
+	"ELoginDenied",
 }
 
 func (e E) Valid() bool {
@@ -262,14 +329,14 @@ func (e E) String() string {
 	return eventString[e]
 }
 
-func encodeAndWrite(m Msgs, e E, msg interface{}) error {
+func encodeAndWrite(m eventWriter, e E, msg interface{}) error {
 	switch e {
 	case EPing:
 		return m.Write(e, make([]byte, 1))
 	case ERegister:
 		return m.WriteWithLen(e, EncodeMsgpack(msg.(*EventRegister)))
 	case EMove:
-		return m.Write(e, []byte{msg.(uint8)})
+		return m.Write(e, EncodeEventMove(msg.(*EventMove)))
 	case ECastSpell:
 		return m.Write(e, EncodeEventCastSpell(msg.(*EventCastSpell)))
 	case EMelee:
@@ -278,10 +345,16 @@ func encodeAndWrite(m Msgs, e E, msg interface{}) error {
 		return m.Write(e, []byte{byte(msg.(Item))})
 	case ESendChat:
 		return m.WriteWithLen(e, EncodeMsgpack(msg.(*EventSendChat)))
+	case EMapChunkRequest:
+		return m.Write(e, EncodeEventMapChunkRequest(msg.(typ.P)))
+	case EMapEdit:
+		return m.WriteWithLen(e, EncodeMsgpack(msg.(*EventMapEdit)))
+	case EPlayerLogin:
+		return m.WriteWithLen(e, EncodeMsgpack(msg.(*EventPlayerLogin)))
 	case EPingOk:
 		return m.Write(e, binary.BigEndian.AppendUint16(make([]byte, 0, 2), msg.(uint16)))
 	case EMoveOk:
-		return m.Write(e, msg.([]byte))
+		return m.Write(e, EncodeEventMoveOk(msg.(*EventMoveOk)))
 	case ECastSpellOk:
 		return m.Write(e, EncodeEventCastSpellOk(msg.(*EventCastSpellOk)))
 	case EMeleeOk:
@@ -308,6 +381,20 @@ func encodeAndWrite(m Msgs, e E, msg interface{}) error {
 		return m.Write(e, EncodeEventPlayerMelee(msg.(*EventPlayerMelee)))
 	case EPlayerMeleeRecieved:
 		return m.Write(e, EncodeEventPlayerMeleeRecieved(msg.(*EventPlayerMeleeRecieved)))
+	case EMapChunk:
+		return m.WriteWithLen(e, EncodeMsgpack(msg.(*EventMapChunk)))
+	case EMapTile:
+		return m.WriteWithLen(e, EncodeMsgpack(msg.(*EventMapTile)))
+	case ESnapshot:
+		return m.WriteWithLen(e, EncodeMsgpack(msg.(*EventSnapshot)))
+	case ESnapshotAck:
+		return m.Write(e, binary.BigEndian.AppendUint32(make([]byte, 0, 4), msg.(uint32)))
+	case ECameraMove:
+		return m.Write(e, EncodeEventCameraMove(msg.(typ.P)))
+	case EChatMuted:
+		return m.WriteWithLen(e, EncodeMsgpack(msg.(*EventChatMuted)))
+	case ELoginDenied:
+		return m.WriteWithLen(e, EncodeMsgpack(msg.(*EventLoginDenied)))
 	default:
 		log.Printf("unknown event %v\n", e.String())
 		return fmt.Errorf("unknown event %v", e.String())
@@ -324,6 +411,40 @@ type EventSendChat struct {
 type EventBroadcastChat struct {
 	ID  uint16
 	Msg string
+	// ChatLocal comes from someone in the viewport, the rest can come
+	// from anywhere so they have the nick of who sent them
+	Channel ChatChannel `msgpack:",omitempty"`
+	Nick    string      `msgpack:",omitempty"`
+	// whispers we sent come back to us with who they went to
+	To string `msgpack:",omitempty"`
+}
+
+// EventChatMuted tells a player it was muted until Until (unix ms),
+// an Until of 0 means it can talk again.
+type EventChatMuted struct {
+	Until  int64
+	Reason string `msgpack:",omitempty"`
+}
+
+type ChatChannel uint8
+
+const (
+	ChatLocal ChatChannel = iota
+	ChatGlobal
+	ChatParty
+	ChatWhisper
+	ChatSystem // from the server, ID is 0
+	ChatCombat // like system, for what happened in a fight
+	ChatLen
+)
+
+var chatChannels = [ChatLen]string{"local", "global", "party", "whisper", "system", "combat"}
+
+func (c ChatChannel) String() string {
+	if c >= ChatLen {
+		return "unknown"
+	}
+	return chatChannels[c]
 }
 
 func BoolByte(b bool) byte {
@@ -335,12 +456,59 @@ func BoolByte(b bool) byte {
 
 type EventRegister struct {
 	Nick string
+	// Only needed for nicks that have an account
+	Password string
+	// Sync the viewport with snapshots instead of move events
+	Snapshots bool
+	// Token of a session that dropped, to get the same player back
+	Resume string
+	// Only watch, without a character in the world
+	Spectate bool
+}
+
+type Role uint8
+
+const (
+	RolePlayer Role = iota
+	RoleAdmin
+	RoleMod // can run some of the admin commands, see server/admin.go
+	RoleLen
+)
+
+var roles = [RoleLen]string{
+	"player",
+	"admin",
+	"mod",
+}
+
+func (r Role) String() string {
+	return roles[r]
+}
+
+func RoleFromString(s string) (Role, bool) {
+	for i, r := range roles {
+		if r == s {
+			return Role(i), true
+		}
+	}
+	return RolePlayer, false
+}
+
+// EventLoginDenied is sent instead of EventPlayerLogin to a player the server
+// wont let in, like a banned one, or to a player kicked out of the game,
+// right before it closes the conn. The client shouldnt try to resume.
+type EventLoginDenied struct {
+	Reason string
+	// when it can try again, unix ms, 0 if never
+	Until int64 `msgpack:",omitempty"`
 }
 
 // msgpack
 type EventPlayerLogin struct {
 	ID             uint16
 	Nick           string
+	Role           Role
+	MapW, MapH     int32
 	Pos            typ.P
 	Dir            direction.D
 	Speed          uint8
@@ -349,7 +517,30 @@ type EventPlayerLogin struct {
 	MaxHP          int32
 	MP             int32
 	MaxMP          int32
+	Zones          []Zone
 	VisiblePlayers []EventNewPlayer
+	// Sent back in EventRegister to resume the session if the connection drops
+	ResumeToken string
+	// We are just watching, Pos is where the camera starts
+	Spectator bool
+}
+
+// Zone is a named region of the map with its own rules,
+// outside of every zone everything is allowed.
+type Zone struct {
+	Name     string
+	Rect     typ.Rect
+	NoCombat bool // no melee and no spells that hurt
+	NoSpells bool
+	NoItems  bool
+	// Respawn points are used to spawn players when the map
+	// has no spawn tiles set.
+	Respawn *typ.P `msgpack:",omitempty" json:",omitempty"`
+}
+
+// Safe is true for the zones where nobody can be attacked.
+func (z *Zone) Safe() bool {
+	return z != nil && z.NoCombat
 }
 
 func DecodeMsgpack[T any](data []byte, to *T) *T {
@@ -376,6 +567,7 @@ type EventNewPlayer struct {
 	Dir   direction.D
 	Dead  bool
 	Speed uint8
+	NPC   npc.Type // npcs come as players, with the type set
 }
 
 type EventPlayerSpawned = EventNewPlayer
@@ -392,9 +584,63 @@ const (
 	ItemLen
 )
 
+// EventMove is a step the client took, the seq
+// is how it knows which one an EventMoveOk answers
+type EventMove struct {
+	Seq uint16
+	Dir direction.D
+}
+
+func DecodeEventMove(data []byte) *EventMove {
+	return &EventMove{
+		Seq: binary.BigEndian.Uint16(data[0:2]),
+		Dir: direction.D(data[2]),
+	}
+}
+
+func EncodeEventMove(c *EventMove) []byte {
+	bs := make([]byte, EMove.Len())
+	binary.BigEndian.PutUint16(bs[0:2], c.Seq)
+	bs[2] = byte(c.Dir)
+	return bs
+}
+
+// EventMoveOk answers the move with Seq, Pos is where
+// the player really is after it, allowed or not
+type EventMoveOk struct {
+	Seq uint16
+	Ok  bool
+	Dir direction.D
+	Pos typ.P
+}
+
+func DecodeEventMoveOk(data []byte) *EventMoveOk {
+	return &EventMoveOk{
+		Seq: binary.BigEndian.Uint16(data[0:2]),
+		Ok:  data[2] != 0,
+		Dir: direction.D(data[3]),
+		Pos: typ.P{
+			X: int32(binary.BigEndian.Uint32(data[4:8])),
+			Y: int32(binary.BigEndian.Uint32(data[8:12])),
+		},
+	}
+}
+
+func EncodeEventMoveOk(c *EventMoveOk) []byte {
+	bs := make([]byte, EMoveOk.Len())
+	binary.BigEndian.PutUint16(bs[0:2], c.Seq)
+	bs[2] = BoolByte(c.Ok)
+	bs[3] = byte(c.Dir)
+	binary.BigEndian.PutUint32(bs[4:8], uint32(c.Pos.X))
+	binary.BigEndian.PutUint32(bs[8:12], uint32(c.Pos.Y))
+	return bs
+}
+
 type EventCastSpell struct {
 	Spell  spell.Spell
 	PX, PY uint32
+	// T is the server time the client was drawing the others at when it clicked
+	T uint32
 }
 
 func DecodeEventCastSpell(data []byte) *EventCastSpell {
@@ -402,6 +648,7 @@ func DecodeEventCastSpell(data []byte) *EventCastSpell {
 		Spell: spell.Spell(data[0]),
 		PX:    binary.BigEndian.Uint32(data[1:5]),
 		PY:    binary.BigEndian.Uint32(data[5:9]),
+		T:     binary.BigEndian.Uint32(data[9:13]),
 	}
 }
 
@@ -410,6 +657,7 @@ func EncodeEventCastSpell(c *EventCastSpell) []byte {
 	bs[0] = byte(c.Spell)
 	binary.BigEndian.PutUint32(bs[1:5], c.PX)
 	binary.BigEndian.PutUint32(bs[5:9], c.PY)
+	binary.BigEndian.PutUint32(bs[9:13], c.T)
 	return bs
 }
 
@@ -471,6 +719,8 @@ func EncodeEventMeleeOk(c *EventMeleeOk) []byte {
 	return bs
 }
 
+// EventUseItemOk is the item used and the hp or mp the player
+// has now, Item is ItemNone if it couldnt be used.
 type EventUseItemOk struct {
 	Item   Item
 	Change uint32
@@ -494,6 +744,8 @@ type EventPlayerMoved struct {
 	Dir direction.D
 	ID  uint16
 	Pos typ.P
+	// T is when the move happened, in ms of the server clock
+	T uint32
 }
 
 func DecodeEventPlayerMoved(data []byte) *EventPlayerMoved {
@@ -504,6 +756,7 @@ func DecodeEventPlayerMoved(data []byte) *EventPlayerMoved {
 			X: int32(binary.BigEndian.Uint32(data[3:7])),
 			Y: int32(binary.BigEndian.Uint32(data[7:11])),
 		},
+		T: binary.BigEndian.Uint32(data[11:15]),
 	}
 }
 
@@ -513,6 +766,7 @@ func EncodeEventPlayerMoved(c *EventPlayerMoved) []byte {
 	binary.BigEndian.PutUint16(bs[1:3], c.ID)
 	binary.BigEndian.PutUint32(bs[3:7], uint32(c.Pos.X))
 	binary.BigEndian.PutUint32(bs[7:11], uint32(c.Pos.Y))
+	binary.BigEndian.PutUint32(bs[11:15], c.T)
 	return bs
 }
 
@@ -621,3 +875,74 @@ func EncodeEventPlayerMeleeRecieved(c *EventPlayerMeleeRecieved) []byte {
 	bs[10] = c.Dir
 	return bs
 }
+
+// Map editor operations
+type MapEditOp uint8
+
+const (
+	MapEditGround MapEditOp = iota // Paint the ground layer with Asset
+	MapEditStuff                   // Paint the stuff layer with Asset
+	MapEditBlock                   // Toggle the tile blocking
+	MapEditSpawn                   // Toggle a spawn point on the tile
+	MapEditNPC                     // Toggle a spawn of the npc type in Asset on the tile
+	MapEditLen
+)
+
+type EventMapEdit struct {
+	Op    MapEditOp
+	Pos   typ.P
+	Asset uint32
+}
+
+type EventMapTile struct {
+	Pos     typ.P
+	Ground  uint32
+	Stuff   uint32
+	Blocked bool
+	Spawn   bool
+	NPC     npc.Type
+}
+
+type NPCSpawn struct {
+	Type npc.Type
+	Pos  typ.P
+}
+
+// Layers of the tiles in Rect, row by row
+type EventMapChunk struct {
+	Chunk   typ.P
+	Rect    typ.Rect
+	Ground  []uint32
+	Stuff   []uint32
+	Blocked []bool
+	Spawns  []typ.P
+	NPCs    []NPCSpawn
+}
+
+func DecodeEventMapChunkRequest(data []byte) typ.P {
+	return typ.P{
+		X: int32(binary.BigEndian.Uint16(data[:2])),
+		Y: int32(binary.BigEndian.Uint16(data[2:4])),
+	}
+}
+
+func EncodeEventMapChunkRequest(c typ.P) []byte {
+	bs := make([]byte, EMapChunkRequest.Len())
+	binary.BigEndian.PutUint16(bs[:2], uint16(c.X))
+	binary.BigEndian.PutUint16(bs[2:4], uint16(c.Y))
+	return bs
+}
+
+func DecodeEventCameraMove(data []byte) typ.P {
+	return typ.P{
+		X: int32(binary.BigEndian.Uint16(data[:2])),
+		Y: int32(binary.BigEndian.Uint16(data[2:4])),
+	}
+}
+
+func EncodeEventCameraMove(p typ.P) []byte {
+	bs := make([]byte, ECameraMove.Len())
+	binary.BigEndian.PutUint16(bs[:2], uint16(p.X))
+	binary.BigEndian.PutUint16(bs[2:4], uint16(p.Y))
+	return bs
+}
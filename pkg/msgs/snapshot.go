@@ -0,0 +1,78 @@
+package msgs
+
+import (
+	"github.com/rywk/minigoao/pkg/constants/direction"
+	"github.com/rywk/minigoao/pkg/typ"
+)
+
+// Snapshot is what an observer sees, by id
+type Snapshot map[uint16]EventNewPlayer
+
+// SnapshotEntity is the part of a player that changes while its in the viewport
+type SnapshotEntity struct {
+	ID   uint16
+	Pos  typ.P
+	Dir  direction.D
+	Dead bool
+}
+
+// EventSnapshot is a delta from the snapshot Base to the snapshot Seq,
+// a Base of 0 means its against nothing, so it has the whole viewport.
+type EventSnapshot struct {
+	Seq     uint32
+	Base    uint32
+	Spawned []EventNewPlayer
+	Changed []SnapshotEntity
+	Removed []uint16
+}
+
+// Delta returns what changed from base to s, base can be nil.
+func (s Snapshot) Delta(seq, baseSeq uint32, base Snapshot) *EventSnapshot {
+	ev := &EventSnapshot{Seq: seq, Base: baseSeq}
+	if base == nil {
+		ev.Base = 0
+	}
+	for id, e := range s {
+		b, ok := base[id]
+		if !ok {
+			ev.Spawned = append(ev.Spawned, e)
+			continue
+		}
+		if b.Pos != e.Pos || b.Dir != e.Dir || b.Dead != e.Dead {
+			ev.Changed = append(ev.Changed, SnapshotEntity{ID: id, Pos: e.Pos, Dir: e.Dir, Dead: e.Dead})
+		}
+	}
+	for id := range base {
+		if _, ok := s[id]; !ok {
+			ev.Removed = append(ev.Removed, id)
+		}
+	}
+	return ev
+}
+
+// Empty is true when the delta doesnt change anything
+func (ev *EventSnapshot) Empty() bool {
+	return ev.Base != 0 && len(ev.Spawned)+len(ev.Changed)+len(ev.Removed) == 0
+}
+
+// Apply builds the snapshot Seq on top of base, which must be the snapshot Base.
+func (ev *EventSnapshot) Apply(base Snapshot) Snapshot {
+	s := make(Snapshot, len(base)+len(ev.Spawned))
+	if ev.Base != 0 {
+		for id, e := range base {
+			s[id] = e
+		}
+	}
+	for _, id := range ev.Removed {
+		delete(s, id)
+	}
+	for _, e := range ev.Spawned {
+		s[e.ID] = e
+	}
+	for _, c := range ev.Changed {
+		e := s[c.ID]
+		e.ID, e.Pos, e.Dir, e.Dead = c.ID, c.Pos, c.Dir, c.Dead
+		s[c.ID] = e
+	}
+	return s
+}
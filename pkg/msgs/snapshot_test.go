@@ -0,0 +1,45 @@
+package msgs_test
+
+import (
+	"testing"
+
+	"github.com/rywk/minigoao/pkg/constants/direction"
+	"github.com/rywk/minigoao/pkg/msgs"
+	"github.com/rywk/minigoao/pkg/typ"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotDelta(t *testing.T) {
+	base := msgs.Snapshot{
+		1: {ID: 1, Nick: "uno", Pos: typ.P{X: 1, Y: 1}},
+		2: {ID: 2, Nick: "dos", Pos: typ.P{X: 2, Y: 2}},
+		3: {ID: 3, Nick: "tres", Pos: typ.P{X: 3, Y: 3}},
+	}
+	cur := msgs.Snapshot{
+		1: {ID: 1, Nick: "uno", Pos: typ.P{X: 1, Y: 1}},
+		2: {ID: 2, Nick: "dos", Pos: typ.P{X: 2, Y: 3}, Dir: direction.Front},
+		4: {ID: 4, Nick: "cuatro", Pos: typ.P{X: 4, Y: 4}},
+	}
+
+	ev := cur.Delta(8, 5, base)
+	require.Equal(t, uint32(5), ev.Base)
+	require.Equal(t, []msgs.EventNewPlayer{cur[4]}, ev.Spawned)
+	require.Equal(t, []msgs.SnapshotEntity{{ID: 2, Pos: typ.P{X: 2, Y: 3}, Dir: direction.Front}}, ev.Changed)
+	require.Equal(t, []uint16{3}, ev.Removed)
+
+	// goes through the wire
+	ev = msgs.DecodeMsgpack(msgs.EncodeMsgpack(ev), &msgs.EventSnapshot{})
+	require.Equal(t, cur, ev.Apply(base))
+	require.True(t, cur.Delta(9, 8, cur).Empty())
+}
+
+func TestSnapshotFull(t *testing.T) {
+	cur := msgs.Snapshot{
+		4: {ID: 4, Nick: "cuatro", Pos: typ.P{X: 4, Y: 4}},
+	}
+	ev := cur.Delta(1, 7, nil)
+	require.Equal(t, uint32(0), ev.Base)
+	require.False(t, ev.Empty())
+	// a full snapshot doesnt keep anything from what the client had
+	require.Equal(t, cur, ev.Apply(msgs.Snapshot{9: {ID: 9}}))
+}
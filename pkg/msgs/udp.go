@@ -0,0 +1,655 @@
+package msgs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// The first byte of every udp packet
+const (
+	udpHello      byte = iota + 1 // client asking for a conn, sent again until the welcome comes
+	udpWelcome                    // server took the conn
+	udpReliable                   // 4 bytes (uint32) seq, a piece of the ordered stream of events
+	udpUnreliable                 // 4 bytes (uint32) seq, whole events, dropped if a newer one already came
+	udpAck                        // 4 bytes (uint32) next reliable seq expected, it is also the keep alive
+	udpClose
+)
+
+const (
+	// UDPMaxPayload keeps the packets under the usual MTU
+	UDPMaxPayload = 1200
+	// UDPWindow is how many reliable packets can wait for an ack before writes block
+	UDPWindow = 256
+	// UDPHandshakeTimeout is how long the client tries udp before giving up
+	UDPHandshakeTimeout = time.Second
+	// UDPTimeout closes a conn that didnt send anything in this long
+	UDPTimeout = 10 * time.Second
+
+	udpHeader    = 1 + 4
+	udpTick      = 50 * time.Millisecond
+	udpKeepAlive = time.Second
+	udpMinRTO    = 100 * time.Millisecond
+	udpMaxRTO    = 2 * time.Second
+)
+
+var (
+	udpMagic      = []byte("mgao1")
+	ErrUDPTimeout = errors.New("udp conn timed out")
+)
+
+// Unreliable is true for the events where only the newest one matters,
+// on udp they go in the unreliable channel, everything else is reliable.
+func Unreliable(e E) bool {
+	switch e {
+	case EPlayerMoved, ESnapshot, ESnapshotAck:
+		return true
+	}
+	return false
+}
+
+type udpSegment struct {
+	seq    uint32
+	pkt    []byte
+	sent   time.Time
+	resent bool
+}
+
+// UDPM is a conn over udp, it has two channels, a reliable ordered
+// stream of events like tcp and an unreliable sequenced one for the
+// events that are old news if they come late.
+type UDPM struct {
+	addr    net.Addr
+	send    func([]byte) error
+	onClose func()
+
+	in        chan *IncomingData
+	stream    *udpStream
+	acked     chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+	err       error
+
+	mu       sync.Mutex
+	deadline time.Time
+	lastRecv time.Time
+	lastSent time.Time
+	// reliable, sending
+	nextSeq uint32
+	unacked []udpSegment
+	srtt    time.Duration
+	rto     time.Duration
+	// reliable, receiving
+	recvNext uint32
+	early    map[uint32][]byte
+	// unreliable
+	uSeq  uint32
+	uLast uint32
+}
+
+func newUDPM(addr net.Addr, send func([]byte) error) *UDPM {
+	m := &UDPM{
+		addr:     addr,
+		send:     send,
+		in:       make(chan *IncomingData, 256),
+		stream:   newUDPStream(),
+		acked:    make(chan struct{}, 1),
+		closed:   make(chan struct{}),
+		lastRecv: time.Now(),
+		lastSent: time.Now(),
+		nextSeq:  1,
+		rto:      udpMinRTO * 2,
+		recvNext: 1,
+		early:    make(map[uint32][]byte),
+	}
+	go m.readStream()
+	go m.loop()
+	return m
+}
+
+func (m *UDPM) IP() string {
+	return m.addr.String()
+}
+
+func (m *UDPM) Close() {
+	m.send([]byte{udpClose})
+	m.close(net.ErrClosed)
+}
+
+func (m *UDPM) close(err error) {
+	m.closeOnce.Do(func() {
+		m.err = err
+		close(m.closed)
+		m.stream.close()
+		if m.onClose != nil {
+			m.onClose()
+		}
+	})
+}
+
+func (m *UDPM) SetWriteDeadline(t time.Time) error {
+	m.mu.Lock()
+	m.deadline = t
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *UDPM) Read() (*IncomingData, error) {
+	select {
+	case im := <-m.in:
+		return im, nil
+	case <-m.closed:
+		return nil, m.err
+	}
+}
+
+func (m *UDPM) Write(event E, data []byte) error {
+	b := &Batch{}
+	b.Write(event, data)
+	return m.WriteBatch(b)
+}
+
+func (m *UDPM) WriteWithLen(event E, data []byte) error {
+	b := &Batch{}
+	b.WriteWithLen(event, data)
+	return m.WriteBatch(b)
+}
+
+func (m *UDPM) EncodeAndWrite(e E, msg interface{}) error {
+	return encodeAndWrite(m, e, msg)
+}
+
+// WriteBatch splits the batch in the two channels, the reliable
+// part goes first so nothing unreliable is about something the
+// other side doesnt know yet.
+func (m *UDPM) WriteBatch(b *Batch) error {
+	var (
+		rel   []byte
+		unrel [][]byte
+		cur   []byte
+		all   = b.Bytes()
+		r     = bytes.NewReader(all)
+	)
+	for r.Len() > 0 {
+		start := len(all) - r.Len()
+		im, err := readMsg(r)
+		if err != nil {
+			return err
+		}
+		raw := all[start : len(all)-r.Len()]
+		if im == nil || !Unreliable(im.Event) || len(raw) > UDPMaxPayload-udpHeader {
+			rel = append(rel, raw...)
+			continue
+		}
+		if len(cur)+len(raw) > UDPMaxPayload-udpHeader {
+			unrel = append(unrel, cur)
+			cur = nil
+		}
+		cur = append(cur, raw...)
+	}
+	if len(cur) != 0 {
+		unrel = append(unrel, cur)
+	}
+	if err := m.writeReliable(rel); err != nil {
+		return err
+	}
+	for _, u := range unrel {
+		if err := m.writeUnreliable(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *UDPM) writeReliable(data []byte) error {
+	for len(data) > 0 {
+		n := min(len(data), UDPMaxPayload-udpHeader)
+		if err := m.waitWindow(); err != nil {
+			return err
+		}
+		m.mu.Lock()
+		seg := udpSegment{seq: m.nextSeq, pkt: udpPacket(udpReliable, m.nextSeq, data[:n]), sent: time.Now()}
+		m.nextSeq++
+		m.unacked = append(m.unacked, seg)
+		m.lastSent = seg.sent
+		err := m.send(seg.pkt)
+		m.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// waitWindow blocks while there are too many packets waiting for an ack
+func (m *UDPM) waitWindow() error {
+	var timeout <-chan time.Time
+	for {
+		m.mu.Lock()
+		full, deadline := len(m.unacked) >= UDPWindow, m.deadline
+		m.mu.Unlock()
+		if !full {
+			return nil
+		}
+		if timeout == nil && !deadline.IsZero() {
+			t := time.NewTimer(time.Until(deadline))
+			defer t.Stop()
+			timeout = t.C
+		}
+		select {
+		case <-m.acked:
+		case <-timeout:
+			return os.ErrDeadlineExceeded
+		case <-m.closed:
+			return m.err
+		}
+	}
+}
+
+func (m *UDPM) writeUnreliable(data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.uSeq++
+	m.lastSent = time.Now()
+	return m.send(udpPacket(udpUnreliable, m.uSeq, data))
+}
+
+func udpPacket(kind byte, seq uint32, data []byte) []byte {
+	pkt := make([]byte, udpHeader, udpHeader+len(data))
+	pkt[0] = kind
+	binary.BigEndian.PutUint32(pkt[1:], seq)
+	return append(pkt, data...)
+}
+
+// handle takes a packet that came from the other side, p is
+// only good until it returns.
+func (m *UDPM) handle(p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	m.mu.Lock()
+	m.lastRecv = time.Now()
+	m.mu.Unlock()
+	if p[0] == udpClose {
+		m.close(io.EOF)
+		return
+	}
+	if len(p) < udpHeader {
+		return
+	}
+	seq := binary.BigEndian.Uint32(p[1:udpHeader])
+	switch p[0] {
+	case udpReliable:
+		m.recvReliable(seq, p[udpHeader:])
+	case udpUnreliable:
+		m.recvUnreliable(seq, p[udpHeader:])
+	case udpAck:
+		m.recvAck(seq)
+	}
+}
+
+func (m *UDPM) recvReliable(seq uint32, data []byte) {
+	m.mu.Lock()
+	if seq == m.recvNext {
+		m.stream.push(data)
+		m.recvNext++
+		for d, ok := m.early[m.recvNext]; ok; d, ok = m.early[m.recvNext] {
+			delete(m.early, m.recvNext)
+			m.stream.push(d)
+			m.recvNext++
+		}
+	} else if d := int32(seq - m.recvNext); d > 0 && d < UDPWindow {
+		m.early[seq] = bytes.Clone(data)
+	}
+	// acked every time, if the ack got lost the resend gets it again
+	m.lastSent = time.Now()
+	m.send(udpPacket(udpAck, m.recvNext, nil))
+	m.mu.Unlock()
+}
+
+func (m *UDPM) recvUnreliable(seq uint32, data []byte) {
+	m.mu.Lock()
+	if int32(seq-m.uLast) <= 0 {
+		m.mu.Unlock()
+		return
+	}
+	m.uLast = seq
+	m.mu.Unlock()
+	r := bytes.NewReader(data)
+	for r.Len() > 0 {
+		im, err := readMsg(r)
+		if err != nil {
+			return
+		}
+		if im == nil {
+			continue
+		}
+		select {
+		case m.in <- im:
+		default:
+			// nobody is reading, its fine to lose these
+		}
+	}
+}
+
+func (m *UDPM) recvAck(next uint32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := 0
+	for ; i < len(m.unacked) && int32(m.unacked[i].seq-next) < 0; i++ {
+		if s := m.unacked[i]; !s.resent {
+			m.sampleRTT(time.Since(s.sent))
+		}
+	}
+	if i == 0 {
+		return
+	}
+	m.unacked = m.unacked[i:]
+	select {
+	case m.acked <- struct{}{}:
+	default:
+	}
+}
+
+func (m *UDPM) sampleRTT(rtt time.Duration) {
+	if m.srtt == 0 {
+		m.srtt = rtt
+	} else {
+		m.srtt += (rtt - m.srtt) / 8
+	}
+	m.rto = min(max(m.srtt*2, udpMinRTO), udpMaxRTO)
+}
+
+// readStream turns the reliable stream back into events
+func (m *UDPM) readStream() {
+	for {
+		im, err := readMsg(m.stream)
+		if err != nil {
+			if err != io.EOF {
+				m.close(err)
+			}
+			return
+		}
+		if im == nil {
+			continue
+		}
+		select {
+		case m.in <- im:
+		case <-m.closed:
+			return
+		}
+	}
+}
+
+// loop resends what wasnt acked in time, keeps the conn alive
+// and closes it if the other side went quiet.
+func (m *UDPM) loop() {
+	t := time.NewTicker(udpTick)
+	defer t.Stop()
+	for {
+		select {
+		case now := <-t.C:
+			if !m.tick(now) {
+				m.close(ErrUDPTimeout)
+				return
+			}
+		case <-m.closed:
+			return
+		}
+	}
+}
+
+func (m *UDPM) tick(now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if now.Sub(m.lastRecv) > UDPTimeout {
+		return false
+	}
+	resent := false
+	for i := range m.unacked {
+		s := &m.unacked[i]
+		if now.Sub(s.sent) < m.rto {
+			continue
+		}
+		s.sent, s.resent, resent = now, true, true
+		m.send(s.pkt)
+	}
+	if resent {
+		m.rto = min(m.rto*2, udpMaxRTO)
+		m.lastSent = now
+	}
+	if now.Sub(m.lastSent) > udpKeepAlive {
+		m.lastSent = now
+		m.send(udpPacket(udpAck, m.recvNext, nil))
+	}
+	return true
+}
+
+// udpStream is the reliable data in order, Read blocks until there is some
+type udpStream struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    bytes.Buffer
+	closed bool
+}
+
+func newUDPStream() *udpStream {
+	s := &udpStream{}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+func (s *udpStream) push(b []byte) {
+	s.mu.Lock()
+	s.buf.Write(b)
+	s.cond.Signal()
+	s.mu.Unlock()
+}
+
+func (s *udpStream) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.buf.Len() == 0 && !s.closed {
+		s.cond.Wait()
+	}
+	if s.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return s.buf.Read(p)
+}
+
+func (s *udpStream) close() {
+	s.mu.Lock()
+	s.closed = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+// UDPServer takes udp conns, one socket for all of them.
+type UDPServer struct {
+	pc       net.PacketConn
+	mu       sync.Mutex
+	conns    map[string]*UDPM
+	newConns chan Msgs
+}
+
+func ListenUDP(address string) (MMsgs, error) {
+	pc, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return ServeUDP(pc), nil
+}
+
+// ServeUDP takes udp conns on pc
+func ServeUDP(pc net.PacketConn) *UDPServer {
+	s := &UDPServer{
+		pc:       pc,
+		conns:    make(map[string]*UDPM),
+		newConns: make(chan Msgs, 100),
+	}
+	go s.read()
+	return s
+}
+
+func (s *UDPServer) Address() string {
+	return s.pc.LocalAddr().String()
+}
+
+func (s *UDPServer) NewConn() (Msgs, error) {
+	m, ok := <-s.newConns
+	if !ok {
+		return nil, net.ErrClosed
+	}
+	return m, nil
+}
+
+func (s *UDPServer) read() {
+	defer close(s.newConns)
+	buf := make([]byte, 2*UDPMaxPayload)
+	for {
+		n, addr, err := s.pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		p := buf[:n]
+		if n == 0 {
+			continue
+		}
+		s.mu.Lock()
+		m := s.conns[addr.String()]
+		s.mu.Unlock()
+		if p[0] == udpHello {
+			if !bytes.Equal(p[1:], udpMagic) {
+				continue
+			}
+			if m == nil && !s.accept(addr) {
+				continue
+			}
+			// the welcome could get lost, the client says hello until it gets one
+			s.pc.WriteTo(append([]byte{udpWelcome}, udpMagic...), addr)
+			continue
+		}
+		if m != nil {
+			m.handle(p)
+		} else if p[0] != udpClose {
+			// a conn we already forgot about, so it doesnt wait for the timeout
+			s.pc.WriteTo([]byte{udpClose}, addr)
+		}
+	}
+}
+
+func (s *UDPServer) accept(addr net.Addr) bool {
+	key := addr.String()
+	m := newUDPM(addr, func(b []byte) error {
+		_, err := s.pc.WriteTo(b, addr)
+		return err
+	})
+	m.onClose = func() {
+		s.mu.Lock()
+		if s.conns[key] == m {
+			delete(s.conns, key)
+		}
+		s.mu.Unlock()
+	}
+	s.mu.Lock()
+	s.conns[key] = m
+	s.mu.Unlock()
+	select {
+	case s.newConns <- m:
+		return true
+	default:
+		m.close(errors.New("too many udp conns waiting"))
+		return false
+	}
+}
+
+// DialUDP connects to a UDPServer, it fails if the
+// server doesnt answer the hello in UDPHandshakeTimeout.
+func DialUDP(address string) (Msgs, error) {
+	c, err := net.Dial("udp4", address)
+	if err != nil {
+		return nil, err
+	}
+	return DialUDPFrom(dialedConn{c}, c.RemoteAddr())
+}
+
+// DialUDPFrom connects to the UDPServer at addr through pc,
+// pc is closed with the conn or if the server doesnt answer.
+func DialUDPFrom(pc net.PacketConn, addr net.Addr) (Msgs, error) {
+	if err := udpHandshake(pc, addr); err != nil {
+		pc.Close()
+		return nil, err
+	}
+	m := newUDPM(addr, func(b []byte) error {
+		_, err := pc.WriteTo(b, addr)
+		return err
+	})
+	m.onClose = func() { pc.Close() }
+	go func() {
+		buf := make([]byte, 2*UDPMaxPayload)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				m.close(err)
+				return
+			}
+			if from.String() != addr.String() {
+				continue
+			}
+			m.handle(buf[:n])
+		}
+	}()
+	return m, nil
+}
+
+// dialedConn is a dialed udp conn as a PacketConn, it only
+// talks to the address it was dialed to.
+type dialedConn struct {
+	net.Conn
+}
+
+func (c dialedConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, err := c.Read(p)
+	return n, c.RemoteAddr(), err
+}
+
+func (c dialedConn) WriteTo(p []byte, _ net.Addr) (int, error) {
+	return c.Write(p)
+}
+
+func udpHandshake(pc net.PacketConn, addr net.Addr) error {
+	hello := append([]byte{udpHello}, udpMagic...)
+	buf := make([]byte, 2*UDPMaxPayload)
+	giveUp := time.Now().Add(UDPHandshakeTimeout)
+	defer pc.SetReadDeadline(time.Time{})
+	for time.Now().Before(giveUp) {
+		if _, err := pc.WriteTo(hello, addr); err != nil {
+			return err
+		}
+		wait := time.Now().Add(250 * time.Millisecond)
+		if wait.After(giveUp) {
+			wait = giveUp
+		}
+		pc.SetReadDeadline(wait)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if from.String() == addr.String() && n > 0 && buf[0] == udpWelcome && bytes.Equal(buf[1:n], udpMagic) {
+				return nil
+			}
+		}
+	}
+	return ErrUDPTimeout
+}
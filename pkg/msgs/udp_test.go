@@ -0,0 +1,240 @@
+package msgs_test
+
+import (
+	"bytes"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rywk/minigoao/pkg/msgs"
+	"github.com/rywk/minigoao/pkg/typ"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUDP(t *testing.T) {
+	mms, err := msgs.ListenUDP("127.0.0.1:0")
+	require.NoError(t, err)
+	c, err := msgs.DialUDP(mms.Address())
+	require.NoError(t, err)
+	defer c.Close()
+	s, err := mms.NewConn()
+	require.NoError(t, err)
+	defer s.Close()
+
+	// bigger than a packet, it has to go in pieces on the reliable channel
+	long := strings.Repeat("hola ", 2000)
+	require.NoError(t, c.EncodeAndWrite(msgs.ESendChat, &msgs.EventSendChat{Msg: long}))
+	im, err := s.Read()
+	require.NoError(t, err)
+	require.Equal(t, msgs.ESendChat, im.Event)
+	require.Equal(t, long, msgs.DecodeMsgpack(im.Data, &msgs.EventSendChat{}).Msg)
+
+	// the move goes unreliable, so only the events are checked, not the order
+	require.NoError(t, s.WriteBatch(testBatch(t)))
+	got := map[msgs.E][]byte{}
+	for range 3 {
+		im, err := c.Read()
+		require.NoError(t, err)
+		got[im.Event] = im.Data
+	}
+	require.Equal(t, typ.P{X: 3, Y: 4}, msgs.DecodeEventPlayerMoved(got[msgs.EPlayerMoved]).Pos)
+	require.Equal(t, "hola", msgs.DecodeMsgpack(got[msgs.EBroadcastChat], &msgs.EventBroadcastChat{}).Msg)
+	require.Contains(t, got, msgs.EPlayerLeaveViewport)
+
+	c.Close()
+	_, err = s.Read()
+	require.Error(t, err)
+}
+
+func TestUDPNoServer(t *testing.T) {
+	mms, err := msgs.ListenTCP("127.0.0.1:0")
+	require.NoError(t, err)
+	_, err = msgs.DialUDP(mms.Address())
+	require.Error(t, err)
+}
+
+// lossyConn is a udp socket that loses, repeats and swaps packets
+// on the way out once lossy is on, off it just passes them.
+type lossyConn struct {
+	net.PacketConn
+	lossy atomic.Bool
+	// blackhole drops everything, lossy or not
+	blackhole atomic.Bool
+	dropped   atomic.Int32
+
+	mu      sync.Mutex
+	rand    *rand.Rand
+	drop    float64
+	dup     float64
+	reorder float64
+	held    []byte
+	heldTo  net.Addr
+}
+
+func newLossyConn(t *testing.T, drop, dup, reorder float64) *lossyConn {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	return &lossyConn{
+		PacketConn: pc,
+		rand:       rand.New(rand.NewSource(1)),
+		drop:       drop,
+		dup:        dup,
+		reorder:    reorder,
+	}
+}
+
+func (c *lossyConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if c.blackhole.Load() {
+		c.dropped.Add(1)
+		return len(p), nil
+	}
+	if !c.lossy.Load() {
+		return c.PacketConn.WriteTo(p, addr)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch r := c.rand.Float64(); {
+	case r < c.drop:
+		c.dropped.Add(1)
+		return len(p), nil
+	case r < c.drop+c.dup:
+		c.PacketConn.WriteTo(p, addr)
+	case r < c.drop+c.dup+c.reorder && c.held == nil:
+		// it goes after the next one
+		c.held, c.heldTo = bytes.Clone(p), addr
+		return len(p), nil
+	}
+	n, err := c.PacketConn.WriteTo(p, addr)
+	if c.held != nil {
+		c.PacketConn.WriteTo(c.held, c.heldTo)
+		c.held = nil
+	}
+	return n, err
+}
+
+// dialLossy connects a client and a server that both go through a lossyConn
+func dialLossy(t *testing.T, drop, dup, reorder float64) (c, s msgs.Msgs, cpc, spc *lossyConn) {
+	spc = newLossyConn(t, drop, dup, reorder)
+	cpc = newLossyConn(t, drop, dup, reorder)
+	mms := msgs.ServeUDP(spc)
+	c, err := msgs.DialUDPFrom(cpc, spc.LocalAddr())
+	require.NoError(t, err)
+	s, err = mms.NewConn()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		c.Close()
+		s.Close()
+		spc.Close()
+	})
+	return c, s, cpc, spc
+}
+
+// readTimeout is the next event, nil if nothing comes in d
+func readTimeout(t *testing.T, m msgs.Msgs, d time.Duration) *msgs.IncomingData {
+	got := make(chan *msgs.IncomingData, 1)
+	go func() {
+		im, err := m.Read()
+		if err != nil {
+			im = nil
+		}
+		got <- im
+	}()
+	select {
+	case im := <-got:
+		return im
+	case <-time.After(d):
+		return nil
+	}
+}
+
+func TestUDPLossyReliable(t *testing.T) {
+	c, s, cpc, spc := dialLossy(t, 0.1, 0.1, 0.2)
+	cpc.lossy.Store(true)
+	spc.lossy.Store(true)
+
+	const n = msgs.UDPWindow + 64
+	go func() {
+		for i := range n {
+			c.EncodeAndWrite(msgs.ESendChat, &msgs.EventSendChat{Msg: strconv.Itoa(i)})
+		}
+	}()
+	// all of them, in order and once
+	for i := range n {
+		im := readTimeout(t, s, 5*time.Second)
+		require.NotNil(t, im, "event %v never came", i)
+		require.Equal(t, msgs.ESendChat, im.Event)
+		require.Equal(t, strconv.Itoa(i), msgs.DecodeMsgpack(im.Data, &msgs.EventSendChat{}).Msg)
+	}
+	require.Nil(t, readTimeout(t, s, 500*time.Millisecond))
+	require.NotZero(t, cpc.dropped.Load())
+}
+
+func TestUDPLossyUnreliable(t *testing.T) {
+	c, s, _, spc := dialLossy(t, 0.2, 0.2, 0.3)
+	spc.lossy.Store(true)
+
+	const n = 200
+	go func() {
+		for i := range n {
+			s.EncodeAndWrite(msgs.EPlayerMoved, &msgs.EventPlayerMoved{ID: 1, Pos: typ.P{X: int32(i + 1)}})
+		}
+	}()
+	// some get lost, but never a stale or repeated one
+	last, got := int32(0), 0
+	for {
+		im := readTimeout(t, c, 500*time.Millisecond)
+		if im == nil {
+			break
+		}
+		require.Equal(t, msgs.EPlayerMoved, im.Event)
+		x := msgs.DecodeEventPlayerMoved(im.Data).Pos.X
+		require.Greater(t, x, last)
+		last = x
+		got++
+	}
+	require.NotZero(t, got)
+	require.Less(t, got, n)
+}
+
+func TestUDPWindowFull(t *testing.T) {
+	c, s, _, spc := dialLossy(t, 0, 0, 0)
+	// the server gets everything but its acks never make it back
+	spc.blackhole.Store(true)
+
+	c.SetWriteDeadline(time.Now().Add(300 * time.Millisecond))
+	for i := range msgs.UDPWindow {
+		require.NoError(t, c.EncodeAndWrite(msgs.ESendChat, &msgs.EventSendChat{Msg: strconv.Itoa(i)}))
+	}
+	err := c.EncodeAndWrite(msgs.ESendChat, &msgs.EventSendChat{Msg: strconv.Itoa(msgs.UDPWindow)})
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+	// once the acks come back the window opens
+	spc.blackhole.Store(false)
+	c.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	require.NoError(t, c.EncodeAndWrite(msgs.ESendChat, &msgs.EventSendChat{Msg: strconv.Itoa(msgs.UDPWindow)}))
+	for i := range msgs.UDPWindow + 1 {
+		im := readTimeout(t, s, 5*time.Second)
+		require.NotNil(t, im, "event %v never came", i)
+		require.Equal(t, strconv.Itoa(i), msgs.DecodeMsgpack(im.Data, &msgs.EventSendChat{}).Msg)
+	}
+}
+
+func TestUDPRetransmit(t *testing.T) {
+	c, s, cpc, _ := dialLossy(t, 0, 0, 0)
+	// the first send and the resends after the rto are lost
+	cpc.blackhole.Store(true)
+	require.NoError(t, c.EncodeAndWrite(msgs.ESendChat, &msgs.EventSendChat{Msg: "hola"}))
+	time.Sleep(500 * time.Millisecond)
+	cpc.blackhole.Store(false)
+	require.GreaterOrEqual(t, cpc.dropped.Load(), int32(2))
+
+	im := readTimeout(t, s, 5*time.Second)
+	require.NotNil(t, im)
+	require.Equal(t, "hola", msgs.DecodeMsgpack(im.Data, &msgs.EventSendChat{}).Msg)
+}
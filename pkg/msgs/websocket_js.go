@@ -2,6 +2,7 @@ package msgs
 
 import (
 	"net"
+	"time"
 
 	owss "github.com/tarndt/wasmws"
 )
@@ -36,6 +37,10 @@ func (ws *WSM3) Close() {
 	ws.c.Close()
 }
 
+func (ws *WSM3) SetWriteDeadline(t time.Time) error {
+	return ws.c.SetWriteDeadline(t)
+}
+
 func (ws *WSM3) Read() (*IncomingData, error) {
 	return readMsg(ws.c)
 }
@@ -52,3 +57,8 @@ func (ws *WSM3) WriteWithLen(event E, data []byte) error {
 func (ws *WSM3) EncodeAndWrite(e E, msg interface{}) error {
 	return encodeAndWrite(ws, e, msg)
 }
+
+func (ws *WSM3) WriteBatch(b *Batch) error {
+	_, err := ws.c.Write(b.Bytes())
+	return err
+}
@@ -3,8 +3,10 @@ package msgs
 import (
 	"context"
 	"crypto/tls"
+	"io"
 	"log"
 	"net"
+	"time"
 
 	wswasm "github.com/coder/websocket"
 	"github.com/gorilla/websocket"
@@ -14,6 +16,11 @@ func DialServer(address string, web, secure bool) (Msgs, error) {
 	if web {
 		return DialWS2(address, secure)
 	}
+	m, err := DialUDP(address)
+	if err == nil {
+		return m, nil
+	}
+	log.Printf("udp: %v, going with tcp\n", err)
 	return DialTCP(address)
 }
 
@@ -48,8 +55,11 @@ func DialWS2(address string, secure bool) (Msgs, error) {
 }
 
 type WSM2 struct {
-	addr string
-	c    *wswasm.Conn
+	addr     string
+	c        *wswasm.Conn
+	deadline time.Time
+	// reader of the current frame, a frame can have many events
+	r io.Reader
 }
 
 func (ws *WSM2) IP() string {
@@ -60,15 +70,39 @@ func (ws *WSM2) Close() {
 	ws.c.CloseNow()
 }
 
+func (ws *WSM2) SetWriteDeadline(t time.Time) error {
+	ws.deadline = t
+	return nil
+}
+
+func (ws *WSM2) writeCtx() (context.Context, context.CancelFunc) {
+	if ws.deadline.IsZero() {
+		return context.TODO(), func() {}
+	}
+	return context.WithDeadline(context.Background(), ws.deadline)
+}
+
 func (ws *WSM2) Read() (*IncomingData, error) {
-	_, r, err := ws.c.Reader(context.TODO())
-	if err != nil {
-		return nil, err
+	for {
+		if ws.r == nil {
+			_, r, err := ws.c.Reader(context.TODO())
+			if err != nil {
+				return nil, err
+			}
+			ws.r = r
+		}
+		im, err := readMsg(ws.r)
+		if err == io.EOF {
+			ws.r = nil
+			continue
+		}
+		return im, err
 	}
-	return readMsg(r)
 }
 func (ws *WSM2) Write(event E, data []byte) error {
-	w, err := ws.c.Writer(context.TODO(), wswasm.MessageBinary)
+	ctx, cancel := ws.writeCtx()
+	defer cancel()
+	w, err := ws.c.Writer(ctx, wswasm.MessageBinary)
 	if err != nil {
 		return err
 	}
@@ -77,7 +111,9 @@ func (ws *WSM2) Write(event E, data []byte) error {
 }
 
 func (ws *WSM2) WriteWithLen(event E, data []byte) error {
-	w, err := ws.c.Writer(context.TODO(), websocket.BinaryMessage)
+	ctx, cancel := ws.writeCtx()
+	defer cancel()
+	w, err := ws.c.Writer(ctx, websocket.BinaryMessage)
 	if err != nil {
 		return err
 	}
@@ -89,6 +125,12 @@ func (ws *WSM2) EncodeAndWrite(e E, msg interface{}) error {
 	return encodeAndWrite(ws, e, msg)
 }
 
+func (ws *WSM2) WriteBatch(b *Batch) error {
+	ctx, cancel := ws.writeCtx()
+	defer cancel()
+	return ws.c.Write(ctx, wswasm.MessageBinary, b.Bytes())
+}
+
 func DialWS(address string) (Msgs, error) {
 	url := "ws://" + address + "/upgrader"
 	log.Print(url)
@@ -96,6 +138,6 @@ func DialWS(address string) (Msgs, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &WSM{c}, nil
+	return &WSM{c: c}, nil
 
 }
@@ -0,0 +1,314 @@
+// Package pathfind finds routes over a grid.Grid with A*,
+// layer 1 of the grid is what blocks, like it does for the moves.
+package pathfind
+
+import (
+	"errors"
+
+	"github.com/rywk/minigoao/pkg/constants/direction"
+	"github.com/rywk/minigoao/pkg/grid"
+	"github.com/rywk/minigoao/pkg/typ"
+)
+
+var (
+	ErrNoPath = errors.New("no path")
+	// The path returned with it goes to the closest tile to the goal we found
+	ErrBudget = errors.New("search budget exhausted")
+)
+
+// DefaultBudget is enough to cross the 100x100 map around the arenas.
+const DefaultBudget = 4000
+
+type Options struct {
+	// Also go around tiles with something in layer 0 (players, npcs),
+	// the goal can be occupied, you can path to who you are chasing.
+	AvoidOccupied bool
+	// Max tiles expanded before giving up, 0 is DefaultBudget.
+	Budget int
+	// Extra tiles that cant be walked, like safe zones for npcs.
+	Blocked func(p typ.P) bool
+}
+
+// Finder keeps the memory of the searches and a cache of the paths
+// found, it's not safe to use from more than one goroutine.
+type Finder struct {
+	g    *grid.Grid
+	w, h int32
+
+	// per tile state, valid only when seen[i] == gen
+	seen []uint32
+	cost []int32
+	from []int32
+	done []bool
+	gen  uint32
+	open openSet
+
+	cache     map[pathKey][]typ.P
+	cacheSize int
+}
+
+type pathKey struct {
+	from, to typ.P
+	occupied bool
+}
+
+func NewFinder(g *grid.Grid, cacheSize int) *Finder {
+	w, h := g.Rect.Max.X, g.Rect.Max.Y
+	n := w * h
+	return &Finder{
+		g:         g,
+		w:         w,
+		h:         h,
+		seen:      make([]uint32, n),
+		cost:      make([]int32, n),
+		from:      make([]int32, n),
+		done:      make([]bool, n),
+		cache:     make(map[pathKey][]typ.P, cacheSize),
+		cacheSize: cacheSize,
+	}
+}
+
+// Reset drops the cached paths, call it when the map changes.
+// Paths that got blocked are dropped anyway when they are asked again,
+// this is for the ones that could be shorter now.
+func (f *Finder) Reset() {
+	clear(f.cache)
+}
+
+// Find returns the tiles to walk from one tile to the other,
+// without from and ending in to.
+func (f *Finder) Find(from, to typ.P, opt Options) ([]typ.P, error) {
+	if from.Out(f.g.Rect) || to.Out(f.g.Rect) {
+		return nil, ErrNoPath
+	}
+	if from == to {
+		return []typ.P{}, nil
+	}
+	key := pathKey{from, to, opt.AvoidOccupied}
+	if path, ok := f.cache[key]; ok {
+		if f.walkable(path, to, opt) {
+			return append([]typ.P(nil), path...), nil
+		}
+		delete(f.cache, key)
+	}
+	path, err := f.search(from, to, opt)
+	if err != nil {
+		return path, err
+	}
+	if f.cacheSize > 0 {
+		if len(f.cache) >= f.cacheSize {
+			// drop any, map iteration order is random enough
+			for k := range f.cache {
+				delete(f.cache, k)
+				break
+			}
+		}
+		f.cache[key] = path
+	}
+	return append([]typ.P(nil), path...), nil
+}
+
+func (f *Finder) blocked(p, goal typ.P, opt *Options) bool {
+	if p.Out(f.g.Rect) || f.g.GetSlot(1, p) != 0 {
+		return true
+	}
+	if opt.AvoidOccupied && p != goal && f.g.GetSlot(0, p) != 0 {
+		return true
+	}
+	return opt.Blocked != nil && opt.Blocked(p)
+}
+
+func (f *Finder) walkable(path []typ.P, goal typ.P, opt Options) bool {
+	for _, p := range path {
+		if f.blocked(p, goal, &opt) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *Finder) index(p typ.P) int32 {
+	return p.X*f.h + p.Y
+}
+
+func (f *Finder) point(i int32) typ.P {
+	return typ.P{X: i / f.h, Y: i % f.h}
+}
+
+func (f *Finder) search(start, goal typ.P, opt Options) ([]typ.P, error) {
+	budget := opt.Budget
+	if budget <= 0 {
+		budget = DefaultBudget
+	}
+	f.gen++
+	if f.gen == 0 {
+		// wrapped, old marks would look valid again
+		clear(f.seen)
+		f.gen = 1
+	}
+	f.open = f.open[:0]
+
+	si, gi := f.index(start), f.index(goal)
+	f.visit(si, 0, -1)
+	f.open.push(node{i: si, g: 0, f: manhattan(start, goal)})
+	closest, closestH := si, manhattan(start, goal)
+
+	for expanded := 0; len(f.open) > 0; expanded++ {
+		if expanded >= budget {
+			return f.build(closest), ErrBudget
+		}
+		n := f.open.pop()
+		if f.done[n.i] {
+			continue
+		}
+		f.done[n.i] = true
+		if n.i == gi {
+			return f.build(gi), nil
+		}
+		p := f.point(n.i)
+		if h := manhattan(p, goal); h < closestH {
+			closest, closestH = n.i, h
+		}
+		for _, d := range direction.List {
+			np := Step(p, d)
+			if f.blocked(np, goal, &opt) {
+				continue
+			}
+			ni := f.index(np)
+			g := n.g + 1
+			if f.seen[ni] == f.gen && (f.done[ni] || f.cost[ni] <= g) {
+				continue
+			}
+			f.visit(ni, g, n.i)
+			f.open.push(node{i: ni, g: g, f: g + manhattan(np, goal)})
+		}
+	}
+	return nil, ErrNoPath
+}
+
+func (f *Finder) visit(i, cost, from int32) {
+	if f.seen[i] != f.gen {
+		f.seen[i] = f.gen
+		f.done[i] = false
+	}
+	f.cost[i] = cost
+	f.from[i] = from
+}
+
+func (f *Finder) build(end int32) []typ.P {
+	n := 0
+	for i := end; f.from[i] != -1; i = f.from[i] {
+		n++
+	}
+	path := make([]typ.P, n)
+	for i := end; f.from[i] != -1; i = f.from[i] {
+		n--
+		path[n] = f.point(i)
+	}
+	return path
+}
+
+// Step is the tile next to p in direction d.
+func Step(p typ.P, d direction.D) typ.P {
+	switch d {
+	case direction.Front:
+		p.Y++
+	case direction.Back:
+		p.Y--
+	case direction.Left:
+		p.X--
+	case direction.Right:
+		p.X++
+	}
+	return p
+}
+
+// Direction to go from a tile to the one next to it.
+func Direction(from, to typ.P) direction.D {
+	switch {
+	case to.Y > from.Y:
+		return direction.Front
+	case to.Y < from.Y:
+		return direction.Back
+	case to.X < from.X:
+		return direction.Left
+	case to.X > from.X:
+		return direction.Right
+	}
+	return direction.Still
+}
+
+// Directions turns a path into the moves to walk it.
+func Directions(from typ.P, path []typ.P) []direction.D {
+	ds := make([]direction.D, len(path))
+	for i, p := range path {
+		ds[i] = Direction(from, p)
+		from = p
+	}
+	return ds
+}
+
+func manhattan(a, b typ.P) int32 {
+	dx, dy := a.X-b.X, a.Y-b.Y
+	if dx < 0 {
+		dx = -dx
+	}
+	if dy < 0 {
+		dy = -dy
+	}
+	return dx + dy
+}
+
+type node struct {
+	i    int32
+	g, f int32
+}
+
+// openSet is a binary heap by f, not using container/heap
+// saves boxing every node into an interface
+type openSet []node
+
+func (o openSet) less(i, j int) bool {
+	// on ties go for the one further from the start, it's closer to the goal
+	if o[i].f == o[j].f {
+		return o[i].g > o[j].g
+	}
+	return o[i].f < o[j].f
+}
+
+func (o *openSet) push(n node) {
+	*o = append(*o, n)
+	h := *o
+	for i := len(h) - 1; i > 0; {
+		parent := (i - 1) / 2
+		if !h.less(i, parent) {
+			break
+		}
+		h[i], h[parent] = h[parent], h[i]
+		i = parent
+	}
+}
+
+func (o *openSet) pop() node {
+	h := *o
+	top := h[0]
+	last := len(h) - 1
+	h[0] = h[last]
+	h = h[:last]
+	for i := 0; ; {
+		smallest, l, r := i, 2*i+1, 2*i+2
+		if l < len(h) && h.less(l, smallest) {
+			smallest = l
+		}
+		if r < len(h) && h.less(r, smallest) {
+			smallest = r
+		}
+		if smallest == i {
+			break
+		}
+		h[i], h[smallest] = h[smallest], h[i]
+		i = smallest
+	}
+	*o = h
+	return top
+}
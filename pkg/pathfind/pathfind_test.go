@@ -0,0 +1,136 @@
+package pathfind_test
+
+import (
+	"testing"
+
+	"github.com/rywk/minigoao/pkg/grid"
+	"github.com/rywk/minigoao/pkg/pathfind"
+	"github.com/rywk/minigoao/pkg/typ"
+	"github.com/rywk/minigoao/pkg/world"
+	"github.com/stretchr/testify/require"
+)
+
+// defaultGrid is the default map, shrooms around it and the two arenas
+func defaultGrid() *grid.Grid {
+	m := world.Default()
+	g := grid.NewGrid(m.W, m.H, 2)
+	for y := int32(0); y < m.H; y++ {
+		for x := int32(0); x < m.W; x++ {
+			if m.Blocked[y][x] {
+				g.SetSlot(1, typ.P{X: x, Y: y}, 1)
+			}
+		}
+	}
+	return g
+}
+
+func requireWalkable(t *testing.T, g *grid.Grid, from typ.P, path []typ.P) {
+	for _, p := range path {
+		require.True(t, p.In(g.Rect), p)
+		require.Zero(t, g.GetSlot(1, p), p)
+		require.NotZero(t, pathfind.Direction(from, p), "%v -> %v is not a step", from, p)
+		require.Equal(t, p, pathfind.Step(from, pathfind.Direction(from, p)))
+		from = p
+	}
+}
+
+func TestFindStraight(t *testing.T) {
+	f := pathfind.NewFinder(defaultGrid(), 0)
+	path, err := f.Find(typ.P{X: 5, Y: 5}, typ.P{X: 10, Y: 5}, pathfind.Options{})
+	require.NoError(t, err)
+	require.Len(t, path, 5)
+	require.Equal(t, typ.P{X: 10, Y: 5}, path[4])
+}
+
+func TestFindIntoArena(t *testing.T) {
+	g := defaultGrid()
+	f := pathfind.NewFinder(g, 0)
+	from, to := typ.P{X: 5, Y: 5}, typ.P{X: 45, Y: 35}
+	path, err := f.Find(from, to, pathfind.Options{})
+	require.NoError(t, err)
+	require.Equal(t, to, path[len(path)-1])
+	requireWalkable(t, g, from, path)
+	// it has to go around to a door, so longer than straight
+	require.Greater(t, len(path), 40+30)
+}
+
+func TestFindNoPath(t *testing.T) {
+	g := defaultGrid()
+	to := typ.P{X: 10, Y: 10}
+	for _, p := range []typ.P{{X: 9, Y: 10}, {X: 11, Y: 10}, {X: 10, Y: 9}, {X: 10, Y: 11}} {
+		g.SetSlot(1, p, 1)
+	}
+	f := pathfind.NewFinder(g, 0)
+	// it has to flood the whole map to know
+	_, err := f.Find(typ.P{X: 5, Y: 5}, to, pathfind.Options{Budget: 100 * 100})
+	require.ErrorIs(t, err, pathfind.ErrNoPath)
+}
+
+func TestFindBudget(t *testing.T) {
+	f := pathfind.NewFinder(defaultGrid(), 0)
+	from, to := typ.P{X: 1, Y: 1}, typ.P{X: 98, Y: 98}
+	path, err := f.Find(from, to, pathfind.Options{Budget: 50})
+	require.ErrorIs(t, err, pathfind.ErrBudget)
+	require.NotEmpty(t, path)
+	last := path[len(path)-1]
+	require.Less(t, last.X+last.Y, to.X+to.Y)
+}
+
+func TestFindAvoidOccupied(t *testing.T) {
+	g := defaultGrid()
+	from, to := typ.P{X: 5, Y: 5}, typ.P{X: 10, Y: 5}
+	g.SetSlot(0, typ.P{X: 7, Y: 5}, 3)
+	g.SetSlot(0, to, 4)
+	f := pathfind.NewFinder(g, 0)
+	path, err := f.Find(from, to, pathfind.Options{AvoidOccupied: true})
+	require.NoError(t, err)
+	require.NotContains(t, path, typ.P{X: 7, Y: 5})
+	require.Equal(t, to, path[len(path)-1])
+
+	path, err = f.Find(from, to, pathfind.Options{})
+	require.NoError(t, err)
+	require.Contains(t, path, typ.P{X: 7, Y: 5})
+}
+
+func TestFindCacheDropsBlockedPaths(t *testing.T) {
+	g := defaultGrid()
+	from, to := typ.P{X: 5, Y: 5}, typ.P{X: 10, Y: 5}
+	f := pathfind.NewFinder(g, 8)
+	path, err := f.Find(from, to, pathfind.Options{})
+	require.NoError(t, err)
+	require.Len(t, path, 5)
+
+	g.SetSlot(1, typ.P{X: 7, Y: 5}, 1)
+	path, err = f.Find(from, to, pathfind.Options{})
+	require.NoError(t, err)
+	require.Len(t, path, 7)
+	requireWalkable(t, g, from, path)
+}
+
+func BenchmarkFindCorners(b *testing.B) {
+	f := pathfind.NewFinder(defaultGrid(), 0)
+	for i := 0; i < b.N; i++ {
+		f.Find(typ.P{X: 1, Y: 1}, typ.P{X: 98, Y: 98}, pathfind.Options{Budget: 10000})
+	}
+}
+
+func BenchmarkFindIntoArena(b *testing.B) {
+	f := pathfind.NewFinder(defaultGrid(), 0)
+	for i := 0; i < b.N; i++ {
+		f.Find(typ.P{X: 5, Y: 5}, typ.P{X: 45, Y: 35}, pathfind.Options{})
+	}
+}
+
+func BenchmarkFindCached(b *testing.B) {
+	f := pathfind.NewFinder(defaultGrid(), 64)
+	for i := 0; i < b.N; i++ {
+		f.Find(typ.P{X: 5, Y: 5}, typ.P{X: 45, Y: 35}, pathfind.Options{})
+	}
+}
+
+func BenchmarkFindShort(b *testing.B) {
+	f := pathfind.NewFinder(defaultGrid(), 0)
+	for i := 0; i < b.N; i++ {
+		f.Find(typ.P{X: 20, Y: 60}, typ.P{X: 27, Y: 66}, pathfind.Options{AvoidOccupied: true})
+	}
+}
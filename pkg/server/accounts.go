@@ -0,0 +1,74 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/rywk/minigoao/pkg/msgs"
+)
+
+// Account reserves a nick, whoever logs in with it needs the password.
+// The file stores the hex sha256 of the password.
+type Account struct {
+	Nick           string
+	PasswordSHA256 string
+	Role           string
+}
+
+// Accounts are by the lower case nick, "Admin" and "ADMIN" are the same account
+type Accounts map[string]*Account
+
+var ErrBadPassword = errors.New("bad password")
+
+func LoadAccounts(path string) (Accounts, error) {
+	accs := Accounts{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return accs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	list := []*Account{}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	for _, a := range list {
+		if _, ok := msgs.RoleFromString(a.Role); !ok {
+			return nil, fmt.Errorf("account %v: unknown role %q", a.Nick, a.Role)
+		}
+		key := strings.ToLower(a.Nick)
+		if o := accs[key]; o != nil {
+			return nil, fmt.Errorf("accounts %v and %v are the same nick", o.Nick, a.Nick)
+		}
+		accs[key] = a
+	}
+	return accs, nil
+}
+
+// Get is the account of nick in any case
+func (accs Accounts) Get(nick string) (*Account, bool) {
+	a, ok := accs[strings.ToLower(nick)]
+	return a, ok
+}
+
+// Auth returns the role of the nick, nicks without an account are players.
+func (accs Accounts) Auth(nick, password string) (msgs.Role, error) {
+	a, ok := accs.Get(nick)
+	if !ok {
+		return msgs.RolePlayer, nil
+	}
+	sum := sha256.Sum256([]byte(password))
+	if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(a.PasswordSHA256)) != 1 {
+		return msgs.RolePlayer, ErrBadPassword
+	}
+	role, _ := msgs.RoleFromString(a.Role)
+	return role, nil
+}
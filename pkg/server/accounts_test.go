@@ -0,0 +1,52 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rywk/minigoao/pkg/msgs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccountsAnyCase(t *testing.T) {
+	sum := sha256.Sum256([]byte("secret"))
+	path := filepath.Join(t.TempDir(), "accounts.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[{"Nick":"Admin","PasswordSHA256":"`+hex.EncodeToString(sum[:])+`","Role":"admin"}]`), 0o644))
+	accs, err := LoadAccounts(path)
+	require.NoError(t, err)
+
+	for _, nick := range []string{"Admin", "admin", "ADMIN"} {
+		_, err := accs.Auth(nick, "")
+		require.ErrorIs(t, err, ErrBadPassword, nick)
+		role, err := accs.Auth(nick, "secret")
+		require.NoError(t, err, nick)
+		require.Equal(t, msgs.RoleAdmin, role, nick)
+	}
+	role, err := accs.Auth("someone", "")
+	require.NoError(t, err)
+	require.Equal(t, msgs.RolePlayer, role)
+
+	require.NoError(t, os.WriteFile(path, []byte(`[{"Nick":"Admin","Role":"admin"},{"Nick":"ADMIN","Role":"mod"}]`), 0o644))
+	_, err = LoadAccounts(path)
+	require.Error(t, err)
+}
+
+func TestNickTaken(t *testing.T) {
+	g := newTestGame(t)
+	p := newTestPlayer(t, g, "pepe")
+	require.True(t, g.nickTaken("PEPE"))
+
+	s, c := net.Pipe()
+	t.Cleanup(func() { c.Close() })
+	go io.Copy(io.Discard, c)
+	other := &Player{g: g, m: msgs.New(s), nick: "Pepe", out: newOutQueue()}
+	g.handle(IncomingMsg{Event: msgs.EPlayerConnect, Data: other})
+	require.Zero(t, other.id)
+	require.Equal(t, p, g.playerByNick("pepe"))
+	require.Len(t, g.onlinePlayers(), 1)
+}
@@ -16,7 +16,10 @@ type SpellProp struct {
 	BaseDamage int32
 	RNGRange   int32
 	ManaCost   int32
+	Offensive  bool
 	Cast       func(from, to *Player, calc int32) error
+	// nil for the spells that dont work on npcs
+	CastNPC func(from *Player, to *NPC, calc int32) error
 }
 
 var ErrorNoMana = errors.New("no mana")
@@ -24,12 +27,14 @@ var ErrorTargetDead = errors.New("target dead")
 var ErrorTargetAlive = errors.New("target alive")
 var ErrorCasterDead = errors.New("caster dead")
 var ErrorSelfCast = errors.New("cant self cast")
+var ErrorNotOnNPCs = errors.New("cant cast on npcs")
 
 var spellProps = [spell.Len]SpellProp{
 	{Spell: spell.None},
 	{
-		Spell:    spell.Paralize,
-		ManaCost: 200,
+		Spell:     spell.Paralize,
+		ManaCost:  200,
+		Offensive: true,
 		Cast: func(from, to *Player, calc int32) error {
 			if from == to {
 				return ErrorSelfCast
@@ -37,6 +42,11 @@ var spellProps = [spell.Len]SpellProp{
 			to.paralized = true
 			return nil
 		},
+		CastNPC: func(from *Player, to *NPC, calc int32) error {
+			to.paralized = true
+			to.paralizedAt = time.Now()
+			return nil
+		},
 	},
 	{
 		Spell:    spell.RemoveParalize,
@@ -75,6 +85,7 @@ var spellProps = [spell.Len]SpellProp{
 		ManaCost:   550,
 		BaseDamage: 81,
 		RNGRange:   6,
+		Offensive:  true,
 		Cast: func(from, to *Player, calc int32) error {
 			if from == to {
 				return ErrorSelfCast
@@ -82,12 +93,17 @@ var spellProps = [spell.Len]SpellProp{
 			to.TakeDamage(calc)
 			return nil
 		},
+		CastNPC: func(from *Player, to *NPC, calc int32) error {
+			to.TakeDamage(calc)
+			return nil
+		},
 	},
 	{
 		Spell:      spell.Explode,
 		ManaCost:   1100,
 		BaseDamage: 177,
 		RNGRange:   10,
+		Offensive:  true,
 		Cast: func(from, to *Player, calc int32) error {
 			if from == to {
 				return ErrorSelfCast
@@ -95,6 +111,10 @@ var spellProps = [spell.Len]SpellProp{
 			to.TakeDamage(calc)
 			return nil
 		},
+		CastNPC: func(from *Player, to *NPC, calc int32) error {
+			to.TakeDamage(calc)
+			return nil
+		},
 	},
 }
 
@@ -125,6 +145,33 @@ func Cast(s *SpellProp, from, to *Player) (int32, error) {
 	return calc, nil
 }
 
+func CastOnNPC(s *SpellProp, from *Player, to *NPC) (int32, error) {
+	if s.CastNPC == nil {
+		return 0, ErrorNotOnNPCs
+	}
+	if from.dead {
+		return 0, ErrorCasterDead
+	}
+	if to.dead {
+		return 0, ErrorTargetDead
+	}
+	if from.mp < s.ManaCost {
+		return 0, ErrorNoMana
+	}
+	from.mp = from.mp - s.ManaCost
+	calc := s.BaseDamage
+	if s.RNGRange != 0 {
+		calc = calc + int32(rand.Intn(int(s.RNGRange)))
+	}
+	err := s.CastNPC(from, to, calc)
+	if err != nil {
+		from.mp = from.mp + s.ManaCost
+		return 0, err
+	}
+	to.aggro(from)
+	return calc, nil
+}
+
 func GetSpellProp(s spell.Spell) *SpellProp {
 	return &spellProps[s]
 }
@@ -141,6 +188,13 @@ func Melee(from, to *Player) int32 {
 	return calc
 }
 
+func MeleeNPC(from *Player, to *NPC) int32 {
+	calc := MeleeBaseDamage + int32(rand.Intn(int(MeleeRNGRange)))
+	to.TakeDamage(calc)
+	to.aggro(from)
+	return calc
+}
+
 type Cooldown struct {
 	CD   time.Duration
 	Last time.Time
@@ -158,16 +212,21 @@ func (c *Cooldown) Try() bool {
 var playerHitbox = typ.Rect{Min: typ.P{X: -16, Y: -48}, Max: typ.P{X: 16, Y: 16}}
 
 func (p *Player) CalcHitbox() typ.Rect {
+	return calcHitbox(p.pos, p.dir, time.Since(p.lastMove), p.speedXTile, p.speedPxXFrame)
+}
+
+// calcHitbox guesses where the client is drawing something that
+// moved to pos sinceMoved ago, it might still be walking from the previous tile
+func calcHitbox(pos typ.P, dir direction.D, sinceMoved time.Duration, speedXTile time.Duration, speedPxXFrame int32) typ.Rect {
 	tilePxCenter := typ.P{
-		X: (p.pos.X * constants.TileSize) + (constants.TileSize / 2),
-		Y: (p.pos.Y * constants.TileSize) + (constants.TileSize / 2),
+		X: (pos.X * constants.TileSize) + (constants.TileSize / 2),
+		Y: (pos.Y * constants.TileSize) + (constants.TileSize / 2),
 	}
-	sinceMoved := time.Since(p.lastMove)
-	if sinceMoved >= p.speedXTile {
+	if sinceMoved >= speedXTile {
 		return playerHitbox.OnPoint(tilePxCenter)
 	}
-	off := constants.TileSize - int32((sinceMoved/AverageGameFrame))*p.speedPxXFrame
-	switch p.dir {
+	off := constants.TileSize - int32((sinceMoved/AverageGameFrame))*speedPxXFrame
+	switch dir {
 	case direction.Back:
 		tilePxCenter.Y = tilePxCenter.Y + off
 	case direction.Front:
@@ -180,11 +239,21 @@ func (p *Player) CalcHitbox() typ.Rect {
 	return playerHitbox.OnPoint(tilePxCenter)
 }
 
-func (g *Game) CheckSpellTargets(px typ.P) uint16 {
+func (g *Game) hitbox(id uint16) typ.Rect {
+	if n := g.npcs[id]; n != nil {
+		return n.CalcHitbox()
+	}
+	return g.players[id].CalcHitbox()
+}
+
+// CheckSpellTargets finds what is under the click px, as the
+// caster saw it at the game time at.
+func (g *Game) CheckSpellTargets(px typ.P, at uint32) uint16 {
 	tilePos := typ.P{
 		X: int32(px.X) / constants.TileSize,
 		Y: int32(px.Y) / constants.TileSize,
 	}
+	v := g.rewind(tilePos, at)
 
 	offR := g.space.Rect
 	offR.Min.Y--
@@ -207,63 +276,45 @@ func (g *Game) CheckSpellTargets(px typ.P) uint16 {
 	downTilePos := tilePos
 	downTilePos.Y++
 	if downTilePos.In(offR) {
-		downTargetId := g.space.GetSlot(0, downTilePos)
-		if downTargetId != 0 {
-			if px.In(g.players[downTargetId].CalcHitbox()) {
-				return downTargetId
-			}
+		if downTargetId := v.hit(downTilePos, px); downTargetId != 0 {
+			return downTargetId
 		}
 	}
 
 	leftDownTilePos := downTilePos
 	leftDownTilePos.X--
 	if leftDownTilePos.In(g.space.Rect) {
-		leftDownTargetId := g.space.GetSlot(0, leftDownTilePos)
-		if leftDownTargetId != 0 {
-			if px.In(g.players[leftDownTargetId].CalcHitbox()) {
-				return leftDownTargetId
-			}
+		if leftDownTargetId := v.hit(leftDownTilePos, px); leftDownTargetId != 0 {
+			return leftDownTargetId
 		}
 	}
 
 	rightDownTilePos := downTilePos
 	rightDownTilePos.X++
 	if rightDownTilePos.In(g.space.Rect) {
-		rightDownTargetId := g.space.GetSlot(0, rightDownTilePos)
-		if rightDownTargetId != 0 {
-			if px.In(g.players[rightDownTargetId].CalcHitbox()) {
-				return rightDownTargetId
-			}
+		if rightDownTargetId := v.hit(rightDownTilePos, px); rightDownTargetId != 0 {
+			return rightDownTargetId
 		}
 	}
 
 	downDownTilePos := downTilePos
 	downDownTilePos.Y++
 	if downDownTilePos.In(g.space.Rect) {
-		downDownTargetId := g.space.GetSlot(0, downDownTilePos)
-		if downDownTargetId != 0 {
-			if px.In(g.players[downDownTargetId].CalcHitbox()) {
-				return downDownTargetId
-			}
+		if downDownTargetId := v.hit(downDownTilePos, px); downDownTargetId != 0 {
+			return downDownTargetId
 		}
 	}
 
 	if tilePos.In(g.space.Rect) {
-		targetId := g.space.GetSlot(0, tilePos)
-		if targetId != 0 {
-			if px.In(g.players[targetId].CalcHitbox()) {
-				return targetId
-			}
+		if targetId := v.hit(tilePos, px); targetId != 0 {
+			return targetId
 		}
 	}
 	upTilePos := tilePos
 	upTilePos.Y--
 	if upTilePos.In(g.space.Rect) {
-		upTargetId := g.space.GetSlot(0, upTilePos)
-		if upTargetId != 0 {
-			if px.In(g.players[upTargetId].CalcHitbox()) {
-				return upTargetId
-			}
+		if upTargetId := v.hit(upTilePos, px); upTargetId != 0 {
+			return upTargetId
 		}
 	}
 
@@ -271,22 +322,16 @@ func (g *Game) CheckSpellTargets(px typ.P) uint16 {
 	leftTilePos.X--
 	if leftTilePos.In(g.space.Rect) {
 
-		leftTargetId := g.space.GetSlot(0, leftTilePos)
-		if leftTargetId != 0 {
-			if px.In(g.players[leftTargetId].CalcHitbox()) {
-				return leftTargetId
-			}
+		if leftTargetId := v.hit(leftTilePos, px); leftTargetId != 0 {
+			return leftTargetId
 		}
 	}
 
 	rightTilePos := tilePos
 	rightTilePos.X++
 	if rightTilePos.In(g.space.Rect) {
-		rightTargetId := g.space.GetSlot(0, rightTilePos)
-		if rightTargetId != 0 {
-			if px.In(g.players[rightTargetId].CalcHitbox()) {
-				return rightTargetId
-			}
+		if rightTargetId := v.hit(rightTilePos, px); rightTargetId != 0 {
+			return rightTargetId
 		}
 	}
 	return 0
@@ -0,0 +1,548 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rywk/minigoao/pkg/grid"
+	"github.com/rywk/minigoao/pkg/msgs"
+	"github.com/rywk/minigoao/pkg/typ"
+)
+
+// Admin commands are chat commands only admins and mods can run,
+// the server console runs them too as an admin. Every one of them
+// goes to the audit log, with who ran it and how it went.
+
+// admin is who runs an admin command, a player or the console
+type admin struct {
+	p   *Player   // nil for the console
+	out io.Writer // where the console gets the replies
+}
+
+func (a *admin) name() string {
+	if a.p == nil {
+		return "console"
+	}
+	return a.p.nick
+}
+
+func (a *admin) role() msgs.Role {
+	if a.p == nil {
+		return msgs.RoleAdmin
+	}
+	return a.p.role
+}
+
+// rank is the role order, mods are under admins even if RoleMod is the bigger number
+func rank(r msgs.Role) int {
+	switch r {
+	case msgs.RoleAdmin:
+		return 2
+	case msgs.RoleMod:
+		return 1
+	}
+	return 0
+}
+
+// over is an error unless a is above the role of nick, online or in the accounts.
+// The console is above everyone, its how a bad admin gets taken out.
+func (g *Game) over(a *admin, nick string) error {
+	role := msgs.RolePlayer
+	if acc, ok := g.accounts.Get(nick); ok {
+		role, _ = msgs.RoleFromString(acc.Role)
+	}
+	for _, id := range g.playersIndex {
+		if t := g.players[id]; t != nil && strings.EqualFold(t.nick, nick) {
+			nick, role = t.nick, t.role
+		}
+	}
+	if a.p != nil && rank(role) >= rank(a.role()) {
+		return fmt.Errorf("%v is %v, you cant", nick, role)
+	}
+	return nil
+}
+
+func (a *admin) reply(format string, args ...interface{}) {
+	if a.p != nil {
+		a.p.system(format, args...)
+		return
+	}
+	fmt.Fprintf(a.out, format+"\n", args...)
+}
+
+// self is the player running the command, the console has none
+func (a *admin) self() (*Player, error) {
+	if a.p == nil {
+		return nil, errors.New("only in game")
+	}
+	return a.p, nil
+}
+
+type adminCommand struct {
+	names []string // the first one is the one in /help
+	args  string
+	help  string
+	mod   bool // mods can run it too, not only admins
+	run   func(g *Game, a *admin, args []string) error
+}
+
+func (c *adminCommand) allowed(r msgs.Role) bool {
+	return r == msgs.RoleAdmin || r == msgs.RoleMod && c.mod
+}
+
+func (c *adminCommand) usage() string {
+	line := "/" + c.names[0]
+	if c.args != "" {
+		line += " " + c.args
+	}
+	return line + " - " + c.help
+}
+
+// adminList is in the order of /help
+var adminList = []*adminCommand{
+	{names: []string{"kick"}, args: "<nick> [reason]", help: "take nick out of the game", mod: true, run: cmdKick},
+	{names: []string{"ban"}, args: "<nick> [time] [reason]", help: "kick nick and dont let it back in, for a time like 30m, 12h or 7d or forever", run: cmdBan},
+	{names: []string{"banaccount"}, args: "<nick> [time] [reason]", help: "like ban but only for the account of nick, the one with the password", run: cmdBanAccount},
+	{names: []string{"banip"}, args: "<nick|ip|cidr> [time] [reason]", help: "ban the ip of nick, or an ip or a range of them", run: cmdBanIP},
+	{names: []string{"unban"}, args: "<nick|ip|cidr>", help: "lift every ban on it", run: cmdUnban},
+	{names: []string{"bans"}, help: "everyone banned", mod: true, run: cmdBans},
+	{names: []string{"tp"}, args: "<x> <y> | <nick> | <nick> <x> <y>", help: "go to x y or to nick, or send nick to x y", mod: true, run: cmdTp},
+	{names: []string{"summon"}, args: "<nick>", help: "bring nick next to you", mod: true, run: cmdSummon},
+	{names: []string{"heal"}, args: "[nick]", help: "fill the hp and mana of nick, or yours", run: cmdHeal},
+	{names: []string{"res"}, args: "[nick]", help: "bring nick back to life, or you", run: cmdRes},
+	{names: []string{"mute"}, args: "<nick> <minutes> [reason]", help: "nick cant chat for a while", mod: true, run: cmdMute},
+	{names: []string{"unmute"}, args: "<nick>", help: "nick can chat again", mod: true, run: cmdUnmute},
+	{names: []string{"announce"}, args: "<msg>", help: "everyone online gets it from the server", mod: true, run: cmdAnnounce},
+	{names: []string{"invis"}, args: "[nick]", help: "nobody sees you, or nick, until you run it again", run: cmdInvis},
+}
+
+// adminCommands is adminList by every name
+var adminCommands = map[string]*adminCommand{}
+
+func init() {
+	for _, c := range adminList {
+		for _, n := range c.names {
+			adminCommands[n] = c
+		}
+	}
+}
+
+// errUsage makes the command reply how to use it
+var errUsage = errors.New("usage")
+
+// runAdmin runs the admin command name as a, false if there is
+// none with that name for its role, so it looks like any unknown command.
+func (g *Game) runAdmin(a *admin, name, args string) bool {
+	cmd := adminCommands[strings.ToLower(name)]
+	if cmd == nil || !cmd.allowed(a.role()) {
+		return false
+	}
+	result := "ok"
+	if err := cmd.run(g, a, strings.Fields(args)); errors.Is(err, errUsage) {
+		a.reply("%v", cmd.usage())
+		result = "usage"
+	} else if err != nil {
+		a.reply("%v", err)
+		result = err.Error()
+	}
+	g.auditf("%v (%v) /%v %v: %v", a.name(), a.role(), name, args, result)
+	return true
+}
+
+// adminHelp is the admin commands role can run, for /help
+func adminHelp(role msgs.Role) []string {
+	lines := []string{}
+	for _, c := range adminList {
+		if c.allowed(role) {
+			lines = append(lines, c.usage())
+		}
+	}
+	return lines
+}
+
+func openAudit(path string) (*log.Logger, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return log.New(f, "", log.LstdFlags), nil
+}
+
+// closeAudit closes the file of an audit log from openAudit
+func closeAudit(audit *log.Logger) {
+	if audit == nil {
+		return
+	}
+	if c, ok := audit.Writer().(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			log.Printf("closing the audit log: %v\n", err)
+		}
+	}
+}
+
+func (g *Game) auditf(format string, a ...interface{}) {
+	log.Printf("ADMIN "+format+"\n", a...)
+	if g.audit != nil {
+		g.audit.Printf(format, a...)
+	}
+}
+
+// adminTarget is the online player in the first arg
+func (g *Game) adminTarget(args []string) (*Player, error) {
+	if len(args) == 0 {
+		return nil, errUsage
+	}
+	t := g.playerByNick(args[0])
+	if t == nil {
+		return nil, fmt.Errorf("%v is not online", args[0])
+	}
+	return t, nil
+}
+
+// selfOrTarget is the player in the first arg, or a if there is none
+func (g *Game) selfOrTarget(a *admin, args []string) (*Player, error) {
+	if len(args) == 0 {
+		return a.self()
+	}
+	return g.adminTarget(args)
+}
+
+func (g *Game) parsePos(x, y string) (typ.P, error) {
+	px, errx := strconv.Atoi(x)
+	py, erry := strconv.Atoi(y)
+	if errx != nil || erry != nil {
+		return typ.P{}, errUsage
+	}
+	p := typ.P{X: int32(px), Y: int32(py)}
+	if p.Out(g.space.Rect) {
+		return typ.P{}, fmt.Errorf("%v is out of the map", p)
+	}
+	return p, nil
+}
+
+func reason(args []string) string {
+	if len(args) == 0 {
+		return "no reason"
+	}
+	return strings.Join(args, " ")
+}
+
+// kick takes p out of the game now, without a session to resume.
+// It stays in players as kicked until its reader is done, see handle.
+func (g *Game) kick(p *Player, denied *msgs.EventLoginDenied) {
+	// so the client goes back to the login instead of resuming
+	p.Send(msgs.ELoginDenied, denied)
+	p.out.Finish()
+	if p.dropped.IsZero() {
+		g.unindexPlayer(p.id)
+		p.kicked = true
+	} else {
+		// its reader is already done
+		g.RemovePlayer(p.id)
+	}
+	log.Printf("KICK: %v  [%v] [%v] %v\n", p.m.IP(), p.nick, p.id, denied.Reason)
+	if p.spectator {
+		p.LogoutSpectator()
+		return
+	}
+	g.online--
+	p.Logout()
+}
+
+func cmdKick(g *Game, a *admin, args []string) error {
+	t, err := g.adminTarget(args)
+	if err != nil {
+		return err
+	}
+	if err := g.over(a, t.nick); err != nil {
+		return err
+	}
+	g.kick(t, &msgs.EventLoginDenied{Reason: "kicked: " + reason(args[1:])})
+	a.reply("kicked %v", t.nick)
+	return nil
+}
+
+// newBan is a ban on value by a, args are the optional time and the reason
+func newBan(a *admin, kind, value string, args []string) *Ban {
+	ban := &Ban{Kind: kind, Value: value, By: a.name(), At: time.Now()}
+	if len(args) > 0 {
+		if d, ok := parseBanTime(args[0]); ok {
+			until := ban.At.Add(d)
+			ban.Until = &until
+			args = args[1:]
+		}
+	}
+	ban.Reason = reason(args)
+	return ban
+}
+
+// ban saves the ban and kicks everyone connected it is on
+func (g *Game) ban(a *admin, ban *Ban) error {
+	if err := g.bans.add(ban); err != nil {
+		return err
+	}
+	for _, id := range slices.Clone(g.playersIndex) {
+		p := g.players[id]
+		if p == nil || !p.dropped.IsZero() {
+			continue
+		}
+		_, account := g.accounts.Get(p.nick)
+		if ban.matches(p.nick, account, remoteIP(p.m.IP())) {
+			g.kick(p, ban.denied())
+		}
+	}
+	a.reply("banned %v", ban)
+	return nil
+}
+
+func cmdBan(g *Game, a *admin, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	if err := g.over(a, args[0]); err != nil {
+		return err
+	}
+	return g.ban(a, newBan(a, BanNick, args[0], args[1:]))
+}
+
+func cmdBanAccount(g *Game, a *admin, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	if acc, ok := g.accounts.Get(args[0]); ok {
+		if err := g.over(a, acc.Nick); err != nil {
+			return err
+		}
+		return g.ban(a, newBan(a, BanAccount, acc.Nick, args[1:]))
+	}
+	return fmt.Errorf("%v has no account, /ban the nick", args[0])
+}
+
+func cmdBanIP(g *Game, a *admin, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	ip := args[0]
+	if t := g.consolePlayer(ip); t != nil {
+		if err := g.over(a, t.nick); err != nil {
+			return err
+		}
+		ip = remoteIP(t.m.IP()).String()
+	} else if _, err := parseIP(ip); err != nil {
+		return fmt.Errorf("%v is not online and not an ip", ip)
+	}
+	return g.ban(a, newBan(a, BanIP, ip, args[1:]))
+}
+
+func cmdUnban(g *Game, a *admin, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	removed, err := g.bans.remove(args[0])
+	if err != nil {
+		return err
+	}
+	if len(removed) == 0 {
+		return fmt.Errorf("%v is not banned", args[0])
+	}
+	for _, ban := range removed {
+		a.reply("lifted %v", ban)
+	}
+	return nil
+}
+
+func cmdBans(g *Game, a *admin, args []string) error {
+	list := g.bans.all()
+	if len(list) == 0 {
+		a.reply("nobody is banned")
+	}
+	for _, ban := range list {
+		a.reply("%v", ban)
+	}
+	return nil
+}
+
+func cmdTp(g *Game, a *admin, args []string) error {
+	switch len(args) {
+	case 1:
+		me, err := a.self()
+		if err != nil {
+			return err
+		}
+		t, err := g.adminTarget(args)
+		if err != nil {
+			return err
+		}
+		me.Teleport(t.pos)
+	case 2:
+		me, err := a.self()
+		if err != nil {
+			return err
+		}
+		to, err := g.parsePos(args[0], args[1])
+		if err != nil {
+			return err
+		}
+		me.Teleport(to)
+	case 3:
+		t, err := g.adminTarget(args)
+		if err != nil {
+			return err
+		}
+		to, err := g.parsePos(args[1], args[2])
+		if err != nil {
+			return err
+		}
+		t.Teleport(to)
+		a.reply("sent %v to %v", t.nick, t.pos)
+	default:
+		return errUsage
+	}
+	return nil
+}
+
+func cmdSummon(g *Game, a *admin, args []string) error {
+	me, err := a.self()
+	if err != nil {
+		return err
+	}
+	t, err := g.adminTarget(args)
+	if err != nil {
+		return err
+	}
+	t.Teleport(me.pos)
+	t.system("%v summoned you", me.nick)
+	return nil
+}
+
+// Heal and res teleport the player to where it is, so its client
+// and the ones around get it again like new.
+
+func cmdHeal(g *Game, a *admin, args []string) error {
+	t, err := g.selfOrTarget(a, args)
+	if err != nil {
+		return err
+	}
+	if t.dead {
+		return fmt.Errorf("%v is dead, /res first", t.nick)
+	}
+	t.hp, t.mp = t.maxHp, t.maxMp
+	t.paralized = false
+	t.Teleport(t.pos)
+	a.reply("healed %v", t.nick)
+	return nil
+}
+
+func cmdRes(g *Game, a *admin, args []string) error {
+	t, err := g.selfOrTarget(a, args)
+	if err != nil {
+		return err
+	}
+	if !t.dead {
+		return fmt.Errorf("%v is alive", t.nick)
+	}
+	t.dead = false
+	t.hp = t.maxHp
+	t.Teleport(t.pos)
+	a.reply("revived %v", t.nick)
+	return nil
+}
+
+func cmdMute(g *Game, a *admin, args []string) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+	minutes, err := strconv.Atoi(args[1])
+	if err != nil || minutes < 1 {
+		return errUsage
+	}
+	if err := g.over(a, args[0]); err != nil {
+		return err
+	}
+	g.mute(args[0], time.Duration(minutes)*time.Minute, reason(args[2:]))
+	a.reply("muted %v for %v minutes", args[0], minutes)
+	return nil
+}
+
+func cmdUnmute(g *Game, a *admin, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	if !g.unmute(args[0]) {
+		return fmt.Errorf("%v is not muted", args[0])
+	}
+	a.reply("unmuted %v", args[0])
+	return nil
+}
+
+func cmdAnnounce(g *Game, a *admin, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	g.announce(strings.Join(args, " "))
+	return nil
+}
+
+func cmdInvis(g *Game, a *admin, args []string) error {
+	t, err := g.selfOrTarget(a, args)
+	if err != nil {
+		return err
+	}
+	t.invisible = !t.invisible
+	if t.invisible {
+		g.space.Notify(t.pos, msgs.EPlayerDespawned, t.id, t.id)
+		a.reply("%v is invisible", t.nick)
+	} else {
+		g.space.Notify(t.pos, msgs.EPlayerSpawned, t.info(), t.id)
+		a.reply("%v is visible", t.nick)
+	}
+	return nil
+}
+
+// idFor is the id of p that o can know, 0 if p is invisible to it
+func (p *Player) idFor(o *Player) uint16 {
+	if p.invisible && p != o {
+		return 0
+	}
+	return p.id
+}
+
+// sees is false for the invisible players if p isnt staff
+func (p *Player) sees(o *Player) bool {
+	return !o.invisible || p == o || p.role != msgs.RolePlayer
+}
+
+// hidden is true for events that would show an invisible player to the
+// others, it still takes its tile so they bump into it.
+func (g *Game) hidden(ev grid.Event) bool {
+	var ids []uint16
+	switch ev.E {
+	case msgs.EPlayerMoved:
+		ids = []uint16{ev.Data.(*msgs.EventPlayerMoved).ID}
+	case msgs.EPlayerSpawned, msgs.EPlayerEnterViewport:
+		ids = []uint16{ev.Data.(*msgs.EventNewPlayer).ID}
+	case msgs.EPlayerMelee:
+		m := ev.Data.(*msgs.EventPlayerMelee)
+		ids = []uint16{m.From, m.ID}
+	case msgs.EPlayerSpell:
+		ids = []uint16{ev.Data.(*msgs.EventPlayerSpell).ID}
+	case msgs.EBroadcastChat:
+		ids = []uint16{ev.Data.(*msgs.EventBroadcastChat).ID}
+	}
+	for _, id := range ids {
+		if p := g.player(id); p != nil && p.invisible {
+			return true
+		}
+	}
+	return false
+}
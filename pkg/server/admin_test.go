@@ -0,0 +1,103 @@
+package server
+
+import (
+	"io"
+	"testing"
+
+	"github.com/rywk/minigoao/pkg/constants/direction"
+	"github.com/rywk/minigoao/pkg/msgs"
+	"github.com/rywk/minigoao/pkg/typ"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKickThenEvents(t *testing.T) {
+	g := newTestGame(t)
+	p := newTestPlayer(t, g, "pepe")
+	o := newTestPlayer(t, g, "other")
+	g.kick(p, &msgs.EventLoginDenied{Reason: "testing"})
+	require.Nil(t, g.player(p.id))
+	require.Nil(t, g.playerByNick("pepe"))
+
+	// what its reader can still send before it sees the conn closed
+	events := []IncomingMsg{
+		{ID: p.id, Event: msgs.EMove, Data: &msgs.EventMove{Dir: direction.Left}},
+		{ID: p.id, Event: msgs.EPing},
+		{ID: p.id, Event: msgs.EMelee, Data: direction.Left},
+		{ID: p.id, Event: msgs.EUseItem, Data: msgs.ItemManaPotion},
+		{ID: p.id, Event: msgs.ESendChat, Data: &msgs.EventSendChat{Msg: "hola"}},
+		{ID: p.id, Event: msgs.ESnapshotAck, Data: uint32(1)},
+	}
+	for _, ev := range events {
+		require.NotPanics(t, func() { g.handle(ev) }, ev.Event.String())
+	}
+	require.Equal(t, p, g.players[p.id])
+
+	// a logout of some other conn doesnt take it
+	g.handle(IncomingMsg{ID: p.id, Event: msgs.EPlayerLogout, Data: o.m})
+	require.Equal(t, p, g.players[p.id])
+	g.handle(IncomingMsg{ID: p.id, Event: msgs.EPlayerLogout, Data: p.m})
+	require.Nil(t, g.players[p.id])
+	require.NotPanics(t, func() {
+		g.handle(IncomingMsg{ID: p.id, Event: msgs.EMove, Data: &msgs.EventMove{Dir: direction.Left}})
+	})
+	require.Equal(t, 1, g.online)
+}
+
+func TestInvisible(t *testing.T) {
+	g := newTestGame(t)
+	a := newTestPlayer(t, g, "admin")
+	a.role = msgs.RoleAdmin
+	p := newTestPlayer(t, g, "player")
+	m := newTestPlayer(t, g, "mod")
+	m.role = msgs.RoleMod
+	// right in front of p
+	g.space.Set(0, a.pos, 0)
+	a.pos = typ.P{X: p.pos.X, Y: p.pos.Y + 1}
+	g.space.Set(0, a.pos, a.id)
+	require.Contains(t, g.visible(p), a.id)
+
+	require.NoError(t, cmdInvis(g, &admin{p: a}, nil))
+	require.NotContains(t, g.visible(p), a.id)
+	require.Nil(t, g.info(a.id))
+	who, _ := g.target(p, "admin")
+	require.Nil(t, who)
+	who, _ = g.target(m, "admin")
+	require.Equal(t, a, who)
+	require.Zero(t, a.idFor(p))
+	require.Equal(t, a.id, a.idFor(a))
+
+	// it cant be hit by what cant see it
+	hp := a.hp
+	g.playerMelee(p, direction.Front)
+	require.Equal(t, hp, a.hp)
+}
+
+func TestRoleHierarchy(t *testing.T) {
+	g := newTestGame(t)
+	g.accounts = Accounts{"boss": {Nick: "Boss", Role: "admin"}}
+	a := newTestPlayer(t, g, "admin")
+	a.role = msgs.RoleAdmin
+	m := newTestPlayer(t, g, "mod")
+	m.role = msgs.RoleMod
+	m2 := newTestPlayer(t, g, "mod2")
+	m2.role = msgs.RoleMod
+	p := newTestPlayer(t, g, "player")
+	mod := &admin{p: m}
+
+	require.Error(t, cmdKick(g, mod, []string{"admin"}))
+	require.Error(t, cmdKick(g, mod, []string{"mod2"}))
+	require.Error(t, cmdMute(g, mod, []string{"ADMIN", "5"}))
+	require.Error(t, cmdBan(g, mod, []string{"admin"}))
+	// offline, by the account
+	require.Error(t, cmdBan(g, mod, []string{"boss"}))
+	require.NotNil(t, g.player(a.id))
+	_, muted := g.muted("admin")
+	require.False(t, muted)
+
+	require.NoError(t, cmdMute(g, mod, []string{"player", "5"}))
+	require.NoError(t, cmdKick(g, &admin{p: a}, []string{"mod2"}))
+	require.NoError(t, cmdKick(g, mod, []string{"player"}))
+	require.Nil(t, g.player(p.id))
+	// the console is over admins
+	require.NoError(t, cmdMute(g, &admin{out: io.Discard}, []string{"admin", "5"}))
+}
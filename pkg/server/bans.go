@@ -0,0 +1,257 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rywk/minigoao/pkg/msgs"
+	"github.com/rywk/minigoao/pkg/world"
+)
+
+// What a ban is on
+const (
+	// any nick like it, in any case, with an account or not
+	BanNick = "nick"
+	// only the account with that nick, the one with the password
+	BanAccount = "account"
+	// an ip or a range of them, like 10.0.0.0/8
+	BanIP = "ip"
+)
+
+// Ban keeps someone out of the game, until it runs out or forever.
+type Ban struct {
+	Kind   string
+	Value  string // the nick, or the ip or cidr
+	Reason string
+	By     string
+	At     time.Time
+	Until  *time.Time `json:",omitempty"` // nil is forever
+
+	prefix netip.Prefix // Value of the ip ones
+}
+
+func (b *Ban) expired(now time.Time) bool {
+	return b.Until != nil && now.After(*b.Until)
+}
+
+func (b *Ban) String() string {
+	s := fmt.Sprintf("%v %v by %v", b.Kind, b.Value, b.By)
+	if b.Until != nil {
+		s += fmt.Sprintf(" for %v more", time.Until(*b.Until).Round(time.Second))
+	}
+	return s + ": " + b.Reason
+}
+
+// denied is what the client gets instead of the login
+func (b *Ban) denied() *msgs.EventLoginDenied {
+	ev := &msgs.EventLoginDenied{Reason: "banned: " + b.Reason}
+	if b.Until != nil {
+		ev.Until = b.Until.UnixMilli()
+	}
+	return ev
+}
+
+func (b *Ban) matches(nick string, account bool, ip netip.Addr) bool {
+	switch b.Kind {
+	case BanNick:
+		return nick != "" && strings.EqualFold(b.Value, nick)
+	case BanAccount:
+		return account && strings.EqualFold(b.Value, nick)
+	case BanIP:
+		return ip.IsValid() && b.prefix.Contains(ip)
+	}
+	return false
+}
+
+// check validates the ban and fills what isnt saved
+func (b *Ban) check() error {
+	switch b.Kind {
+	case BanNick, BanAccount:
+		if b.Value == "" {
+			return fmt.Errorf("%v ban without a nick", b.Kind)
+		}
+	case BanIP:
+		p, err := parseIP(b.Value)
+		if err != nil {
+			return fmt.Errorf("ip ban %q: %w", b.Value, err)
+		}
+		b.prefix, b.Value = p, ipValue(p)
+	default:
+		return fmt.Errorf("unknown ban kind %q", b.Kind)
+	}
+	return nil
+}
+
+// parseIP takes an ip or a cidr, a lone ip is a range of one
+func parseIP(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return p.Masked(), nil
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	a = a.Unmap()
+	return netip.PrefixFrom(a, a.BitLen()), nil
+}
+
+// ipValue is how an ip ban is saved, without the /32 of a lone ip
+func ipValue(p netip.Prefix) string {
+	if p.IsSingleIP() {
+		return p.Addr().String()
+	}
+	return p.String()
+}
+
+// remoteIP is the ip of a conn address, ip:port from IP()
+func remoteIP(addr string) netip.Addr {
+	if ap, err := netip.ParseAddrPort(addr); err == nil {
+		return ap.Addr().Unmap()
+	}
+	a, _ := netip.ParseAddr(addr)
+	return a.Unmap()
+}
+
+// parseBanTime is how long a ban lasts, like 30m, 12h or 7d
+func parseBanTime(s string) (time.Duration, bool) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		return time.Duration(n) * 24 * time.Hour, err == nil && n > 0
+	}
+	d, err := time.ParseDuration(s)
+	return d, err == nil && d > 0
+}
+
+// bans are saved to a json file on every change. HandleLogin checks
+// them from its own goroutine so they have their own lock.
+type bans struct {
+	mu   sync.Mutex
+	path string // empty to keep them only while the server runs
+	list []*Ban
+}
+
+func loadBans(path string) (*bans, error) {
+	b := &bans{path: path}
+	if path == "" {
+		return b, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &b.list); err != nil {
+		return nil, err
+	}
+	for _, ban := range b.list {
+		if err := ban.check(); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// save writes the list, the lock has to be held
+func (b *bans) save() error {
+	if b.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(b.list, "", "\t")
+	if err != nil {
+		return err
+	}
+	return world.WriteFile(b.path, data)
+}
+
+// prune drops the bans that ran out, the lock has to be held
+func (b *bans) prune() {
+	now := time.Now()
+	kept := b.list[:0]
+	for _, ban := range b.list {
+		if !ban.expired(now) {
+			kept = append(kept, ban)
+		}
+	}
+	clear(b.list[len(kept):])
+	b.list = kept
+}
+
+// add puts the ban in place of any other on the same thing
+func (b *bans) add(ban *Ban) error {
+	if err := ban.check(); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.prune()
+	for i, o := range b.list {
+		if o.Kind == ban.Kind && strings.EqualFold(o.Value, ban.Value) {
+			b.list = slices.Delete(b.list, i, i+1)
+			break
+		}
+	}
+	b.list = append(b.list, ban)
+	return b.save()
+}
+
+// remove drops every ban on value, a nick or an ip or cidr
+func (b *bans) remove(value string) ([]*Ban, error) {
+	if p, err := parseIP(value); err == nil {
+		value = ipValue(p)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.prune()
+	removed := []*Ban{}
+	kept := b.list[:0]
+	for _, ban := range b.list {
+		if strings.EqualFold(ban.Value, value) {
+			removed = append(removed, ban)
+		} else {
+			kept = append(kept, ban)
+		}
+	}
+	clear(b.list[len(kept):])
+	b.list = kept
+	if len(removed) == 0 {
+		return nil, nil
+	}
+	return removed, b.save()
+}
+
+// find is the first ban on the player, nil if there is none. The ip
+// is its conn address, nick is empty before it says which one it is.
+func (b *bans) find(nick string, account bool, addr string) *Ban {
+	ip := remoteIP(addr)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	for _, ban := range b.list {
+		if !ban.expired(now) && ban.matches(nick, account, ip) {
+			return ban
+		}
+	}
+	return nil
+}
+
+func (b *bans) all() []*Ban {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.prune()
+	return append([]*Ban{}, b.list...)
+}
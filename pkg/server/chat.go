@@ -0,0 +1,395 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rywk/minigoao/pkg/msgs"
+	"github.com/rywk/minigoao/pkg/typ"
+)
+
+// chat is what a player typed, it goes to the players around unless
+// its a command, commands start with a /
+func (g *Game) chat(p *Player, msg string) {
+	if !g.chatAllowed(p, msg) {
+		return
+	}
+	if !strings.HasPrefix(msg, "/") {
+		log.Printf("[%v][%v]: %v", p.id, p.nick, msg)
+		msg, ok := g.speak(p, msg)
+		if !ok {
+			return
+		}
+		g.space.Notify(p.pos, msgs.EBroadcastChat, &msgs.EventBroadcastChat{
+			ID:  p.id,
+			Msg: msg,
+		}, p.id)
+		return
+	}
+	name, args, _ := strings.Cut(msg[1:], " ")
+	cmd := chatCommands[strings.ToLower(name)]
+	if cmd == nil && g.runAdmin(&admin{p: p}, name, strings.TrimSpace(args)) {
+		return
+	}
+	if cmd == nil {
+		p.system("unknown command /%v, /help for the list", name)
+		return
+	}
+	log.Printf("[%v][%v] /%v %v", p.id, p.nick, name, args)
+	cmd.run(g, p, strings.TrimSpace(args))
+}
+
+type chatCommand struct {
+	names []string // the first one is the one in /help
+	args  string
+	help  string
+	run   func(g *Game, p *Player, args string)
+}
+
+// commandList is in the order of /help, it is set in init since /help uses it
+var commandList []*chatCommand
+
+// chatCommands is commandList by every name
+var chatCommands = map[string]*chatCommand{}
+
+func init() {
+	commandList = []*chatCommand{
+		{names: []string{"help", "h"}, help: "this list", run: cmdHelp},
+		{names: []string{"w", "whisper", "msg"}, args: "<nick> <msg>", help: "only nick gets it", run: cmdWhisper},
+		{names: []string{"g", "global"}, args: "<msg>", help: "everyone online gets it", run: cmdGlobal},
+		{names: []string{"p", "party"}, args: "<msg>", help: "your party gets it", run: cmdParty},
+		{names: []string{"invite", "inv"}, args: "<nick>", help: "invite nick to your party, or join the party of nick if you were invited", run: cmdInvite},
+		{names: []string{"leave"}, help: "leave your party", run: cmdLeave},
+		{names: []string{"who"}, help: "who is online", run: cmdWho},
+		{names: []string{"roll"}, args: "[max]", help: "a random number from 1 to max (100), the players around see it", run: cmdRoll},
+		{names: []string{"duel"}, args: "<nick>", help: "challenge nick to a duel, or accept the one nick sent you", run: cmdDuel},
+	}
+	for _, c := range commandList {
+		for _, n := range c.names {
+			chatCommands[n] = c
+		}
+	}
+}
+
+// system sends a message from the server to the player only
+func (p *Player) system(format string, a ...interface{}) {
+	p.Send(msgs.EBroadcastChat, &msgs.EventBroadcastChat{
+		Channel: msgs.ChatSystem,
+		Msg:     fmt.Sprintf(format, a...),
+	})
+}
+
+// combat is system for what went wrong in a fight, it goes to the combat log
+func (p *Player) combat(format string, a ...interface{}) {
+	p.Send(msgs.EBroadcastChat, &msgs.EventBroadcastChat{
+		Channel: msgs.ChatCombat,
+		Msg:     fmt.Sprintf(format, a...),
+	})
+}
+
+// onlinePlayers are the players in the world with a connection, by nick
+func (g *Game) onlinePlayers() []*Player {
+	ps := []*Player{}
+	for _, id := range g.playersIndex {
+		if p := g.players[id]; p != nil && !p.spectator && p.dropped.IsZero() {
+			ps = append(ps, p)
+		}
+	}
+	sort.Slice(ps, func(i, j int) bool { return ps[i].nick < ps[j].nick })
+	return ps
+}
+
+// playerByNick is the online player with that nick, nil if nobody has it
+func (g *Game) playerByNick(nick string) *Player {
+	for _, p := range g.onlinePlayers() {
+		if strings.EqualFold(p.nick, nick) {
+			return p
+		}
+	}
+	return nil
+}
+
+// target is the player named in the first word of args, the rest is what is left.
+// It tells p what went wrong when there is nobody.
+func (g *Game) target(p *Player, args string) (*Player, string) {
+	nick, rest, _ := strings.Cut(args, " ")
+	if nick == "" {
+		p.system("who?")
+		return nil, ""
+	}
+	t := g.playerByNick(nick)
+	if t == nil || !p.sees(t) {
+		p.system("%v is not online", nick)
+		return nil, ""
+	}
+	return t, strings.TrimSpace(rest)
+}
+
+func cmdHelp(g *Game, p *Player, args string) {
+	lines := []string{"commands:"}
+	for _, c := range commandList {
+		line := "/" + c.names[0]
+		if c.args != "" {
+			line += " " + c.args
+		}
+		lines = append(lines, line+" - "+c.help)
+	}
+	if admin := adminHelp(p.role); len(admin) != 0 {
+		lines = append(lines, "admin commands:")
+		lines = append(lines, admin...)
+	}
+	p.system("%v", strings.Join(lines, "\n"))
+}
+
+func cmdWhisper(g *Game, p *Player, args string) {
+	t, msg := g.target(p, args)
+	if t == nil {
+		return
+	}
+	if msg == "" {
+		p.system("/w %v <msg>", t.nick)
+		return
+	}
+	msg, ok := g.speak(p, msg)
+	if !ok {
+		return
+	}
+	t.Send(msgs.EBroadcastChat, &msgs.EventBroadcastChat{
+		ID: p.id, Nick: p.nick, Msg: msg, Channel: msgs.ChatWhisper,
+	})
+	p.Send(msgs.EBroadcastChat, &msgs.EventBroadcastChat{
+		ID: p.id, Nick: p.nick, Msg: msg, Channel: msgs.ChatWhisper, To: t.nick,
+	})
+}
+
+func cmdGlobal(g *Game, p *Player, msg string) {
+	if msg == "" {
+		return
+	}
+	msg, ok := g.speak(p, msg)
+	if !ok {
+		return
+	}
+	ev := &msgs.EventBroadcastChat{ID: p.id, Nick: p.nick, Msg: msg, Channel: msgs.ChatGlobal}
+	for _, id := range g.playersIndex {
+		if o := g.players[id]; o != nil && o.dropped.IsZero() {
+			o.Send(msgs.EBroadcastChat, ev)
+		}
+	}
+}
+
+func cmdWho(g *Game, p *Player, args string) {
+	nicks := []string{}
+	for _, o := range g.onlinePlayers() {
+		if p.sees(o) {
+			nicks = append(nicks, o.nick)
+		}
+	}
+	p.system("%v online: %v", len(nicks), strings.Join(nicks, ", "))
+}
+
+func cmdRoll(g *Game, p *Player, args string) {
+	limit := 100
+	if args != "" {
+		n, err := strconv.Atoi(args)
+		if err != nil || n < 2 {
+			p.system("/roll [max], max is a number over 1")
+			return
+		}
+		limit = n
+	}
+	ev := &msgs.EventBroadcastChat{
+		Channel: msgs.ChatSystem,
+		Msg:     fmt.Sprintf("%v rolled %v (1-%v)", p.nick, rand.Intn(limit)+1, limit),
+	}
+	// the ones around see it, and so do we
+	g.space.Notify(p.pos, msgs.EBroadcastChat, ev, p.id)
+	p.Send(msgs.EBroadcastChat, ev)
+}
+
+// Parties and duels start with a request that the other one accepts
+// by asking the same back before RequestTTL.
+const RequestTTL = 30 * time.Second
+
+type requestKind uint8
+
+const (
+	requestParty requestKind = iota
+	requestDuel
+)
+
+type request struct {
+	kind     requestKind
+	from, to uint16
+}
+
+// ask stores the request of p to t, true if t already asked p the same,
+// then the request is taken.
+func (g *Game) ask(kind requestKind, p, t *Player) bool {
+	back := request{kind: kind, from: t.id, to: p.id}
+	if at, ok := g.requests[back]; ok && time.Since(at) < RequestTTL {
+		delete(g.requests, back)
+		return true
+	}
+	for r, at := range g.requests {
+		if time.Since(at) >= RequestTTL {
+			delete(g.requests, r)
+		}
+	}
+	g.requests[request{kind: kind, from: p.id, to: t.id}] = time.Now()
+	return false
+}
+
+// MaxParty is how many players fit in a party
+const MaxParty = 5
+
+type party struct {
+	members []*Player
+}
+
+func (pt *party) send(ev *msgs.EventBroadcastChat) {
+	for _, m := range pt.members {
+		m.Send(msgs.EBroadcastChat, ev)
+	}
+}
+
+func (pt *party) system(format string, a ...interface{}) {
+	pt.send(&msgs.EventBroadcastChat{Channel: msgs.ChatSystem, Msg: fmt.Sprintf(format, a...)})
+}
+
+func cmdParty(g *Game, p *Player, msg string) {
+	pt := g.parties[p.id]
+	if pt == nil {
+		p.system("you are not in a party, /invite <nick> to make one")
+		return
+	}
+	if msg == "" {
+		nicks := []string{}
+		for _, m := range pt.members {
+			nicks = append(nicks, m.nick)
+		}
+		p.system("party: %v", strings.Join(nicks, ", "))
+		return
+	}
+	msg, ok := g.speak(p, msg)
+	if !ok {
+		return
+	}
+	pt.send(&msgs.EventBroadcastChat{ID: p.id, Nick: p.nick, Msg: msg, Channel: msgs.ChatParty})
+}
+
+func cmdInvite(g *Game, p *Player, args string) {
+	t, _ := g.target(p, args)
+	if t == nil {
+		return
+	}
+	if t == p {
+		p.system("you are already with yourself")
+		return
+	}
+	if pt := g.parties[p.id]; pt != nil && pt == g.parties[t.id] {
+		p.system("%v is already in your party", t.nick)
+		return
+	}
+	if !g.ask(requestParty, p, t) {
+		t.system("%v invites you to a party, /invite %v to join", p.nick, p.nick)
+		p.system("invited %v to your party", t.nick)
+		return
+	}
+	// t invited us, we go to its party
+	pt := g.parties[t.id]
+	if pt == nil {
+		pt = &party{members: []*Player{t}}
+		g.parties[t.id] = pt
+	}
+	if len(pt.members) >= MaxParty {
+		p.system("the party of %v is full", t.nick)
+		return
+	}
+	g.leaveParty(p)
+	pt.members = append(pt.members, p)
+	g.parties[p.id] = pt
+	pt.system("%v joined the party", p.nick)
+}
+
+func cmdLeave(g *Game, p *Player, args string) {
+	if g.parties[p.id] == nil {
+		p.system("you are not in a party")
+		return
+	}
+	g.leaveParty(p)
+	p.system("you left the party")
+}
+
+// leaveParty takes p out of its party, a party of one is no party
+func (g *Game) leaveParty(p *Player) {
+	pt := g.parties[p.id]
+	if pt == nil {
+		return
+	}
+	delete(g.parties, p.id)
+	for i, m := range pt.members {
+		if m == p {
+			pt.members = append(pt.members[:i], pt.members[i+1:]...)
+			break
+		}
+	}
+	pt.system("%v left the party", p.nick)
+	if len(pt.members) == 1 {
+		delete(g.parties, pt.members[0].id)
+		pt.members[0].system("the party is over")
+	}
+}
+
+func cmdDuel(g *Game, p *Player, args string) {
+	if g.world.Zone(g.cfg.DuelZone) == nil {
+		p.system("duels are unavailable on this server")
+		return
+	}
+	t, _ := g.target(p, args)
+	if t == nil {
+		return
+	}
+	if t == p {
+		p.system("you cant duel yourself")
+		return
+	}
+	if !g.ask(requestDuel, p, t) {
+		t.system("%v challenges you to a duel, /duel %v to accept", p.nick, p.nick)
+		p.system("you challenged %v to a duel", t.nick)
+		return
+	}
+	g.duel(t, p)
+}
+
+// duel takes both to the duel zone, as new, and tells everyone
+func (g *Game) duel(a, b *Player) {
+	z := g.world.Zone(g.cfg.DuelZone)
+	if z == nil {
+		// reloaded without it since the challenge
+		a.system("duels are unavailable on this server")
+		b.system("duels are unavailable on this server")
+		return
+	}
+	midY := (z.Rect.Min.Y + z.Rect.Max.Y) / 2
+	for _, d := range []struct {
+		p *Player
+		x int32
+	}{{a, z.Rect.Min.X + 1}, {b, z.Rect.Max.X - 2}} {
+		d.p.hp, d.p.mp = d.p.maxHp, d.p.maxMp
+		d.p.dead, d.p.paralized = false, false
+		d.p.Teleport(typ.P{X: d.x, Y: midY})
+	}
+	ev := &msgs.EventBroadcastChat{
+		Channel: msgs.ChatSystem,
+		Msg:     fmt.Sprintf("%v and %v are dueling in %v", a.nick, b.nick, z.Name),
+	}
+	for _, o := range g.onlinePlayers() {
+		o.Send(msgs.EBroadcastChat, ev)
+	}
+}
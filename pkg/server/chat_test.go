@@ -0,0 +1,24 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDuelWithoutZone(t *testing.T) {
+	g := newTestGame(t)
+	a := newTestPlayer(t, g, "a")
+	b := newTestPlayer(t, g, "b")
+	g.cfg.DuelZone = ""
+	cmdDuel(g, a, "b")
+	cmdDuel(g, b, "a")
+	require.Empty(t, g.requests)
+
+	g.cfg.DuelZone = "Arena 1v1"
+	require.NotNil(t, g.world.Zone(g.cfg.DuelZone))
+	cmdDuel(g, a, "b")
+	require.Len(t, g.requests, 1)
+	cmdDuel(g, b, "a")
+	require.True(t, a.pos.In(g.world.Zone(g.cfg.DuelZone).Rect))
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+)
+
+type Config struct {
+	// Map file, loaded on start and saved a moment after the edits
+	MapPath string
+	// Accounts file, nicks that need a password and their role
+	AccountsPath string
+	// Zones file, if it exists it replaces the zones saved in the map
+	ZonesPath string
+	// Where to serve the metrics (expvar json at /debug/vars), off if empty.
+	// They list the players and their ips, keep it on a local address
+	// like 127.0.0.1:6061.
+	MetricsAddr string
+	// Also take native clients over udp, on the same port as tcp
+	UDP bool
+	// Where recordings are saved, empty to not record anything
+	RecordDir string
+	// Record everything that happens in the map while the server runs
+	RecordSession bool
+	// Zones recorded as matches, from the first player that walks in
+	// until the last one leaves, like the arenas
+	RecordZones []string
+	// Zone where /duel takes the players, without one there are no duels
+	DuelZone string
+	// Longest chat message in characters, longer ones are dropped
+	ChatMaxLen int
+	// Chat messages a player can send at once, and how many
+	// more it can send every second after that
+	ChatBurst     int
+	ChatPerSecond float64
+	// Saying the same again before this many seconds is dropped
+	ChatRepeatSecs int
+	// Words covered with * in the chat, whole words in any case
+	ChatFilter []string
+	// Messages dropped in a row for going too fast until the player
+	// is muted for ChatFloodMuteSecs, 0 to never mute
+	ChatFloodStrikes  int
+	ChatFloodMuteSecs int
+	// Where the admin commands are logged, empty to only log them with the rest
+	AuditPath string
+	// Bans file, saved on every ban and unban, empty to forget them on restart
+	BansPath string
+	// Run admin commands typed in stdin
+	Console bool
+
+	// where it was loaded from, for the console reload
+	path string
+}
+
+var DefaultConfig = Config{
+	MapPath:      "./map.mpk",
+	AccountsPath: "./accounts.json",
+	ZonesPath:    "./zones.json",
+	UDP:          true,
+	RecordDir:    "./recordings",
+	DuelZone:     "Arena 1v1",
+
+	ChatMaxLen:        200,
+	ChatBurst:         5,
+	ChatPerSecond:     1,
+	ChatRepeatSecs:    10,
+	ChatFloodStrikes:  5,
+	ChatFloodMuteSecs: 60,
+
+	AuditPath: "./admin.log",
+	BansPath:  "./bans.json",
+	Console:   true,
+}
+
+// LoadConfig reads a json config file, fields that are not set
+// keep their default, a missing file is just the default config.
+func LoadConfig(path string) (*Config, error) {
+	cfg := DefaultConfig
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Printf("no config at %v, using defaults\n", path)
+		cfg.path = path
+		return &cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	cfg.path = path
+	return &cfg, nil
+}
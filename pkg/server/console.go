@@ -0,0 +1,328 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rywk/minigoao/pkg/constants/direction"
+	"github.com/rywk/minigoao/pkg/msgs"
+)
+
+// The console is a prompt on the server stdin, it has its own commands
+// to look at the game, reload the config and shut down, and runs the
+// admin commands as an admin. Lines go to the game as EConsole events
+// so they run in the game loop like everything else.
+
+// consoleLine is a line typed in the console on its way to the game,
+// the game sends back what it has to say about it.
+type consoleLine struct {
+	line string
+	done chan string
+}
+
+// ConsolePrompt is printed when the console waits for a line
+const ConsolePrompt = "> "
+
+func (s *Server) console(r io.Reader) {
+	sc := bufio.NewScanner(r)
+	fmt.Fprint(os.Stdout, ConsolePrompt)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line != "" {
+			c := &consoleLine{line: line, done: make(chan string, 1)}
+			s.game.incomingData <- IncomingMsg{Event: msgs.EConsole, Data: c}
+			fmt.Fprint(os.Stdout, <-c.done)
+		}
+		fmt.Fprint(os.Stdout, ConsolePrompt)
+	}
+	if err := sc.Err(); err != nil {
+		log.Printf("console: %v\n", err)
+	}
+}
+
+type consoleCommand struct {
+	names []string
+	args  string
+	help  string
+	run   func(g *Game, out io.Writer, args []string) error
+}
+
+// consoleList is in the order of help, it is set in init since help uses it
+var consoleList []*consoleCommand
+
+var consoleCommands = map[string]*consoleCommand{}
+
+func init() {
+	consoleList = []*consoleCommand{
+		{names: []string{"help", "h", "?"}, help: "this list", run: consoleHelp},
+		{names: []string{"players", "ls"}, help: "everyone connected, and the dropped ones", run: consolePlayers},
+		{names: []string{"inspect", "i"}, args: "<nick|id>", help: "all about a player", run: consoleInspect},
+		{names: []string{"reload"}, help: "read the config file again", run: consoleReload},
+		{names: []string{"shutdown"}, args: "[seconds|cancel]", help: "tell everyone and stop the server after a countdown (10)", run: consoleShutdown},
+	}
+	for _, c := range consoleList {
+		for _, n := range c.names {
+			consoleCommands[n] = c
+		}
+	}
+}
+
+func (g *Game) console(c *consoleLine) {
+	out := &strings.Builder{}
+	name, args, _ := strings.Cut(strings.TrimPrefix(c.line, "/"), " ")
+	args = strings.TrimSpace(args)
+	if cmd := consoleCommands[strings.ToLower(name)]; cmd != nil {
+		if err := cmd.run(g, out, strings.Fields(args)); errors.Is(err, errUsage) {
+			fmt.Fprintf(out, "%v %v - %v\n", cmd.names[0], cmd.args, cmd.help)
+		} else if err != nil {
+			fmt.Fprintln(out, err)
+		}
+	} else if !g.runAdmin(&admin{out: out}, name, args) {
+		fmt.Fprintf(out, "unknown command %v, help for the list\n", name)
+	}
+	c.done <- out.String()
+}
+
+func consoleHelp(g *Game, out io.Writer, args []string) error {
+	for _, c := range consoleList {
+		line := c.names[0]
+		if c.args != "" {
+			line += " " + c.args
+		}
+		fmt.Fprintf(out, "%v - %v\n", line, c.help)
+	}
+	fmt.Fprintln(out, "admin commands, with or without the /:")
+	for _, line := range adminHelp(msgs.RoleAdmin) {
+		fmt.Fprintln(out, line)
+	}
+	return nil
+}
+
+// state is what a player is doing, for the console
+func (p *Player) state() string {
+	switch {
+	case !p.dropped.IsZero():
+		return fmt.Sprintf("dropped %v ago", time.Since(p.dropped).Round(time.Second))
+	case p.spectator:
+		return "spectating"
+	case p.dead:
+		return "dead"
+	}
+	return "playing"
+}
+
+func consolePlayers(g *Game, out io.Writer, args []string) error {
+	ids := slices.Clone(g.playersIndex)
+	slices.Sort(ids)
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNICK\tROLE\tIP\tPOS\tHP\tMP\tSTATE")
+	for _, id := range ids {
+		p := g.players[id]
+		if p == nil {
+			continue
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v,%v\t%v/%v\t%v/%v\t%v\n",
+			p.id, p.nick, p.role, p.m.IP(), p.pos.X, p.pos.Y, p.hp, p.maxHp, p.mp, p.maxMp, p.state())
+	}
+	w.Flush()
+	fmt.Fprintf(out, "%v online, %v npcs\n", g.online, len(g.npcs))
+	return nil
+}
+
+// consolePlayer is the player by id or nick, the dropped ones too
+func (g *Game) consolePlayer(arg string) *Player {
+	if id, err := strconv.Atoi(arg); err == nil && id > 0 && id < 1<<16 {
+		return g.player(uint16(id))
+	}
+	for _, id := range g.playersIndex {
+		if p := g.players[id]; p != nil && strings.EqualFold(p.nick, arg) {
+			return p
+		}
+	}
+	return nil
+}
+
+func consoleInspect(g *Game, out io.Writer, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	p := g.consolePlayer(args[0])
+	if p == nil {
+		return fmt.Errorf("nobody is %v", args[0])
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	field := func(name string, v interface{}) { fmt.Fprintf(w, "%v\t%v\n", name, v) }
+	field("id", p.id)
+	field("nick", p.nick)
+	field("role", p.role)
+	field("ip", p.m.IP())
+	field("state", p.state())
+	field("pos", fmt.Sprintf("%v,%v looking %v", p.pos.X, p.pos.Y, direction.S(p.dir)))
+	if z := g.world.ZoneAt(p.pos); z != nil {
+		field("zone", z.Name)
+	}
+	field("hp", fmt.Sprintf("%v/%v", p.hp, p.maxHp))
+	field("mp", fmt.Sprintf("%v/%v", p.mp, p.maxMp))
+	field("paralized", p.paralized)
+	field("invisible", p.invisible)
+	field("snapshots", p.snap != nil)
+	field("out queue", p.out.Len())
+	if pt := g.parties[p.id]; pt != nil {
+		nicks := []string{}
+		for _, m := range pt.members {
+			nicks = append(nicks, m.nick)
+		}
+		field("party", strings.Join(nicks, ", "))
+	}
+	if m, ok := g.muted(p.nick); ok {
+		field("muted", fmt.Sprintf("%v more, %v", time.Until(m.until).Round(time.Second), m.reason))
+	}
+	return w.Flush()
+}
+
+func consoleReload(g *Game, out io.Writer, args []string) error {
+	cfg, err := LoadConfig(g.cfg.path)
+	if err != nil {
+		return err
+	}
+	// these are only used on start, they keep the value the
+	// server is running with until a restart
+	restart := []string{}
+	if cfg.MapPath != g.cfg.MapPath {
+		restart = append(restart, "MapPath")
+		cfg.MapPath = g.cfg.MapPath
+	}
+	if cfg.AccountsPath != g.cfg.AccountsPath {
+		restart = append(restart, "AccountsPath")
+		cfg.AccountsPath = g.cfg.AccountsPath
+	}
+	if cfg.ZonesPath != g.cfg.ZonesPath {
+		restart = append(restart, "ZonesPath")
+		cfg.ZonesPath = g.cfg.ZonesPath
+	}
+	if cfg.MetricsAddr != g.cfg.MetricsAddr {
+		restart = append(restart, "MetricsAddr")
+		cfg.MetricsAddr = g.cfg.MetricsAddr
+	}
+	if cfg.UDP != g.cfg.UDP {
+		restart = append(restart, "UDP")
+		cfg.UDP = g.cfg.UDP
+	}
+	if cfg.BansPath != g.cfg.BansPath {
+		restart = append(restart, "BansPath")
+		cfg.BansPath = g.cfg.BansPath
+	}
+	if cfg.Console != g.cfg.Console {
+		restart = append(restart, "Console")
+		cfg.Console = g.cfg.Console
+	}
+	if cfg.AuditPath != g.cfg.AuditPath {
+		audit, err := openAudit(cfg.AuditPath)
+		if err != nil {
+			return err
+		}
+		closeAudit(g.audit)
+		g.audit = audit
+	}
+	g.wordFilter = wordFilter(cfg.ChatFilter)
+	for name := range g.recordings {
+		if name != SessionRecording && !slices.Contains(cfg.RecordZones, name) {
+			g.stopRecording(name)
+		}
+	}
+	*g.cfg = *cfg
+	// after the config is in, a new session recording goes to the new RecordDir
+	if cfg.RecordSession {
+		g.startRecording(SessionRecording, g.space.Rect)
+	} else {
+		g.stopRecording(SessionRecording)
+	}
+	fmt.Fprintf(out, "reloaded %v\n", cfg.path)
+	if len(restart) != 0 {
+		fmt.Fprintf(out, "%v only change after a restart\n", strings.Join(restart, ", "))
+	}
+	g.auditf("console reloaded the config")
+	return nil
+}
+
+// ShutdownWarnings are the seconds left when the players are told
+var ShutdownWarnings = []int{300, 120, 60, 30, 10, 5, 3, 2, 1}
+
+// ShutdownLinger is how long the server waits to stop after the last warning
+const ShutdownLinger = 500 * time.Millisecond
+
+func seconds(n int) string {
+	if n == 1 {
+		return "1 second"
+	}
+	return fmt.Sprintf("%v seconds", n)
+}
+
+func consoleShutdown(g *Game, out io.Writer, args []string) error {
+	secs := 10
+	if len(args) == 1 && args[0] == "cancel" {
+		if g.shutdownAt.IsZero() {
+			return errors.New("there is no shutdown to cancel")
+		}
+		g.shutdownAt = time.Time{}
+		g.announce("the server is not shutting down anymore")
+		g.auditf("console cancelled the shutdown")
+		return nil
+	}
+	if len(args) == 1 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			return errUsage
+		}
+		secs = n
+	} else if len(args) > 1 {
+		return errUsage
+	}
+	g.shutdownAt = time.Now().Add(time.Duration(secs) * time.Second)
+	g.shutdownWarned = secs
+	g.announce("the server shuts down in " + seconds(secs))
+	g.auditf("console shuts down in %v", seconds(secs))
+	fmt.Fprintf(out, "shutting down in %v, shutdown cancel to stop it\n", seconds(secs))
+	return nil
+}
+
+// countdown runs every tick, it tells the players how long until the
+// shutdown at the ShutdownWarnings and stops the server when its time.
+func (g *Game) countdown() {
+	if g.shutdownAt.IsZero() {
+		return
+	}
+	left := time.Until(g.shutdownAt)
+	if left <= 0 {
+		g.shutdownAt = time.Time{}
+		g.announce("the server is shutting down, see you soon")
+		// time for the writers to send it
+		time.AfterFunc(ShutdownLinger, g.stop)
+		return
+	}
+	// the first tick under a warning tells it
+	secs := int(left.Seconds()) + 1
+	if secs < g.shutdownWarned && slices.Contains(ShutdownWarnings, secs) {
+		g.shutdownWarned = secs
+		g.announce("the server shuts down in " + seconds(secs))
+	}
+}
+
+// announce is a system message for everyone connected
+func (g *Game) announce(msg string) {
+	ev := &msgs.EventBroadcastChat{Channel: msgs.ChatSystem, Msg: "[announce] " + msg}
+	for _, id := range g.playersIndex {
+		if o := g.players[id]; o != nil && o.dropped.IsZero() {
+			o.Send(msgs.EBroadcastChat, ev)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsoleReload(t *testing.T) {
+	dir := t.TempDir()
+	g := newTestGame(t)
+	g.cfg.path = filepath.Join(dir, "config.json")
+	g.cfg.AuditPath = filepath.Join(dir, "old.log")
+	audit, err := openAudit(g.cfg.AuditPath)
+	require.NoError(t, err)
+	g.audit = audit
+
+	cfg := *g.cfg
+	cfg.MapPath = filepath.Join(dir, "other.map")
+	cfg.AuditPath = filepath.Join(dir, "new.log")
+	cfg.RecordDir = filepath.Join(dir, "recordings")
+	cfg.RecordSession = true
+	data, err := json.Marshal(cfg)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(g.cfg.path, data, 0o644))
+
+	out := &bytes.Buffer{}
+	require.NoError(t, consoleReload(g, out, nil))
+	require.Contains(t, out.String(), "MapPath only change after a restart")
+	// it keeps saying it until the restart
+	require.Equal(t, "", g.cfg.MapPath)
+
+	// the old audit log is closed and the new one is in use
+	_, err = audit.Writer().Write([]byte("x"))
+	require.ErrorIs(t, err, os.ErrClosed)
+	logged, err := os.ReadFile(cfg.AuditPath)
+	require.NoError(t, err)
+	require.Contains(t, string(logged), "console reloaded the config")
+
+	require.NotNil(t, g.recordings[SessionRecording])
+	cfg.RecordSession = false
+	data, err = json.Marshal(cfg)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(g.cfg.path, data, 0o644))
+	require.NoError(t, consoleReload(g, out, nil))
+	require.Nil(t, g.recordings[SessionRecording])
+	closeAudit(g.audit)
+}
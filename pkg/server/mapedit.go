@@ -0,0 +1,147 @@
+package server
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/rywk/minigoao/pkg/conc"
+	"github.com/rywk/minigoao/pkg/msgs"
+	"github.com/rywk/minigoao/pkg/typ"
+	"github.com/rywk/minigoao/pkg/world"
+)
+
+// loadWorld fills the collision layer of the space from the map.
+func (g *Game) loadWorld() {
+	for y := int32(0); y < g.world.H; y++ {
+		for x := int32(0); x < g.world.W; x++ {
+			g.setBlocking(typ.P{X: x, Y: y})
+		}
+	}
+}
+
+func (g *Game) setBlocking(p typ.P) {
+	if !g.world.Blocked[p.Y][p.X] {
+		g.space.SetSlot(1, p, 0)
+		return
+	}
+	// layer 1 just needs to be != 0 to block, we keep the asset there when there is one
+	block := uint16(g.world.Stuff[p.Y][p.X])
+	if block == 0 {
+		block = 1
+	}
+	g.space.SetSlot(1, p, block)
+}
+
+func (g *Game) sendMapChunk(player *Player, cp typ.P) {
+	chunks := g.world.Chunks()
+	if cp.X < 0 || cp.Y < 0 || cp.X >= chunks.X || cp.Y >= chunks.Y {
+		return
+	}
+	player.Send(msgs.EMapChunk, g.world.Chunk(cp))
+}
+
+func (g *Game) editMap(player *Player, ev *msgs.EventMapEdit) {
+	if player.role != msgs.RoleAdmin {
+		log.Printf("[%v][%v] tried to edit the map without being admin\n", player.id, player.nick)
+		return
+	}
+	if err := g.mapSaver.Edit(ev); err != nil {
+		log.Printf("[%v][%v] map edit %#v: %v\n", player.id, player.nick, *ev, err)
+		return
+	}
+	g.setBlocking(ev.Pos)
+	g.paths.Reset()
+	if ev.Op == msgs.MapEditNPC {
+		g.respawnNPCAt(ev.Pos)
+	}
+	g.space.Notify(ev.Pos, msgs.EMapTile, g.world.Tile(ev.Pos))
+}
+
+const (
+	// MapSaveDelay is how long the map waits without edits to be saved,
+	// a drag in the editor is saved once when it stops
+	MapSaveDelay = 2 * time.Second
+	// MapSaveMaxDelay is the longest an edit waits while more keep coming
+	MapSaveMaxDelay = 10 * time.Second
+)
+
+// mapSaver encodes and writes the map out of the game loop, a moment
+// after the edits stop. The game loop edits the map through it so it
+// doesnt change while its encoded, reading it needs no lock.
+type mapSaver struct {
+	path  string
+	m     *world.Map
+	mu    sync.Mutex
+	wake  chan struct{}
+	flush chan chan struct{}
+}
+
+func newMapSaver(path string, m *world.Map) *mapSaver {
+	s := &mapSaver{
+		path:  path,
+		m:     m,
+		wake:  make(chan struct{}, 1),
+		flush: make(chan chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Edit changes the map and schedules a save
+func (s *mapSaver) Edit(ev *msgs.EventMapEdit) error {
+	s.mu.Lock()
+	err := s.m.Edit(ev)
+	s.mu.Unlock()
+	if err == nil {
+		conc.TrySend(struct{}{}, s.wake)
+	}
+	return err
+}
+
+// Flush saves the edits that are waiting now, for the shutdown
+func (s *mapSaver) Flush() {
+	done := make(chan struct{})
+	s.flush <- done
+	<-done
+}
+
+func (s *mapSaver) run() {
+	var (
+		save  <-chan time.Time
+		first time.Time // first edit not saved
+	)
+	for {
+		select {
+		case <-s.wake:
+			if first.IsZero() {
+				first = time.Now()
+			}
+			if time.Since(first) < MapSaveMaxDelay {
+				save = time.After(MapSaveDelay)
+			}
+		case <-save:
+			s.save()
+			save, first = nil, time.Time{}
+		case done := <-s.flush:
+			if save != nil {
+				s.save()
+				save, first = nil, time.Time{}
+			}
+			close(done)
+		}
+	}
+}
+
+func (s *mapSaver) save() {
+	s.mu.Lock()
+	data, err := s.m.Encode()
+	s.mu.Unlock()
+	if err != nil {
+		log.Printf("encoding map: %v\n", err)
+		return
+	}
+	if err := world.WriteFile(s.path, data); err != nil {
+		log.Printf("saving map to %v: %v\n", s.path, err)
+	}
+}
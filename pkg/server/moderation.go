@@ -0,0 +1,168 @@
+package server
+
+import (
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rywk/minigoao/pkg/msgs"
+)
+
+// Everything a player types goes through here before anyone reads it,
+// chatAllowed checks the length and how fast it types (commands too),
+// speak is for what other players get: mutes, repeats and the word filter.
+
+// chatLimit is a token bucket per player, it starts full with
+// ChatBurst messages and gets ChatPerSecond back every second.
+type chatLimit struct {
+	tokens float64
+	at     time.Time // last refill
+	// the last thing said, to drop it if it comes again soon
+	last   string
+	lastAt time.Time
+	// messages dropped in a row for typing too fast
+	strikes int
+}
+
+func (l *chatLimit) allow(burst int, perSecond float64, now time.Time) bool {
+	if l.at.IsZero() {
+		l.tokens = float64(burst)
+	} else {
+		l.tokens = min(float64(burst), l.tokens+now.Sub(l.at).Seconds()*perSecond)
+	}
+	l.at = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+type mute struct {
+	until  time.Time
+	reason string
+}
+
+// chatAllowed is false if msg is dropped, p gets told why.
+// Too many in a row and p gets muted for flooding.
+func (g *Game) chatAllowed(p *Player, msg string) bool {
+	if g.cfg.ChatMaxLen > 0 && utf8.RuneCountInString(msg) > g.cfg.ChatMaxLen {
+		p.system("too long, the max is %v characters", g.cfg.ChatMaxLen)
+		return false
+	}
+	if p.chatLimit.allow(g.cfg.ChatBurst, g.cfg.ChatPerSecond, time.Now()) {
+		p.chatLimit.strikes = 0
+		return true
+	}
+	p.chatLimit.strikes++
+	if g.cfg.ChatFloodStrikes > 0 && p.chatLimit.strikes >= g.cfg.ChatFloodStrikes {
+		p.chatLimit.strikes = 0
+		g.mute(p.nick, time.Duration(g.cfg.ChatFloodMuteSecs)*time.Second, "flooding")
+		return false
+	}
+	p.system("slow down")
+	return false
+}
+
+// speak is msg as the others get it, false if p cant say it now
+func (g *Game) speak(p *Player, msg string) (string, bool) {
+	if m, ok := g.muted(p.nick); ok {
+		p.system("you are muted for %v more", time.Until(m.until).Round(time.Second))
+		return "", false
+	}
+	now := time.Now()
+	repeat := time.Duration(g.cfg.ChatRepeatSecs) * time.Second
+	if strings.EqualFold(msg, p.chatLimit.last) && now.Sub(p.chatLimit.lastAt) < repeat {
+		p.system("you just said that")
+		return "", false
+	}
+	p.chatLimit.last, p.chatLimit.lastAt = msg, now
+	return g.filterWords(msg), true
+}
+
+// wordFilter matches any of the words in any case, filterWords
+// checks they are whole words.
+func wordFilter(words []string) *regexp.Regexp {
+	quoted := []string{}
+	for _, w := range words {
+		if w = strings.TrimSpace(w); w != "" {
+			quoted = append(quoted, regexp.QuoteMeta(w))
+		}
+	}
+	if len(quoted) == 0 {
+		return nil
+	}
+	// the alternation takes the first word that matches, the longest go
+	// first so "badword" isnt found as "bad" and then dropped as not whole
+	slices.SortStableFunc(quoted, func(a, b string) int { return len(b) - len(a) })
+	return regexp.MustCompile(`(?i)(` + strings.Join(quoted, "|") + `)`)
+}
+
+// filterWords covers the words of the filter with *. The \b of regexp
+// only knows ascii letters, so a word in the middle of "ámalo" would
+// be whole for it, here the boundaries are any letter or number.
+func (g *Game) filterWords(msg string) string {
+	if g.wordFilter == nil {
+		return msg
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range g.wordFilter.FindAllStringIndex(msg, -1) {
+		before, _ := utf8.DecodeLastRuneInString(msg[:m[0]])
+		after, _ := utf8.DecodeRuneInString(msg[m[1]:])
+		if inWord(before) || inWord(after) {
+			continue
+		}
+		b.WriteString(msg[last:m[0]])
+		b.WriteString(strings.Repeat("*", utf8.RuneCountInString(msg[m[0]:m[1]])))
+		last = m[1]
+	}
+	if last == 0 {
+		return msg
+	}
+	b.WriteString(msg[last:])
+	return b.String()
+}
+
+func inWord(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)
+}
+
+// muted is the mute of nick if it has one that didnt run out
+func (g *Game) muted(nick string) (mute, bool) {
+	key := strings.ToLower(nick)
+	m, ok := g.mutes[key]
+	if ok && time.Now().After(m.until) {
+		delete(g.mutes, key)
+		return mute{}, false
+	}
+	return m, ok
+}
+
+// mute keeps nick from talking for d, it lasts over logouts
+// while the server runs. It tells the player if its online.
+func (g *Game) mute(nick string, d time.Duration, reason string) {
+	m := mute{until: time.Now().Add(d), reason: reason}
+	g.mutes[strings.ToLower(nick)] = m
+	log.Printf("MUTED %v for %v: %v\n", nick, d, reason)
+	if p := g.playerByNick(nick); p != nil {
+		p.Send(msgs.EChatMuted, &msgs.EventChatMuted{Until: m.until.UnixMilli(), Reason: reason})
+	}
+}
+
+func (g *Game) unmute(nick string) bool {
+	key := strings.ToLower(nick)
+	if _, ok := g.mutes[key]; !ok {
+		return false
+	}
+	delete(g.mutes, key)
+	log.Printf("UNMUTED %v\n", nick)
+	if p := g.playerByNick(nick); p != nil {
+		p.Send(msgs.EChatMuted, &msgs.EventChatMuted{})
+	}
+	return true
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterWords(t *testing.T) {
+	g := &Game{wordFilter: wordFilter([]string{"malo", "feo", "ñoño"})}
+	for _, c := range []struct {
+		msg, want string
+	}{
+		{msg: "sos malo", want: "sos ****"},
+		{msg: "MALO!", want: "****!"},
+		{msg: "malo malo", want: "**** ****"},
+		{msg: "feo,malo", want: "***,****"},
+		{msg: "que ñoño", want: "que ****"},
+		{msg: "ámalo", want: "ámalo"},
+		{msg: "maloña", want: "maloña"},
+		{msg: "feo_x x_feo feo2", want: "feo_x x_feo feo2"},
+		{msg: "ñoñoño", want: "ñoñoño"},
+		{msg: "nada", want: "nada"},
+	} {
+		require.Equal(t, c.want, g.filterWords(c.msg), c.msg)
+	}
+	require.Equal(t, "malo", (&Game{}).filterWords("malo"))
+}
+
+func TestFilterWordsPrefixes(t *testing.T) {
+	for _, words := range [][]string{{"bad", "badword"}, {"badword", "bad"}} {
+		g := &Game{wordFilter: wordFilter(words)}
+		require.Equal(t, "*******", g.filterWords("badword"), words)
+		require.Equal(t, "*** *******", g.filterWords("bad badword"), words)
+		require.Equal(t, "badwords", g.filterWords("badwords"), words)
+	}
+}
@@ -0,0 +1,384 @@
+package server
+
+import (
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/rywk/minigoao/pkg/constants"
+	"github.com/rywk/minigoao/pkg/constants/direction"
+	"github.com/rywk/minigoao/pkg/constants/npc"
+	"github.com/rywk/minigoao/pkg/grid"
+	"github.com/rywk/minigoao/pkg/msgs"
+	"github.com/rywk/minigoao/pkg/pathfind"
+	"github.com/rywk/minigoao/pkg/typ"
+)
+
+// NPCs live in the same layer of the grid as the players,
+// their ids start at NPCIDStart so we know which is which,
+// the players get the ids under it, see AddPlayer.
+const NPCIDStart = 1 << 15
+
+func isNPC(id uint16) bool {
+	return id >= NPCIDStart
+}
+
+const (
+	GameTick = time.Millisecond * 100
+
+	// time the body of a dead npc stays before it despawns
+	npcCorpseTime   = time.Second * 5
+	npcParalizeTime = time.Second * 4
+	// npcs stop chasing players that go this far from their spawn
+	npcLeash = 12
+	// and wander around their spawn when they have nothing to do
+	npcWanderRange = 3
+	// tiles the npcs search for a way each step, they only chase
+	// around their spawn so it doesnt need to be big
+	npcPathBudget = 300
+)
+
+type NPCProp struct {
+	Type          npc.Type
+	HP            int32
+	Damage        int32
+	RNGRange      int32
+	AggroRange    int32
+	Respawn       time.Duration
+	SpeedPxXFrame int32
+	AttackCD      time.Duration
+}
+
+var npcProps = [npc.Len]NPCProp{
+	{Type: npc.None},
+	{
+		Type:          npc.Skeleton,
+		HP:            280,
+		Damage:        38,
+		RNGRange:      10,
+		AggroRange:    6,
+		Respawn:       time.Second * 20,
+		SpeedPxXFrame: 2,
+		AttackCD:      time.Second,
+	},
+	{
+		Type:          npc.DarkKnight,
+		HP:            900,
+		Damage:        85,
+		RNGRange:      20,
+		AggroRange:    8,
+		Respawn:       time.Minute,
+		SpeedPxXFrame: 2,
+		AttackCD:      time.Millisecond * 1200,
+	},
+}
+
+type NPC struct {
+	id    uint16
+	prop  *NPCProp
+	spawn typ.P
+	// npcs dont care about the events around them, we only use
+	// the observer to know which players see them when they move
+	obs *grid.Obs
+	pos typ.P
+	dir direction.D
+
+	// in the grid, alive or as a body
+	spawned   bool
+	dead      bool
+	paralized bool
+	hp        int32
+	target    uint16
+
+	speedXTile  time.Duration
+	lastMove    time.Time
+	moves       moveHistory
+	lastAttack  time.Time
+	diedAt      time.Time
+	paralizedAt time.Time
+}
+
+// newNPCID is the next npc id nobody has, false if they are all taken
+func (g *Game) newNPCID() (uint16, bool) {
+	for range 1<<16 - NPCIDStart {
+		id := g.nextNPC
+		g.nextNPC++
+		if g.nextNPC < NPCIDStart {
+			g.nextNPC = NPCIDStart
+		}
+		if _, taken := g.npcs[id]; !taken {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+func (g *Game) addNPC(s msgs.NPCSpawn) {
+	id, ok := g.newNPCID()
+	if !ok {
+		log.Printf("no npc ids left for the %v at %v\n", s.Type, s.Pos)
+		return
+	}
+	n := &NPC{
+		id:         id,
+		prop:       &npcProps[s.Type],
+		spawn:      s.Pos,
+		dir:        direction.Front,
+		speedXTile: time.Duration(constants.TileSize/npcProps[s.Type].SpeedPxXFrame) * AverageGameFrame,
+	}
+	g.npcs[n.id] = n
+	g.spawnNPC(n)
+}
+
+func (g *Game) spawnNPCs() {
+	for _, s := range g.world.NPCs {
+		g.addNPC(s)
+	}
+}
+
+// respawnNPCAt is for when the npc spawn on p was edited
+func (g *Game) respawnNPCAt(p typ.P) {
+	for id, n := range g.npcs {
+		if n.spawn == p {
+			g.despawnNPC(n)
+			delete(g.npcs, id)
+		}
+	}
+	if t := g.world.NPCAt(p); t != npc.None {
+		g.addNPC(msgs.NPCSpawn{Type: t, Pos: p})
+	}
+}
+
+func (g *Game) spawnNPC(n *NPC) {
+	n.pos = checkSpawn(g.space, n.spawn)
+	n.dir = direction.Front
+	n.hp = n.prop.HP
+	n.dead, n.paralized = false, false
+	n.target = 0
+	n.obs = grid.NewBlindObserver(g.space, n.pos, constants.GridViewportX, constants.GridViewportY)
+	g.space.Set(0, n.pos, n.id)
+	n.spawned = true
+	g.space.Notify(n.pos, msgs.EPlayerSpawned, n.info())
+}
+
+func (g *Game) despawnNPC(n *NPC) {
+	if !n.spawned {
+		return
+	}
+	n.spawned = false
+	g.space.Unset(0, n.pos)
+	g.space.Notify(n.pos, msgs.EPlayerDespawned, n.id)
+}
+
+func (n *NPC) info() *msgs.EventNewPlayer {
+	return &msgs.EventNewPlayer{
+		ID:    n.id,
+		Nick:  n.prop.Type.String(),
+		Pos:   n.pos,
+		Dir:   n.dir,
+		Dead:  n.dead,
+		Speed: uint8(n.prop.SpeedPxXFrame),
+		NPC:   n.prop.Type,
+	}
+}
+
+func (n *NPC) TakeDamage(dmg int32) {
+	n.hp = n.hp - dmg
+	if n.hp <= 0 {
+		n.hp = 0
+		n.dead = true
+		n.paralized = false
+		n.target = 0
+		n.diedAt = time.Now()
+	}
+}
+
+// aggro makes the npc go for whoever hit it if it wasnt busy
+func (n *NPC) aggro(p *Player) {
+	if !n.dead && n.target == 0 {
+		n.target = p.id
+	}
+}
+
+func (n *NPC) CalcHitbox() typ.Rect {
+	return calcHitbox(n.pos, n.dir, time.Since(n.lastMove), n.speedXTile, n.prop.SpeedPxXFrame)
+}
+
+func (g *Game) tickNPCs() {
+	now := time.Now()
+	for _, n := range g.npcs {
+		g.updateNPC(n, now)
+	}
+}
+
+func (g *Game) updateNPC(n *NPC, now time.Time) {
+	if !n.spawned {
+		if now.Sub(n.diedAt) >= n.prop.Respawn {
+			g.spawnNPC(n)
+		}
+		return
+	}
+	if n.dead {
+		if now.Sub(n.diedAt) >= npcCorpseTime {
+			g.despawnNPC(n)
+		}
+		return
+	}
+	if n.paralized && now.Sub(n.paralizedAt) >= npcParalizeTime {
+		n.paralized = false
+	}
+	target := g.npcTarget(n)
+	if target != nil && distance(n.pos, target.pos) == 1 {
+		if now.Sub(n.lastAttack) >= n.prop.AttackCD {
+			g.npcAttack(n, target, now)
+		}
+		return
+	}
+	if n.paralized || now.Sub(n.lastMove) < n.speedXTile {
+		return
+	}
+	switch {
+	case target != nil:
+		g.npcWalkTo(n, target.pos, now)
+	case distance(n.pos, n.spawn) > npcWanderRange:
+		g.npcWalkTo(n, n.spawn, now)
+	case rand.Intn(20) == 0:
+		d := direction.List[rand.Intn(len(direction.List))]
+		if distance(pathfind.Step(n.pos, d), n.spawn) <= npcWanderRange {
+			g.npcMove(n, d, now)
+		}
+	}
+}
+
+func (g *Game) canChase(n *NPC, p *Player) bool {
+	return p != nil && !p.dead &&
+		distance(p.pos, n.spawn) <= npcLeash &&
+		g.canAttack(n.pos, p.pos)
+}
+
+// npcTarget keeps the current target while it can,
+// otherwise looks for the closest player in aggro range.
+func (g *Game) npcTarget(n *NPC) *Player {
+	if t := g.player(n.target); g.canChase(n, t) {
+		return t
+	}
+	n.target = 0
+	r := n.prop.AggroRange
+	var closest *Player
+	for y := n.pos.Y - r; y <= n.pos.Y+r; y++ {
+		for x := n.pos.X - r; x <= n.pos.X+r; x++ {
+			p := typ.P{X: x, Y: y}
+			if p.Out(g.space.Rect) {
+				continue
+			}
+			pl := g.player(g.space.GetSlot(0, p))
+			if !g.canChase(n, pl) {
+				continue
+			}
+			if closest == nil || distance(n.pos, p) < distance(n.pos, closest.pos) {
+				closest = pl
+			}
+		}
+	}
+	if closest != nil {
+		n.target = closest.id
+	}
+	return closest
+}
+
+func (g *Game) npcAttack(n *NPC, p *Player, now time.Time) {
+	n.lastAttack = now
+	n.dir = pathfind.Direction(n.pos, p.pos)
+	dmg := n.prop.Damage
+	if n.prop.RNGRange != 0 {
+		dmg = dmg + int32(rand.Intn(int(n.prop.RNGRange)))
+	}
+	p.TakeDamage(dmg)
+	p.Send(msgs.EPlayerMeleeRecieved, &msgs.EventPlayerMeleeRecieved{
+		ID:     n.id,
+		Damage: uint32(dmg),
+		NewHP:  uint32(p.hp),
+		Dir:    n.dir,
+	})
+	g.space.Notify(n.pos, msgs.EPlayerMelee, &msgs.EventPlayerMelee{
+		From:   n.id,
+		ID:     p.id,
+		Hit:    true,
+		Killed: p.dead,
+		Dir:    n.dir,
+	}, p.id)
+}
+
+// npcWalkTo takes the first step of the way to the target,
+// if there is no way it goes straight and hopes for the best.
+func (g *Game) npcWalkTo(n *NPC, to typ.P, now time.Time) {
+	path, _ := g.paths.Find(n.pos, to, pathfind.Options{
+		AvoidOccupied: true,
+		Budget:        npcPathBudget,
+		Blocked:       func(p typ.P) bool { return g.world.ZoneAt(p).Safe() },
+	})
+	if len(path) != 0 && g.npcMove(n, pathfind.Direction(n.pos, path[0]), now) {
+		return
+	}
+	dx, dy := to.X-n.pos.X, to.Y-n.pos.Y
+	h, v := direction.Right, direction.Front
+	if dx < 0 {
+		h, dx = direction.Left, -dx
+	}
+	if dy < 0 {
+		v, dy = direction.Back, -dy
+	}
+	dirs := []direction.D{h, v}
+	if dy > dx {
+		dirs[0], dirs[1] = v, h
+	}
+	for i, d := range dirs {
+		if i == 1 && (dx == 0 || dy == 0) {
+			return
+		}
+		if g.npcMove(n, d, now) {
+			return
+		}
+	}
+}
+
+func (g *Game) npcMove(n *NPC, d direction.D, now time.Time) bool {
+	np := pathfind.Step(n.pos, d)
+	if np.Out(g.space.Rect) ||
+		g.space.GetSlot(1, np) != 0 ||
+		g.world.ZoneAt(np).Safe() ||
+		g.space.Move(0, n.pos, np) != nil {
+		return false
+	}
+	n.dir = d
+	n.lastMove = now
+	n.moves.Add(g.now(), np, d)
+	n.obs.MoveOne(d, func(x, y int32) {
+		if p := g.player(g.space.GetSlot(0, typ.P{X: x, Y: y})); p != nil {
+			p.Send(msgs.EPlayerEnterViewport, n.info())
+		}
+	}, func(x, y int32) {
+		if p := g.player(g.space.GetSlot(0, typ.P{X: x, Y: y})); p != nil {
+			p.Send(msgs.EPlayerLeaveViewport, n.id)
+		}
+	})
+	g.space.Notify(np, msgs.EPlayerMoved, &msgs.EventPlayerMoved{
+		ID:  n.id,
+		Pos: np,
+		Dir: d,
+		T:   g.now(),
+	})
+	n.pos = np
+	return true
+}
+
+func distance(a, b typ.P) int32 {
+	return abs(a.X-b.X) + abs(a.Y-b.Y)
+}
+
+func abs(v int32) int32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNPCIDs(t *testing.T) {
+	g := newTestGame(t)
+	g.nextNPC = 1<<16 - 2
+	g.npcs[1<<16-1] = &NPC{}
+	g.npcs[NPCIDStart] = &NPC{}
+
+	id, ok := g.newNPCID()
+	require.True(t, ok)
+	require.Equal(t, uint16(1<<16-2), id)
+	// it skips the taken ones and wraps back to NPCIDStart, not 0
+	id, ok = g.newNPCID()
+	require.True(t, ok)
+	require.Equal(t, uint16(NPCIDStart+1), id)
+
+	for id := 0; id < 1<<16-NPCIDStart; id++ {
+		g.npcs[uint16(NPCIDStart+id)] = &NPC{}
+	}
+	_, ok = g.newNPCID()
+	require.False(t, ok)
+}
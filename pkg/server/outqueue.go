@@ -0,0 +1,209 @@
+package server
+
+import (
+	"expvar"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rywk/minigoao/pkg/conc"
+	"github.com/rywk/minigoao/pkg/msgs"
+)
+
+const (
+	// OutQueueSoft is the depth where we start coalescing movement
+	OutQueueSoft = 64
+	// OutQueueMax is the depth where a client is too far behind and gets kicked
+	OutQueueMax = 512
+)
+
+var (
+	metricQueued    = expvar.NewInt("out_queued")
+	metricMaxDepth  = expvar.NewInt("out_max_depth")
+	metricCoalesced = expvar.NewInt("out_coalesced")
+	metricDropped   = expvar.NewInt("out_dropped")
+	metricEvicted   = expvar.NewInt("out_evicted")
+	metricWrites    = expvar.NewInt("out_writes")
+	metricWritten   = expvar.NewInt("out_written")
+	maxDepth        atomic.Int64
+)
+
+// outQueues are the queues that have a writer, out_queues
+// lists them so a slow client can be told apart from the rest
+var outQueues = struct {
+	sync.Mutex
+	m map[*outQueue]queueOwner
+}{m: make(map[*outQueue]queueOwner)}
+
+type queueOwner struct {
+	ID   uint16
+	Nick string
+	Addr string
+}
+
+type queueDepth struct {
+	queueOwner
+	Depth    int
+	MaxDepth int
+}
+
+func init() {
+	expvar.Publish("out_queues", expvar.Func(func() any {
+		outQueues.Lock()
+		defer outQueues.Unlock()
+		depths := make([]queueDepth, 0, len(outQueues.m))
+		for q, o := range outQueues.m {
+			q.mu.Lock()
+			depths = append(depths, queueDepth{queueOwner: o, Depth: len(q.msgs), MaxDepth: q.maxDepth})
+			q.mu.Unlock()
+		}
+		slices.SortFunc(depths, func(a, b queueDepth) int { return int(a.ID) - int(b.ID) })
+		return depths
+	}))
+}
+
+// track lists q in out_queues until untrack
+func (q *outQueue) track(o queueOwner) {
+	outQueues.Lock()
+	outQueues.m[q] = o
+	outQueues.Unlock()
+}
+
+func (q *outQueue) untrack() {
+	outQueues.Lock()
+	delete(outQueues.m, q)
+	outQueues.Unlock()
+}
+
+// outQueue is what the game loop writes to for a player,
+// Push never blocks, Flush wakes the writer goroutine and it drains
+// everything queued since the last flush with Pop, in one write.
+type outQueue struct {
+	mu     sync.Mutex
+	msgs   []OutMsg
+	closed bool
+	// closed once the writer takes what is left, see Finish
+	finishing bool
+	wake      chan struct{}
+	evicted   atomic.Bool
+	maxDepth  int
+}
+
+func newOutQueue() *outQueue {
+	return &outQueue{
+		msgs: make([]OutMsg, 0, OutQueueSoft),
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// Push queues m, returns false if the queue is full and m could not be
+// dropped or merged, the player should be evicted then.
+func (q *outQueue) Push(m OutMsg) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return true
+	}
+	if len(q.msgs) >= OutQueueSoft {
+		if q.coalesce(m) {
+			metricCoalesced.Add(1)
+			return true
+		}
+		if droppable(m.Event) {
+			metricDropped.Add(1)
+			return true
+		}
+	}
+	if len(q.msgs) >= OutQueueMax {
+		return false
+	}
+	q.msgs = append(q.msgs, m)
+	metricQueued.Add(1)
+	q.maxDepth = max(q.maxDepth, len(q.msgs))
+	depth := int64(len(q.msgs))
+	if depth > maxDepth.Load() {
+		maxDepth.Store(depth)
+		metricMaxDepth.Set(depth)
+	}
+	return true
+}
+
+func (q *outQueue) Flush() {
+	conc.TrySend(struct{}{}, q.wake)
+}
+
+// coalesce replaces a queued move of the same player with m, only looking
+// through the moves at the tail so nothing else about that player gets reordered.
+func (q *outQueue) coalesce(m OutMsg) bool {
+	if m.Event != msgs.EPlayerMoved {
+		return false
+	}
+	id := m.Data.(*msgs.EventPlayerMoved).ID
+	for i := len(q.msgs) - 1; i >= 0 && q.msgs[i].Event == msgs.EPlayerMoved; i-- {
+		if q.msgs[i].Data.(*msgs.EventPlayerMoved).ID == id {
+			q.msgs[i] = m
+			return true
+		}
+	}
+	return false
+}
+
+// droppable events dont change what the client thinks of the world
+func droppable(e msgs.E) bool {
+	return e == msgs.EPingOk
+}
+
+// Pop waits for messages and takes all of them, ok is false once the queue is closed.
+func (q *outQueue) Pop(buf []OutMsg) ([]OutMsg, bool) {
+	for {
+		q.mu.Lock()
+		if q.closed || q.finishing && len(q.msgs) == 0 {
+			q.closed = true
+			q.mu.Unlock()
+			return buf, false
+		}
+		if len(q.msgs) != 0 {
+			buf = append(buf, q.msgs...)
+			metricQueued.Add(-int64(len(q.msgs)))
+			clear(q.msgs)
+			q.msgs = q.msgs[:0]
+			q.mu.Unlock()
+			return buf, true
+		}
+		q.mu.Unlock()
+		<-q.wake
+	}
+}
+
+func (q *outQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.msgs)
+}
+
+// Finish is Close after the writer sends what is queued, and then it closes
+// the conn, so a player we kick gets to know why.
+func (q *outQueue) Finish() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.finishing = true
+	conc.TrySend(struct{}{}, q.wake)
+}
+
+func (q *outQueue) Finished() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.finishing
+}
+
+func (q *outQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed || q.finishing {
+		return
+	}
+	q.closed = true
+	metricQueued.Add(-int64(len(q.msgs)))
+	q.msgs = nil
+	conc.TrySend(struct{}{}, q.wake)
+}
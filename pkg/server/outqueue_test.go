@@ -0,0 +1,42 @@
+package server
+
+import (
+	"encoding/json"
+	"expvar"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/rywk/minigoao/pkg/msgs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutQueueDepths(t *testing.T) {
+	a, b := newOutQueue(), newOutQueue()
+	a.track(queueOwner{ID: 2, Nick: "a", Addr: "10.0.0.1:1"})
+	b.track(queueOwner{ID: 1, Nick: "b", Addr: "10.0.0.2:1"})
+	defer a.untrack()
+	defer b.untrack()
+	for range 3 {
+		a.Push(OutMsg{Event: msgs.EPing})
+	}
+	b.Push(OutMsg{Event: msgs.EPing})
+	a.Pop(nil)
+
+	require.Equal(t, []queueDepth{
+		{queueOwner: queueOwner{ID: 1, Nick: "b", Addr: "10.0.0.2:1"}, Depth: 1, MaxDepth: 1},
+		{queueOwner: queueOwner{ID: 2, Nick: "a", Addr: "10.0.0.1:1"}, Depth: 0, MaxDepth: 3},
+	}, listedDepths(t))
+
+	b.untrack()
+	require.Len(t, listedDepths(t), 1)
+}
+
+// listedDepths is out_queues without the players other tests left behind
+func listedDepths(t *testing.T) []queueDepth {
+	all := []queueDepth{}
+	require.NoError(t, json.Unmarshal([]byte(expvar.Get("out_queues").String()), &all))
+	return slices.DeleteFunc(all, func(d queueDepth) bool {
+		return !strings.HasPrefix(d.Addr, "10.0.0.")
+	})
+}
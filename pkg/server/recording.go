@@ -0,0 +1,193 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rywk/minigoao/pkg/grid"
+	"github.com/rywk/minigoao/pkg/msgs"
+	"github.com/rywk/minigoao/pkg/typ"
+	"github.com/rywk/minigoao/pkg/world"
+)
+
+// A recording is a capture of everything that happens in a part of the map,
+// the client plays it back like it was a live game (see game.StartReplay).
+// It starts with a login of the recording itself (id 0, the camera) and the
+// chunks of the map it covers, then every event of the tiles it sees, with
+// the players coming in and out of it as viewport events.
+type recording struct {
+	g       *Game
+	name    string
+	obs     *grid.Obs
+	capture *msgs.CaptureWriter
+	// players and npcs the replay knows about
+	known   map[uint16]bool
+	flushed time.Time
+}
+
+// SessionRecording is the name of the recording of the whole map
+const SessionRecording = "session"
+
+// RecordingFlush is how often the recordings are written to disk
+const RecordingFlush = time.Second
+
+func (g *Game) startRecording(name string, rect typ.Rect) {
+	if g.cfg.RecordDir == "" || g.recordings[name] != nil {
+		return
+	}
+	if err := os.MkdirAll(g.cfg.RecordDir, 0o755); err != nil {
+		log.Printf("recording %v: %v\n", name, err)
+		return
+	}
+	file := fmt.Sprintf("%v-%v.rec", strings.ReplaceAll(name, " ", "_"), time.Now().Format("20060102-150405"))
+	path := filepath.Join(g.cfg.RecordDir, file)
+	capture, err := msgs.CreateCapture(path)
+	if err != nil {
+		log.Printf("recording %v: %v\n", name, err)
+		return
+	}
+	r := &recording{
+		g:       g,
+		name:    name,
+		capture: capture,
+		known:   make(map[uint16]bool),
+		flushed: time.Now(),
+	}
+	// the observer is centered so it needs an odd size, it can see a tile more than the rect
+	w, h := rect.Max.X-rect.Min.X, rect.Max.Y-rect.Min.Y
+	w, h = w|1, h|1
+	center := typ.P{X: rect.Min.X + w/2, Y: rect.Min.Y + h/2}
+	login := &msgs.EventPlayerLogin{
+		Nick:  name,
+		MapW:  g.world.W,
+		MapH:  g.world.H,
+		Pos:   center,
+		Zones: g.world.Zones,
+	}
+	r.obs = grid.NewObserverRange(g.space, center, w, h, func(t *grid.Tile) {
+		if vp := r.g.info(t.Layers[0]); vp != nil {
+			r.known[vp.ID] = true
+			login.VisiblePlayers = append(login.VisiblePlayers, *vp)
+		}
+	}, r.notify)
+	r.record(msgs.EPlayerLogin, login)
+	v := r.obs.View
+	for cy := v.Min.Y / world.ChunkTiles; cy <= v.Max.Y/world.ChunkTiles; cy++ {
+		for cx := v.Min.X / world.ChunkTiles; cx <= v.Max.X/world.ChunkTiles; cx++ {
+			r.record(msgs.EMapChunk, g.world.Chunk(typ.P{X: cx, Y: cy}))
+		}
+	}
+	g.recordings[name] = r
+	log.Printf("RECORDING %v to %v\n", name, path)
+}
+
+func (g *Game) stopRecording(name string) {
+	r := g.recordings[name]
+	if r == nil {
+		return
+	}
+	delete(g.recordings, name)
+	r.obs.Nuke()
+	if err := r.capture.Close(); err != nil {
+		log.Printf("recording %v: %v\n", name, err)
+	}
+	log.Printf("RECORDING %v stopped\n", name)
+}
+
+func (g *Game) stopRecordings() {
+	for name := range g.recordings {
+		g.stopRecording(name)
+	}
+}
+
+// recordMatches runs every tick, a zone in RecordZones is recorded from the
+// first player that walks in until the last one leaves, that is a match.
+func (g *Game) recordMatches() {
+	for _, name := range g.cfg.RecordZones {
+		z := g.world.Zone(name)
+		if z == nil {
+			continue
+		}
+		playing := g.playersIn(z.Rect)
+		if playing && g.recordings[name] == nil {
+			g.startRecording(name, z.Rect)
+		} else if !playing {
+			g.stopRecording(name)
+		}
+	}
+	for _, r := range g.recordings {
+		r.tick()
+	}
+}
+
+func (g *Game) playersIn(rect typ.Rect) bool {
+	for x := rect.Min.X; x < rect.Max.X; x++ {
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			if id := g.space.GetSlot(0, typ.P{X: x, Y: y}); id != 0 && !isNPC(id) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *recording) record(e msgs.E, data interface{}) {
+	if err := r.capture.RecordEvent(0, msgs.DirDown, e, data); err != nil {
+		log.Printf("recording %v: %v\n", r.name, err)
+	}
+}
+
+// see tells the replay about someone it doesnt know yet, before its first event
+func (r *recording) see(id uint16) {
+	if r.known[id] {
+		return
+	}
+	if vp := r.g.info(id); vp != nil {
+		r.known[id] = true
+		r.record(msgs.EPlayerEnterViewport, vp)
+	}
+}
+
+func (r *recording) notify(ev grid.Event) {
+	switch ev.E {
+	case msgs.EPlayerMoved:
+		r.see(ev.Data.(*msgs.EventPlayerMoved).ID)
+	case msgs.EPlayerSpawned:
+		r.known[ev.Data.(*msgs.EventPlayerSpawned).ID] = true
+	case msgs.EPlayerDespawned:
+		delete(r.known, ev.Data.(uint16))
+	}
+	r.record(ev.E, ev.Data)
+}
+
+// tick catches who came in and out of the view since the last one,
+// moves out of the view dont notify the recording.
+func (r *recording) tick() {
+	in := make(map[uint16]bool, len(r.known))
+	v := r.obs.View
+	for x := v.Min.X; x <= v.Max.X; x++ {
+		for y := v.Min.Y; y <= v.Max.Y; y++ {
+			if id := r.g.space.GetSlot(0, typ.P{X: x, Y: y}); id != 0 {
+				in[id] = true
+				r.see(id)
+			}
+		}
+	}
+	for id := range r.known {
+		if !in[id] {
+			delete(r.known, id)
+			r.record(msgs.EPlayerLeaveViewport, id)
+		}
+	}
+	if time.Since(r.flushed) < RecordingFlush {
+		return
+	}
+	r.flushed = time.Now()
+	if err := r.capture.Flush(); err != nil {
+		log.Printf("recording %v: %v\n", r.name, err)
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"github.com/rywk/minigoao/pkg/msgs"
+)
+
+// ResumeGrace is how long a player that lost the connection stays in
+// the world waiting for the client to come back with its resume token.
+const ResumeGrace = 30 * time.Second
+
+func newResumeToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// dropPlayer is called when the connection of a player dies,
+// the player stays until it resumes or the grace period is over.
+func (g *Game) dropPlayer(p *Player) {
+	if p.spectator {
+		g.RemovePlayer(p.id)
+		p.LogoutSpectator()
+		return
+	}
+	p.out.Close()
+	p.dropped = time.Now()
+	log.Printf("DROP: %v  [%v] [%v]\n", p.m.IP(), p.nick, p.id)
+}
+
+// resumePlayer moves the connection of np to the player behind its token,
+// false if there is nobody to resume.
+func (g *Game) resumePlayer(np *Player) bool {
+	id, ok := g.sessions[np.token]
+	p := g.player(id)
+	if !ok || p == nil || p.nick != np.nick {
+		return false
+	}
+	// it could still look connected if the old conn didnt fail on our side yet,
+	// closing it makes its reader log out, but that conn wont be the player's anymore
+	old := p.m
+	p.out.Close()
+	p.m, p.out, p.snap = np.m, np.out, np.snap
+	p.dropped = time.Time{}
+	old.Close()
+	p.Send(msgs.EPlayerLogin, p.loginEvent())
+	go p.HandleIncomingMessages(p.m)
+	go p.HandleOutgoingMessages(p.m, p.out)
+	log.Printf("RESUME: %v  [%v] [%v]\n", p.m.IP(), p.nick, p.id)
+	return true
+}
+
+// expireDropped logs out the players that didnt come back in time
+func (g *Game) expireDropped() {
+	var expired []*Player
+	for _, id := range g.playersIndex {
+		p := g.players[id]
+		if !p.dropped.IsZero() && time.Since(p.dropped) > ResumeGrace {
+			expired = append(expired, p)
+		}
+	}
+	for _, p := range expired {
+		g.online--
+		g.RemovePlayer(p.id)
+		p.Logout()
+		log.Printf("LOG OUT: %v  [%v] [%v]\n", p.m.IP(), p.nick, p.id)
+	}
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"time"
+
+	"github.com/rywk/minigoao/pkg/constants/direction"
+	"github.com/rywk/minigoao/pkg/typ"
+)
+
+const (
+	// MaxRewind is the furthest back we look for what a client clicked on (ms),
+	// a client that is further behind than this just misses more.
+	MaxRewind = 300
+	// MoveHistory moves are way more than a MaxRewind worth of walking
+	MoveHistory = 8
+	// rewindRange is how far from the click we look for things that could have been there
+	rewindRange = 3
+)
+
+type move struct {
+	t   uint32 // game clock ms
+	pos typ.P
+	dir direction.D
+}
+
+// moveHistory has the last moves of a player or npc
+type moveHistory struct {
+	moves [MoveHistory]move
+	n     int
+}
+
+func (h *moveHistory) Add(t uint32, pos typ.P, dir direction.D) {
+	h.moves[h.n%MoveHistory] = move{t: t, pos: pos, dir: dir}
+	h.n++
+}
+
+func stepBack(p typ.P, d direction.D) typ.P {
+	switch d {
+	case direction.Front:
+		p.Y--
+	case direction.Back:
+		p.Y++
+	case direction.Left:
+		p.X++
+	case direction.Right:
+		p.X--
+	}
+	return p
+}
+
+// At is the last move at or before t, if all the moves we have are after t
+// its standing where the oldest one started, ok is false if it never moved.
+func (h *moveHistory) At(t uint32) (m move, ok bool) {
+	if h.n == 0 {
+		return move{}, false
+	}
+	oldest := max(h.n-MoveHistory, 0)
+	for i := h.n - 1; i >= oldest; i-- {
+		m = h.moves[i%MoveHistory]
+		if int32(t-m.t) >= 0 {
+			return m, true
+		}
+	}
+	m.pos = stepBack(m.pos, m.dir)
+	m.t = t - MaxRewind*10 // long done walking
+	return m, true
+}
+
+// rewound is the world how a client saw it at some time, just
+// enough of it to tell what a click hit. Two can have been on the
+// same tile then, one walking out and the other in.
+type rewound struct {
+	g     *Game
+	at    uint32
+	slots map[typ.P][]uint16
+}
+
+// rewind builds what was around tile at the time at, bounded by MaxRewind
+func (g *Game) rewind(tile typ.P, at uint32) *rewound {
+	now := g.now()
+	if d := int32(now - at); d < 0 || at == 0 {
+		at = now
+	} else if d > MaxRewind {
+		at = now - MaxRewind
+	}
+	r := &rewound{g: g, at: at, slots: make(map[typ.P][]uint16)}
+	for x := tile.X - rewindRange; x <= tile.X+rewindRange; x++ {
+		for y := tile.Y - rewindRange; y <= tile.Y+rewindRange; y++ {
+			p := typ.P{X: x, Y: y}
+			if !p.In(g.space.Rect) {
+				continue
+			}
+			id := g.space.GetSlot(0, p)
+			if id == 0 {
+				continue
+			}
+			if m, ok := r.move(id); ok {
+				p = m.pos
+			}
+			r.slots[p] = append(r.slots[p], id)
+		}
+	}
+	return r
+}
+
+func (r *rewound) move(id uint16) (move, bool) {
+	if n := r.g.npcs[id]; n != nil {
+		return n.moves.At(r.at)
+	}
+	return r.g.players[id].moves.At(r.at)
+}
+
+// hit is what was at tile p with px in its hitbox, 0 if nothing
+func (r *rewound) hit(p typ.P, px typ.P) uint16 {
+	for _, id := range r.slots[p] {
+		if px.In(r.hitbox(id)) {
+			return id
+		}
+	}
+	return 0
+}
+
+func (r *rewound) hitbox(id uint16) typ.Rect {
+	m, ok := r.move(id)
+	if !ok {
+		return r.g.hitbox(id)
+	}
+	since := time.Duration(int32(r.at-m.t)) * time.Millisecond
+	if n := r.g.npcs[id]; n != nil {
+		return calcHitbox(m.pos, m.dir, since, n.speedXTile, n.prop.SpeedPxXFrame)
+	}
+	p := r.g.players[id]
+	return calcHitbox(m.pos, m.dir, since, p.speedXTile, p.speedPxXFrame)
+}
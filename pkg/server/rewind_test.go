@@ -0,0 +1,82 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rywk/minigoao/pkg/constants"
+	"github.com/rywk/minigoao/pkg/constants/direction"
+	"github.com/rywk/minigoao/pkg/typ"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMoveHistoryAt(t *testing.T) {
+	// 12 moves to the right, one every 100ms, the ring keeps the last 8
+	h := &moveHistory{}
+	for i := int32(1); i <= 12; i++ {
+		h.Add(uint32(i*100), typ.P{X: i, Y: 1}, direction.Right)
+	}
+	for _, c := range []struct {
+		name string
+		at   uint32
+		x    int32
+	}{
+		{name: "after the last", at: 1250, x: 12},
+		{name: "on a move", at: 800, x: 8},
+		{name: "between moves", at: 850, x: 8},
+		{name: "oldest kept", at: 500, x: 5},
+		{name: "before the oldest kept", at: 450, x: 4},
+		{name: "overwritten by the wrap", at: 150, x: 4},
+	} {
+		m, ok := h.At(c.at)
+		require.True(t, ok, c.name)
+		require.Equal(t, typ.P{X: c.x, Y: 1}, m.pos, c.name)
+		require.LessOrEqual(t, int32(m.t-c.at), int32(0), c.name)
+	}
+
+	_, ok := (&moveHistory{}).At(100)
+	require.False(t, ok)
+}
+
+func TestRewindClamp(t *testing.T) {
+	g := newTestGame(t)
+	g.start = time.Now().Add(-10 * time.Second)
+	tile := typ.P{X: 10, Y: 10}
+	for _, c := range []struct {
+		name string
+		at   int32 // from now
+		want int32 // from now
+	}{
+		{name: "now", at: 0, want: 0},
+		{name: "in the past", at: -100, want: -100},
+		{name: "too far back", at: -5000, want: -MaxRewind},
+		{name: "in the future", at: 1000, want: 0},
+	} {
+		now := g.now()
+		r := g.rewind(tile, uint32(int32(now)+c.at))
+		require.InDelta(t, int32(now)+c.want, int32(r.at), 5, c.name)
+	}
+	// 0 is a client that doesnt know the time
+	require.InDelta(t, int32(g.now()), int32(g.rewind(tile, 0).at), 5)
+}
+
+func TestRewindSameTile(t *testing.T) {
+	g := newTestGame(t)
+	a := newTestPlayer(t, g, "a")
+	b := newTestPlayer(t, g, "b")
+	tile := typ.P{X: 20, Y: 20}
+	g.space.Set(0, a.pos, 0)
+	g.space.Set(0, b.pos, 0)
+
+	// a is standing on tile, b just walked out of it to the right
+	a.pos = tile
+	b.pos = typ.P{X: tile.X + 1, Y: tile.Y}
+	g.space.Set(0, a.pos, a.id)
+	g.space.Set(0, b.pos, b.id)
+	now := g.now()
+	b.moves.Add(now, b.pos, direction.Right)
+
+	r := g.rewind(tile, now-50)
+	require.ElementsMatch(t, []uint16{a.id, b.id}, r.slots[tile])
+	require.NotZero(t, r.hit(tile, typ.P{X: tile.X*constants.TileSize + 16, Y: tile.Y*constants.TileSize + 16}))
+}
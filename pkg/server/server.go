@@ -4,29 +4,36 @@ import (
 	"context"
 	_ "embed"
 	"errors"
+	"expvar"
 	"fmt"
-	"image"
+	"io/fs"
 	"log"
+	"math/rand"
 	"net/http"
 	"os"
 	"os/signal"
+	"regexp"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/rywk/minigoao/pkg/constants"
-	"github.com/rywk/minigoao/pkg/constants/assets"
 	"github.com/rywk/minigoao/pkg/constants/direction"
 	"github.com/rywk/minigoao/pkg/grid"
 	"github.com/rywk/minigoao/pkg/msgs"
+	"github.com/rywk/minigoao/pkg/pathfind"
 	"github.com/rywk/minigoao/pkg/server/webpage"
 	"github.com/rywk/minigoao/pkg/typ"
+	"github.com/rywk/minigoao/pkg/world"
 )
 
 type Server struct {
+	cfg       *Config
 	web       *http.Server
 	mms       msgs.MMsgs
 	mws       msgs.MMsgs
+	mus       msgs.MMsgs
 	tcpport   string
 	webport   string
 	connCount atomic.Int32
@@ -34,8 +41,9 @@ type Server struct {
 	game      *Game
 }
 
-func NewServer(tcpport string, webport string) *Server {
+func NewServer(tcpport string, webport string, cfg *Config) *Server {
 	return &Server{
+		cfg:     cfg,
 		tcpport: tcpport,
 		webport: webport,
 		newConn: make(chan msgs.Msgs, 100),
@@ -68,6 +76,29 @@ func (s *Server) AcceptWSConnections() {
 	}
 }
 
+func (s *Server) AcceptUDPConnections() {
+	log.Printf("Accepting UDP connections at %v.\n", s.mus.Address())
+	for {
+		conn, err := s.mus.NewConn()
+		if err != nil {
+			return
+		}
+		log.Printf("accepted udp conn\n")
+		s.connCount.Add(1)
+		s.newConn <- conn
+	}
+}
+
+// serveMetrics exposes the expvar counters (outbound queues and the rest) as json
+func (s *Server) serveMetrics() {
+	log.Printf("Serving metrics at %v/debug/vars.\n", s.cfg.MetricsAddr)
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	if err := http.ListenAndServe(s.cfg.MetricsAddr, mux); err != nil {
+		log.Printf("metrics server: %v\n", err)
+	}
+}
+
 var (
 	//go:embed pk_path.txt
 	PKPath []byte
@@ -88,27 +119,32 @@ func (s *Server) Start(exposed bool) error {
 
 	var web http.Server
 	shutdown := make(chan struct{})
+	var once sync.Once
+	// ctrl-c or the console countdown
+	stop := func() {
+		once.Do(func() {
+			log.Printf("Shutting down web...")
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			err := web.Shutdown(ctx)
+			if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+				log.Printf("Error at server.Shutdown: %v", err)
+			}
+			close(shutdown)
+		})
+	}
 	go func() {
 		sigint := make(chan os.Signal, 1)
 		signal.Notify(sigint, os.Interrupt)
 		<-sigint
-
-		log.Printf("Shutting down web...")
-
-		// Received an interrupt signal, shut down.
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
-		defer cancel()
-		err := web.Shutdown(ctx)
-		if err != nil && !errors.Is(err, context.DeadlineExceeded) {
-			log.Printf("Error at server.Shutdown: %v", err)
-		}
-		close(shutdown)
+		stop()
 
 		<-sigint
 		// Hard exit on the second ctrl-c.
 		os.Exit(0)
 	}()
 
+	webpage.Load()
 	mux := http.NewServeMux()
 	var upgraderFunc http.HandlerFunc
 	s.mws, upgraderFunc = msgs.NewUpgraderMiddleware()
@@ -129,35 +165,129 @@ func (s *Server) Start(exposed bool) error {
 		}
 	}()
 
-	var err error
+	wmap, err := world.Load(s.cfg.MapPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Printf("no map at %v, starting with the default map\n", s.cfg.MapPath)
+		wmap = world.Default()
+	} else if err != nil {
+		return err
+	}
+	if err := loadZones(s.cfg.ZonesPath, wmap); err != nil {
+		return err
+	}
+	accounts, err := LoadAccounts(s.cfg.AccountsPath)
+	if err != nil {
+		return err
+	}
+	audit, err := openAudit(s.cfg.AuditPath)
+	if err != nil {
+		return err
+	}
+	bans, err := loadBans(s.cfg.BansPath)
+	if err != nil {
+		return err
+	}
+
 	s.mms, err = msgs.ListenTCP(address)
 	if err != nil {
 		return err
 	}
+	if s.cfg.UDP {
+		s.mus, err = msgs.ListenUDP(address)
+		if err != nil {
+			return err
+		}
+	}
+	space := grid.NewGrid(wmap.W, wmap.H, 2)
 	s.game = &Game{
+		cfg:          s.cfg,
 		newConn:      s.newConn,
 		players:      []*Player{{id: 0}}, // no 0 id
 		playersIndex: make([]uint16, 0),
-		space:        grid.NewGrid(constants.WorldX, constants.WorldY, 2),
+		world:        wmap,
+		mapSaver:     newMapSaver(s.cfg.MapPath, wmap),
+		accounts:     accounts,
+		space:        space,
+		paths:        pathfind.NewFinder(space, 256),
+		npcs:         make(map[uint16]*NPC),
+		sessions:     make(map[string]uint16),
+		recordings:   make(map[string]*recording),
+		parties:      make(map[uint16]*party),
+		requests:     make(map[request]time.Time),
+		mutes:        make(map[string]mute),
+		wordFilter:   wordFilter(s.cfg.ChatFilter),
+		bans:         bans,
+		audit:        audit,
+		stop:         stop,
+		start:        time.Now(),
+		nextNPC:      NPCIDStart,
 		incomingData: make(chan IncomingMsg, 1000),
 	}
 
 	go s.AcceptTCPConnections()
 	go s.AcceptWSConnections()
+	if s.mus != nil {
+		go s.AcceptUDPConnections()
+	}
+	if s.cfg.MetricsAddr != "" {
+		go s.serveMetrics()
+	}
 
 	go s.game.Run()
+	if s.cfg.Console {
+		go s.console(os.Stdin)
+	}
 
 	<-shutdown
+	// so the recordings get closed
+	done := make(chan struct{})
+	s.game.incomingData <- IncomingMsg{Event: msgs.EServerDisconnect, Data: done}
+	<-done
 
 	return nil
 }
 
 type Game struct {
+	cfg          *Config
 	newConn      chan msgs.Msgs
 	players      []*Player
 	playersIndex []uint16
+	// ids of players that are gone, oldest first
+	freeIDs      []uint16
+	world        *world.Map
+	mapSaver     *mapSaver
+	accounts     Accounts
 	space        *grid.Grid
+	paths        *pathfind.Finder
+	npcs         map[uint16]*NPC
+	nextNPC      uint16
 	incomingData chan IncomingMsg
+	// players with messages queued since the last flush
+	flushing []*Player
+	online   int
+	// resume token to player id
+	sessions map[string]uint16
+	// by zone name, or SessionRecording
+	recordings map[string]*recording
+	// by player id, see chat.go
+	parties  map[uint16]*party
+	requests map[request]time.Time
+	// by lowercase nick, see moderation.go
+	mutes      map[string]mute
+	wordFilter *regexp.Regexp
+	bans       *bans
+	// admin commands, see admin.go
+	audit *log.Logger
+	// stops the server, and when the console countdown does it
+	stop           func()
+	shutdownAt     time.Time
+	shutdownWarned int // seconds left of the last warning
+	start          time.Time
+}
+
+// now is the game clock in ms, what clients get as the time of an event
+func (g *Game) now() uint32 {
+	return uint32(time.Since(g.start).Milliseconds())
 }
 
 type IncomingMsg struct {
@@ -166,13 +296,65 @@ type IncomingMsg struct {
 	Data  interface{}
 }
 
-func (g *Game) AddPlayer(p *Player) {
-	g.players = append(g.players, p)
-	p.id = uint16(len(g.players) - 1)
+// AddPlayer gives p the id that was free the longest, false if there is
+// none, the ids from NPCIDStart up are for the npcs.
+func (g *Game) AddPlayer(p *Player) bool {
+	if len(g.freeIDs) != 0 {
+		p.id, g.freeIDs = g.freeIDs[0], g.freeIDs[1:]
+		g.players[p.id] = p
+	} else if len(g.players) < NPCIDStart {
+		g.players = append(g.players, p)
+		p.id = uint16(len(g.players) - 1)
+	} else {
+		return false
+	}
 	g.playersIndex = append(g.playersIndex, p.id)
+	return true
+}
+
+// nickTaken is true if someone in the game has nick in any case,
+// the dropped ones too since they can still resume.
+func (g *Game) nickTaken(nick string) bool {
+	for _, id := range g.playersIndex {
+		if p := g.players[id]; p != nil && strings.EqualFold(p.nick, nick) {
+			return true
+		}
+	}
+	return false
+}
+
+// player is nil for ids that are not online players
+func (g *Game) player(id uint16) *Player {
+	if id == 0 || int(id) >= len(g.players) {
+		return nil
+	}
+	if p := g.players[id]; p != nil && !p.kicked {
+		return p
+	}
+	return nil
 }
 
 func (g *Game) RemovePlayer(pid uint16) {
+	if g.unindexPlayer(pid) {
+		g.releaseID(pid)
+	}
+}
+
+// releaseID is for when nothing can come with the id of a player anymore,
+// the next one to log in can have it.
+func (g *Game) releaseID(pid uint16) {
+	g.players[pid] = nil
+	for r := range g.requests {
+		if r.from == pid || r.to == pid {
+			delete(g.requests, r)
+		}
+	}
+	g.freeIDs = append(g.freeIDs, pid)
+}
+
+// unindexPlayer takes the player out of playersIndex and its party,
+// false if it wasnt there
+func (g *Game) unindexPlayer(pid uint16) bool {
 	index := -1
 	for i, id := range g.playersIndex {
 		if pid == id {
@@ -181,38 +363,37 @@ func (g *Game) RemovePlayer(pid uint16) {
 		}
 	}
 	if index == -1 {
-		return
+		return false
 	}
-	g.players[pid] = nil
+	g.leaveParty(g.players[pid])
 	g.playersIndex[index] = g.playersIndex[len(g.playersIndex)-1]
 	g.playersIndex = g.playersIndex[:len(g.playersIndex)-1]
+	return true
 }
 
-func getNick(m msgs.Msgs) (string, error) {
+func getRegister(m msgs.Msgs) (*msgs.EventRegister, error) {
 	im, err := m.Read()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	if im.Event != msgs.ERegister {
-		return "", errors.New("bad message")
+		return nil, errors.New("bad message")
 	}
-	reg := &msgs.EventRegister{}
-	msgs.DecodeMsgpack(im.Data, reg)
-	return reg.Nick, nil
+	return msgs.DecodeMsgpack(im.Data, &msgs.EventRegister{}), nil
 }
 
-func GetNick(m msgs.Msgs) (nick string, err error) {
+func GetRegister(m msgs.Msgs) (reg *msgs.EventRegister, err error) {
 	timeout := time.NewTicker(time.Second).C
 	done := make(chan struct{})
 	go func() {
-		nick, err = getNick(m)
+		reg, err = getRegister(m)
 		done <- struct{}{}
 	}()
 	select {
 	case <-timeout:
-		return "", errors.New("nick timeout")
+		return nil, errors.New("nick timeout")
 	case <-done:
-		return nick, err
+		return reg, err
 	}
 }
 
@@ -224,7 +405,7 @@ func (g *Game) HandleLogin() {
 			g:             g,
 			m:             conn,
 			pos:           typ.P{X: constants.WorldX / 2, Y: constants.WorldY / 2},
-			Send:          make(chan OutMsg, 100),
+			out:           newOutQueue(),
 			dir:           direction.Front,
 			speedPxXFrame: 3,
 			speedXTile:    (constants.TileSize / 3) * AverageGameFrame,
@@ -234,15 +415,43 @@ func (g *Game) HandleLogin() {
 			maxMp:         2420,
 		}
 
+		if ban := g.bans.find("", false, conn.IP()); ban != nil {
+			// the client sends its register first, it gets the
+			// answer after it like any other login
+			go func() {
+				GetRegister(conn)
+				g.deny(conn, "", ban.denied())
+			}()
+			continue
+		}
+
 		log.Printf("player created waiting for nick\n")
-		nick, err := GetNick(p.m)
+		reg, err := GetRegister(p.m)
 		if err != nil {
 			log.Printf("Get nick error: %v\n", err)
 			conn.Close()
 			continue
 		}
+		nick := reg.Nick
 		log.Printf("got nick [%v]\n", nick)
+		role, err := g.accounts.Auth(nick, reg.Password)
+		if err != nil {
+			log.Printf("Auth [%v] error: %v\n", nick, err)
+			conn.Close()
+			continue
+		}
+		_, account := g.accounts.Get(nick)
+		if ban := g.bans.find(nick, account, conn.IP()); ban != nil {
+			g.deny(conn, nick, ban.denied())
+			continue
+		}
 		p.nick = nick
+		p.role = role
+		p.spectator = reg.Spectate
+		if reg.Snapshots && !p.spectator {
+			p.snap = &snapshots{}
+		}
+		p.token = reg.Resume
 		g.incomingData <- IncomingMsg{
 			Event: msgs.EPlayerConnect,
 			Data:  p,
@@ -250,104 +459,157 @@ func (g *Game) HandleLogin() {
 	}
 }
 
-func (g *Game) AddObjectsToSpace() {
-	arena1v1 := image.Rect(0, 0, 8, 8)
-	arena2v2 := image.Rect(0, 0, 16, 12)
-
-	arena1v1n1 := arena1v1.Add(image.Point{X: 25, Y: 29})
-	arena2v2n1 := arena2v2.Add(image.Point{X: 40, Y: 29})
-
-	for y := arena1v1n1.Min.Y; y < arena1v1n1.Max.Y; y++ {
-		g.space.Set(1, typ.P{X: int32(arena1v1n1.Min.X), Y: int32(y)}, uint16(assets.Shroom))
-		g.space.Set(1, typ.P{X: int32(arena1v1n1.Max.X), Y: int32(y)}, uint16(assets.Shroom))
-	}
-	for x := arena1v1n1.Min.X; x < arena1v1n1.Max.X; x++ {
-		g.space.Set(1, typ.P{X: int32(x), Y: int32(arena1v1n1.Min.Y)}, uint16(assets.Shroom))
-		g.space.Set(1, typ.P{X: int32(x), Y: int32(arena1v1n1.Max.Y)}, uint16(assets.Shroom))
-	}
-	g.space.Unset(1, typ.P{X: 32, Y: 37})
-
-	for y := arena2v2n1.Min.Y; y < arena2v2n1.Max.Y; y++ {
-		g.space.Set(1, typ.P{X: int32(arena2v2n1.Min.X), Y: int32(y)}, uint16(assets.Shroom))
-		g.space.Set(1, typ.P{X: int32(arena2v2n1.Max.X), Y: int32(y)}, uint16(assets.Shroom))
-	}
-	for x := arena2v2n1.Min.X; x < arena2v2n1.Max.X; x++ {
-		g.space.Set(1, typ.P{X: int32(x), Y: int32(arena2v2n1.Min.Y)}, uint16(assets.Shroom))
-		g.space.Set(1, typ.P{X: int32(x), Y: int32(arena2v2n1.Max.Y)}, uint16(assets.Shroom))
-	}
-	g.space.Unset(1, typ.P{X: 55, Y: 41})
-	g.space.Unset(1, typ.P{X: 54, Y: 41})
-	g.space.Unset(1, typ.P{X: 40, Y: 41})
-	g.space.Unset(1, typ.P{X: 41, Y: 41})
-
-	for y := 0; y < constants.WorldY; y++ {
-		g.space.Set(1, typ.P{X: int32(0), Y: int32(y)}, uint16(assets.Shroom))
-		g.space.Set(1, typ.P{X: int32(constants.WorldX - 1), Y: int32(y)}, uint16(assets.Shroom))
-		for x := 0; x < constants.WorldX; x++ {
-			g.space.Set(1, typ.P{X: int32(x), Y: int32(0)}, uint16(assets.Shroom))
-			g.space.Set(1, typ.P{X: int32(x), Y: int32(constants.WorldY - 1)}, uint16(assets.Shroom))
-			if x%25 == 0 && y%25 == 0 {
-				g.space.Set(1, typ.P{X: int32(x), Y: int32(y)}, uint16(assets.Shroom))
-			}
-		}
+// deny tells conn why it cant play and closes it
+func (g *Game) deny(conn msgs.Msgs, nick string, denied *msgs.EventLoginDenied) {
+	log.Printf("DENIED: %v [%v] %v\n", conn.IP(), nick, denied.Reason)
+	if err := conn.EncodeAndWrite(msgs.ELoginDenied, denied); err != nil {
+		log.Printf("deny %v: %v\n", conn.IP(), err)
 	}
+	conn.Close()
 }
 
 func (g *Game) Run() {
-	g.AddObjectsToSpace()
+	g.loadWorld()
+	g.spawnNPCs()
+	if g.cfg.RecordSession {
+		g.startRecording(SessionRecording, g.space.Rect)
+	}
 	go g.HandleLogin()
+	go g.tick()
 	g.consumeIncomingData()
 
 }
 
+func (g *Game) tick() {
+	for range time.Tick(GameTick) {
+		g.incomingData <- IncomingMsg{Event: msgs.EGameTick}
+	}
+}
+
 func (g *Game) consumeIncomingData() {
 	log.Printf("Game started.\n")
-	online := 0
+	batched := 0
 	for incomingData := range g.incomingData {
-		player := g.players[incomingData.ID]
-		switch incomingData.Event {
-		case msgs.EPlayerConnect:
-			online++
-			player = incomingData.Data.(*Player)
-			g.AddPlayer(player)
-			player.Login()
-			log.Printf("LOG IN: %v  [%v] [%v]\n", player.m.IP(), player.nick, player.id)
-		case msgs.EPing:
-			player.Send <- OutMsg{Event: msgs.EPingOk, Data: uint16(online)}
-		case msgs.EPlayerLogout:
-			online--
-			g.RemovePlayer(player.id)
-			player.Logout()
-			log.Printf("LOG OUT: %v  [%v] [%v]\n", player.m.IP(), player.nick, player.id)
-		case msgs.EMove:
-			g.playerMove(player, incomingData)
-		case msgs.ECastSpell:
-			g.playerCastSpell(player, incomingData)
-		case msgs.EMelee:
-			g.playerMelee(player, incomingData.Data.(direction.D))
-		case msgs.EUseItem:
-			item := incomingData.Data.(msgs.Item)
-			//log.Printf("[%v][%v] USE ITEM %v\n", player.id, player.nick, item)
-			changed := UseItem(item, player)
-			player.Send <- OutMsg{Event: msgs.EUseItemOk, Data: &msgs.EventUseItemOk{
-				Item:   msgs.Item(item),
-				Change: changed,
-			}}
-		case msgs.ESendChat:
-			chat := incomingData.Data.(*msgs.EventSendChat)
-			log.Printf("[%v][%v]: %v", player.id, player.nick, chat.Msg)
-			g.space.Notify(player.pos, msgs.EBroadcastChat, &msgs.EventBroadcastChat{
-				ID:  player.id,
-				Msg: chat.Msg,
-			}, player.id)
+		g.handle(incomingData)
+		batched++
+		if len(g.incomingData) == 0 || batched >= MaxFlushEvents {
+			g.flush()
+			batched = 0
+		}
+	}
+}
+
+func (g *Game) handle(incomingData IncomingMsg) {
+	player := g.players[incomingData.ID]
+	if player != nil && player.kicked {
+		// the reader of a kicked player can still have events on the
+		// way, the player goes away for good when its conn is done
+		if incomingData.Event == msgs.EPlayerLogout && incomingData.Data == player.m {
+			g.releaseID(player.id)
+		}
+		return
+	}
+	if player == nil && incomingData.Event != msgs.EPlayerLogout {
+		return
+	}
+	switch incomingData.Event {
+	case msgs.EPlayerConnect:
+		player = incomingData.Data.(*Player)
+		if player.token != "" && !player.spectator && g.resumePlayer(player) {
+			break
+		}
+		if g.nickTaken(player.nick) {
+			go g.deny(player.m, player.nick, &msgs.EventLoginDenied{Reason: "someone is already playing as " + player.nick})
+			break
+		}
+		if !g.AddPlayer(player) {
+			go g.deny(player.m, player.nick, &msgs.EventLoginDenied{Reason: "the server is full"})
+			break
+		}
+		if player.spectator {
+			player.LoginSpectator()
+			log.Printf("SPECTATOR LOG IN: %v  [%v] [%v]\n", player.m.IP(), player.nick, player.id)
+			break
 		}
+		g.online++
+		player.Login()
+		log.Printf("LOG IN: %v  [%v] [%v]\n", player.m.IP(), player.nick, player.id)
+	case msgs.EGameTick:
+		g.tickNPCs()
+		g.sendSnapshots()
+		g.expireDropped()
+		g.recordMatches()
+		g.countdown()
+	case msgs.EServerDisconnect:
+		g.stopRecordings()
+		g.mapSaver.Flush()
+		close(incomingData.Data.(chan struct{}))
+	case msgs.ESnapshotAck:
+		if player.snap != nil {
+			player.snap.Ack(incomingData.Data.(uint32))
+		}
+	case msgs.EPing:
+		player.Send(msgs.EPingOk, uint16(g.online))
+	case msgs.EPlayerLogout:
+		// the reader of a conn that was already replaced by a resume
+		if player == nil || incomingData.Data != player.m {
+			break
+		}
+		g.dropPlayer(player)
+	case msgs.EMove:
+		g.playerMove(player, incomingData)
+	case msgs.ECastSpell:
+		g.playerCastSpell(player, incomingData)
+	case msgs.EMelee:
+		g.playerMelee(player, incomingData.Data.(direction.D))
+	case msgs.EUseItem:
+		item := incomingData.Data.(msgs.Item)
+		//log.Printf("[%v][%v] USE ITEM %v\n", player.id, player.nick, item)
+		if !g.canUseItem(player) {
+			player.Send(msgs.EUseItemOk, &msgs.EventUseItemOk{Item: msgs.ItemNone})
+			player.combat("cant use items here")
+			break
+		}
+		changed := UseItem(item, player)
+		player.Send(msgs.EUseItemOk, &msgs.EventUseItemOk{
+			Item:   msgs.Item(item),
+			Change: changed,
+		})
+	case msgs.EMapChunkRequest:
+		g.sendMapChunk(player, incomingData.Data.(typ.P))
+	case msgs.EMapEdit:
+		g.editMap(player, incomingData.Data.(*msgs.EventMapEdit))
+	case msgs.ECameraMove:
+		if player.spectator {
+			g.moveCamera(player, incomingData.Data.(typ.P))
+		}
+	case msgs.ESendChat:
+		g.chat(player, incomingData.Data.(*msgs.EventSendChat).Msg)
+	case msgs.EConsole:
+		g.console(incomingData.Data.(*consoleLine))
 	}
 }
 
+// MaxFlushEvents is how many incoming events we process before flushing
+// even if there are still more waiting.
+const MaxFlushEvents = 64
+
+// flush wakes the writers of the players that got something since the last flush,
+// everything they got goes out together.
+func (g *Game) flush() {
+	for i, p := range g.flushing {
+		p.flush = false
+		p.out.Flush()
+		g.flushing[i] = nil
+	}
+	g.flushing = g.flushing[:0]
+}
+
 const AverageGameFrame = time.Duration((time.Millisecond * 16) + (6 * (time.Millisecond / 10)))
 
 func (g *Game) playerMove(player *Player, incomingData IncomingMsg) {
-	player.dir = incomingData.Data.(direction.D)
+	ev := incomingData.Data.(*msgs.EventMove)
+	player.dir = ev.Dir
 	np := player.pos
 	switch player.dir {
 	case direction.Front:
@@ -370,68 +632,86 @@ func (g *Game) playerMove(player *Player, incomingData IncomingMsg) {
 		err = g.space.Move(0, player.pos, np)
 	}
 	if err != nil {
-		player.Send <- OutMsg{Event: msgs.EMoveOk, Data: []byte{msgs.BoolByte(false), player.dir}}
+		player.Send(msgs.EMoveOk, &msgs.EventMoveOk{Seq: ev.Seq, Ok: false, Dir: player.dir, Pos: player.pos})
 		g.space.Notify(player.pos, msgs.EPlayerMoved, &msgs.EventPlayerMoved{
 			ID:  player.id,
 			Pos: player.pos,
 			Dir: player.dir,
+			T:   g.now(),
 		}, player.id)
 		//log.Printf("[%v][%v] %v -X-> %v: %v\n", player.id, player.nick, player.pos, np, err)
 		return
 	}
 	player.lastMove = time.Now()
+	player.moves.Add(g.now(), np, player.dir)
 	//log.Printf("[%v][%v] MOVE %v->%v\n", player.id, player.nick, player.pos, np)
 	player.obs.MoveOne(player.dir, func(x, y int32) {
 		newPlayerInSight := g.space.GetSlot(0, typ.P{X: x, Y: y})
 		if newPlayerInSight == 0 {
 			return
 		}
+		if n := g.npcs[newPlayerInSight]; n != nil {
+			player.Send(msgs.EPlayerEnterViewport, n.info())
+			return
+		}
 		newPlayer := g.players[newPlayerInSight]
-		newPlayer.Send <- OutMsg{Event: msgs.EPlayerEnterViewport, Data: &msgs.EventPlayerEnterViewport{
-			ID:    player.id,
-			Nick:  player.nick,
-			Pos:   player.pos,
-			Dir:   player.dir,
-			Dead:  player.dead,
-			Speed: uint8(player.speedPxXFrame),
-		}}
-		player.Send <- OutMsg{Event: msgs.EPlayerEnterViewport, Data: &msgs.EventPlayerEnterViewport{
-			ID:    uint16(newPlayer.id),
-			Nick:  newPlayer.nick,
-			Pos:   newPlayer.pos,
-			Dir:   newPlayer.dir,
-			Dead:  newPlayer.dead,
-			Speed: uint8(newPlayer.speedPxXFrame),
-		}}
+		if !player.invisible {
+			newPlayer.Send(msgs.EPlayerEnterViewport, player.info())
+		}
+		if !newPlayer.invisible {
+			player.Send(msgs.EPlayerEnterViewport, newPlayer.info())
+		}
 	}, func(x, y int32) {
 		newPlayerOutSight := g.space.GetSlot(0, typ.P{X: x, Y: y})
 		if newPlayerOutSight == 0 {
 			return
 		}
+		if isNPC(newPlayerOutSight) {
+			player.Send(msgs.EPlayerLeaveViewport, newPlayerOutSight)
+			return
+		}
 		newPlayerOut := g.players[newPlayerOutSight]
-		newPlayerOut.Send <- OutMsg{Event: msgs.EPlayerLeaveViewport, Data: player.id}
-		player.Send <- OutMsg{Event: msgs.EPlayerLeaveViewport, Data: uint16(newPlayerOut.id)}
+		newPlayerOut.Send(msgs.EPlayerLeaveViewport, player.id)
+		player.Send(msgs.EPlayerLeaveViewport, uint16(newPlayerOut.id))
 	})
 	g.space.Notify(np, msgs.EPlayerMoved, &msgs.EventPlayerMoved{
 		ID:  player.id,
 		Pos: np,
 		Dir: player.dir,
+		T:   g.now(),
 	}, player.id)
 	player.pos = np
-	player.Send <- OutMsg{Event: msgs.EMoveOk, Data: []byte{msgs.BoolByte(true), player.dir}}
+	player.Send(msgs.EMoveOk, &msgs.EventMoveOk{Seq: ev.Seq, Ok: true, Dir: player.dir, Pos: player.pos})
 }
 
 func (g *Game) playerCastSpell(player *Player, incomingData IncomingMsg) {
 	ev := incomingData.Data.(*msgs.EventCastSpell)
 	defer log.Printf("[%v][%v] SPELL %v at [%v %v]\n", player.id, player.nick, ev.Spell.String(), ev.PX, ev.PY)
-	hitPlayer := g.CheckSpellTargets(typ.P{X: int32(ev.PX), Y: int32(ev.PY)})
+	hitPlayer := g.CheckSpellTargets(typ.P{X: int32(ev.PX), Y: int32(ev.PY)}, ev.T)
 	if hitPlayer == 0 {
 		log.Printf("missed all hitboxs\n")
+		player.combat("%v missed", ev.Spell)
+		return
+	}
+	sp := GetSpellProp(ev.Spell)
+	if n := g.npcs[hitPlayer]; n != nil {
+		g.playerCastSpellNPC(player, n, sp)
 		return
 	}
 	targetPlayer := g.players[hitPlayer]
-	dmg, err := Cast(GetSpellProp(ev.Spell), player, targetPlayer)
+	if targetPlayer.invisible && targetPlayer != player {
+		// it cant be clicked if it cant be seen
+		player.combat("%v missed", ev.Spell)
+		return
+	}
+	if !g.canCast(player.pos, targetPlayer.pos, sp) {
+		log.Printf("spell not allowed in this zone\n")
+		player.combat("cant cast %v here", ev.Spell)
+		return
+	}
+	dmg, err := Cast(sp, player, targetPlayer)
 	if err != nil {
+		player.combat("cant cast %v: %v", ev.Spell, err)
 		return
 	}
 	if dmg < 0 {
@@ -442,19 +722,44 @@ func (g *Game) playerCastSpell(player *Player, incomingData IncomingMsg) {
 		Spell:  ev.Spell,
 		Killed: targetPlayer.dead,
 	}, player.id, uint16(targetPlayer.id))
-	player.Send <- OutMsg{Event: msgs.ECastSpellOk, Data: &msgs.EventCastSpellOk{
+	player.Send(msgs.ECastSpellOk, &msgs.EventCastSpellOk{
 		ID:     uint16(hitPlayer),
 		Damage: uint32(dmg),
 		NewMP:  uint32(player.mp),
 		Spell:  ev.Spell,
 		Killed: targetPlayer.dead,
-	}}
-	targetPlayer.Send <- OutMsg{Event: msgs.EPlayerSpellRecieved, Data: &msgs.EventPlayerSpellRecieved{
-		ID:     player.id,
+	})
+	targetPlayer.Send(msgs.EPlayerSpellRecieved, &msgs.EventPlayerSpellRecieved{
+		ID:     player.idFor(targetPlayer),
 		Spell:  ev.Spell,
 		Damage: uint32(dmg),
 		NewHP:  uint32(targetPlayer.hp),
-	}}
+	})
+}
+
+func (g *Game) playerCastSpellNPC(player *Player, n *NPC, sp *SpellProp) {
+	if !g.canCast(player.pos, n.pos, sp) {
+		log.Printf("spell not allowed in this zone\n")
+		player.combat("cant cast %v here", sp.Spell)
+		return
+	}
+	dmg, err := CastOnNPC(sp, player, n)
+	if err != nil {
+		player.combat("cant cast %v: %v", sp.Spell, err)
+		return
+	}
+	g.space.Notify(n.pos, msgs.EPlayerSpell, &msgs.EventPlayerSpell{
+		ID:     n.id,
+		Spell:  sp.Spell,
+		Killed: n.dead,
+	}, player.id)
+	player.Send(msgs.ECastSpellOk, &msgs.EventCastSpellOk{
+		ID:     n.id,
+		Damage: uint32(dmg),
+		NewMP:  uint32(player.mp),
+		Spell:  sp.Spell,
+		Killed: n.dead,
+	})
 }
 
 func (g *Game) playerMelee(player *Player, d direction.D) {
@@ -478,22 +783,29 @@ func (g *Game) playerMelee(player *Player, d direction.D) {
 	if player.dead {
 		log.Printf("dead?")
 
-		player.Send <- OutMsg{Event: msgs.EMeleeOk, Data: &msgs.EventMeleeOk{}}
+		player.Send(msgs.EMeleeOk, &msgs.EventMeleeOk{})
 		return
 	}
 	targetId := g.space.GetSlot(0, np)
 	dmg := int32(0)
 	killed := false
-	if targetId != 0 {
+	if n := g.npcs[targetId]; n != nil {
+		if !n.dead && g.canAttack(player.pos, n.pos) {
+			dmg = MeleeNPC(player, n)
+		} else {
+			targetId = 0
+		}
+		killed = n.dead
+	} else if targetId != 0 {
 		targetPlayer := g.players[targetId]
-		if !targetPlayer.dead {
+		if !targetPlayer.dead && !targetPlayer.invisible && g.canAttack(player.pos, targetPlayer.pos) {
 			dmg = Melee(player, targetPlayer)
-			targetPlayer.Send <- OutMsg{Event: msgs.EPlayerMeleeRecieved, Data: &msgs.EventPlayerMeleeRecieved{
-				ID:     player.id,
+			targetPlayer.Send(msgs.EPlayerMeleeRecieved, &msgs.EventPlayerMeleeRecieved{
+				ID:     player.idFor(targetPlayer),
 				Damage: uint32(dmg),
 				NewHP:  uint32(targetPlayer.hp),
 				Dir:    player.dir,
-			}}
+			})
 		} else {
 			targetId = 0
 		}
@@ -517,20 +829,35 @@ func (g *Game) playerMelee(player *Player, d direction.D) {
 	}
 	log.Printf("%#v", *meleOk)
 
-	player.Send <- OutMsg{Event: msgs.EMeleeOk, Data: meleOk}
+	player.Send(msgs.EMeleeOk, meleOk)
 }
 
 type Player struct {
-	g    *Game
-	obs  *grid.Obs
-	m    msgs.Msgs
-	Send chan OutMsg
-	id   uint16
-	nick string
-	pos  typ.P
-	dir  direction.D
+	g     *Game
+	obs   *grid.Obs
+	m     msgs.Msgs
+	out   *outQueue
+	flush bool       // already in the game flush list
+	snap  *snapshots // nil if the player gets move events
+	// token to resume the session, when a client connects it has the
+	// token it wants to resume
+	token   string
+	dropped time.Time // when the connection died, zero while connected
+	id      uint16
+	nick    string
+	role    msgs.Role
+	pos     typ.P
+	dir     direction.D
+	// only watching, see spectator.go
+	spectator bool
+	// nobody sees it, see admin.go
+	invisible bool
+	// out of the game, waiting for its reader to be done
+	kicked    bool
+	chatLimit chatLimit
 
 	lastMove      time.Time
+	moves         moveHistory
 	speedXTile    time.Duration
 	speedPxXFrame int32
 
@@ -551,57 +878,132 @@ type OutMsg struct {
 	Data  interface{}
 }
 
-func (p *Player) HandleOutgoingMessages() {
+// WriteTimeout is how long a single write can take before we give up on the client
+const WriteTimeout = 5 * time.Second
+
+// MaxBatchBytes splits a batch in more than one write, clients
+// have a limit on the size of a websocket frame.
+const MaxBatchBytes = 16 << 10
+
+// Send queues a message for the player, it never blocks the game loop,
+// it goes out when the game flushes after the current events.
+func (p *Player) Send(e msgs.E, data interface{}) {
+	if !p.out.Push(OutMsg{Event: e, Data: data}) {
+		p.evict(p.m, p.out, fmt.Sprintf("outbound queue full (%v)", OutQueueMax))
+		return
+	}
+	if !p.flush {
+		p.flush = true
+		p.g.flushing = append(p.g.flushing, p)
+	}
+}
+
+// notify gets the events of the tiles in the player view
+func (p *Player) notify(ev grid.Event) {
+	if ev.HasID(uint16(p.id)) || p.g.hidden(ev) {
+		return
+	}
+	if p.snap != nil && syncEvent(ev.E) {
+		return
+	}
+	p.Send(ev.E, ev.Data)
+}
+
+// evict closes the connection, the reader fails and drops the player as usual.
+func (p *Player) evict(m msgs.Msgs, q *outQueue, reason string) {
+	if q.evicted.Swap(true) {
+		return
+	}
+	metricEvicted.Add(1)
+	log.Printf("EVICT: %v [%v] [%v] %v\n", m.IP(), p.nick, p.id, reason)
+	m.Close()
+}
+
+// HandleOutgoingMessages writes what gets to q into m, they are not read from
+// the player since a resumed session changes them.
+func (p *Player) HandleOutgoingMessages(m msgs.Msgs, q *outQueue) {
+	var (
+		queued []OutMsg
+		ok     bool
+		batch  = &msgs.Batch{}
+	)
+	q.track(queueOwner{ID: p.id, Nick: p.nick, Addr: m.IP()})
+	defer q.untrack()
+	write := func() error {
+		if batch.Len() == 0 {
+			return nil
+		}
+		m.SetWriteDeadline(time.Now().Add(WriteTimeout))
+		err := m.WriteBatch(batch)
+		metricWrites.Add(1)
+		metricWritten.Add(int64(batch.Len()))
+		batch.Reset()
+		return err
+	}
 	for {
-		select {
-		case m := <-p.Send:
-			p.m.EncodeAndWrite(m.Event, m.Data)
-		case ev, ok := <-p.obs.Events:
-			if !ok {
-				return
+		queued, ok = q.Pop(queued[:0])
+		if !ok {
+			if q.Finished() {
+				m.Close()
+			}
+			return
+		}
+		for _, msg := range queued {
+			if err := batch.EncodeAndWrite(msg.Event, msg.Data); err != nil {
+				continue
 			}
-			if ev.HasID(uint16(p.id)) {
+			if len(batch.Bytes()) < MaxBatchBytes {
 				continue
 			}
-			p.m.EncodeAndWrite(ev.E, ev.Data)
+			if err := write(); err != nil {
+				p.evict(m, q, fmt.Sprintf("write: %v", err))
+				return
+			}
+		}
+		if err := write(); err != nil {
+			p.evict(m, q, fmt.Sprintf("write: %v", err))
+			return
 		}
+		clear(queued)
 	}
 }
 
-func (p *Player) HandleIncomingMessages() {
+func (p *Player) HandleIncomingMessages(m msgs.Msgs) {
 	for {
 
-		im, err := p.m.Read()
+		im, err := m.Read()
 		if err != nil {
+			// the conn goes with it, so the game can tell if its from an old one
 			p.g.incomingData <- IncomingMsg{
 				ID:    uint16(p.id),
 				Event: msgs.EPlayerLogout,
+				Data:  m,
 			}
 			return
 		}
-		msg := IncomingMsg{
-			ID:    uint16(p.id),
-			Event: im.Event,
+		if p.spectator && !spectatorEvent(im.Event) {
+			continue
 		}
 		//log.Printf("recieved %v from %v", im.Event.String(), p.nick)
 		switch im.Event {
-		case msgs.EPing:
-		case msgs.EMove:
-			msg.Data = direction.D(im.Data[0])
-		case msgs.ECastSpell:
-			msg.Data = msgs.DecodeEventCastSpell(im.Data)
-		case msgs.EMelee:
-			msg.Data = direction.D(im.Data[0])
-		case msgs.EUseItem:
-			msg.Data = msgs.Item(im.Data[0])
-		case msgs.ESendChat:
-			d := msgs.DecodeMsgpack(im.Data, &msgs.EventSendChat{})
-			msg.Data = d
+		case msgs.EPing, msgs.EMove, msgs.ECastSpell, msgs.EMelee, msgs.EUseItem,
+			msgs.ESendChat, msgs.EMapChunkRequest, msgs.EMapEdit, msgs.ESnapshotAck,
+			msgs.ECameraMove:
 		default:
 			log.Printf("HandleIncomingMessages unknown event\n")
 			continue
 		}
-		p.g.incomingData <- msg
+		// anything can come from a client, bad data is dropped
+		data, err := msgs.Decode(im.Event, im.Data)
+		if err != nil {
+			log.Printf("[%v][%v] bad %v: %v\n", p.id, p.nick, im.Event, err)
+			continue
+		}
+		p.g.incomingData <- IncomingMsg{
+			ID:    uint16(p.id),
+			Event: im.Event,
+			Data:  data,
+		}
 	}
 }
 
@@ -624,60 +1026,83 @@ func checkSpawn(g *grid.Grid, spawn typ.P) typ.P {
 }
 
 func (p *Player) Login() {
-	p.pos = checkSpawn(p.g.space, p.pos)
-	loginEvent := &msgs.EventPlayerLogin{
-		ID:    uint16(p.id),
-		Nick:  p.nick,
-		Pos:   p.pos,
-		Dir:   p.dir,
-		HP:    p.hp,
-		MaxHP: p.maxHp,
-		MP:    p.mp,
-		MaxMP: p.maxMp,
-		Speed: uint8(p.speedPxXFrame),
+	if len(p.g.world.Spawns) != 0 {
+		p.pos = p.g.world.Spawns[rand.Intn(len(p.g.world.Spawns))]
+	} else if respawns := p.g.world.RespawnPoints(); len(respawns) != 0 {
+		p.pos = respawns[rand.Intn(len(respawns))]
 	}
-	log.Printf("login %#v", *loginEvent)
-
-	p.obs = grid.NewObserverRange(p.g.space, p.pos,
-		constants.GridViewportX, constants.GridViewportY,
-		func(t *grid.Tile) {
-			if t.Layers[0] != 0 {
-				log.Print(t.Layers[0])
-				vp := p.g.players[t.Layers[0]]
-				loginEvent.VisiblePlayers = append(loginEvent.VisiblePlayers, msgs.EventNewPlayer{
-					ID:    uint16(vp.id),
-					Nick:  vp.nick,
-					Pos:   vp.pos,
-					Dir:   vp.dir,
-					Speed: uint8(vp.speedPxXFrame),
-					Dead:  vp.dead,
-				})
-			}
-		},
-	)
+	p.pos = checkSpawn(p.g.space, p.pos)
+	p.token = newResumeToken()
+	p.g.sessions[p.token] = p.id
+	p.obs = grid.NewObserver(p.g.space, p.pos,
+		constants.GridViewportX, constants.GridViewportY, p.notify)
 	p.g.space.Set(0, p.pos, uint16(p.id))
-	go p.HandleIncomingMessages()
-	go p.HandleOutgoingMessages()
-	encoded := msgs.EncodeMsgpack(loginEvent)
-	testLogin := msgs.DecodeMsgpack(encoded, &msgs.EventPlayerLogin{})
-	log.Printf("login %#v", *testLogin)
-
-	p.m.WriteWithLen(msgs.EPlayerLogin, encoded)
-	p.g.space.Notify(p.pos, msgs.EPlayerSpawned, &msgs.EventPlayerSpawned{
-		ID:    uint16(p.id),
-		Nick:  p.nick,
-		Pos:   p.pos,
-		Dir:   p.dir,
-		Speed: uint8(p.speedPxXFrame),
-	}, uint16(p.id))
+	p.Send(msgs.EPlayerLogin, p.loginEvent())
+	go p.HandleIncomingMessages(p.m)
+	go p.HandleOutgoingMessages(p.m, p.out)
+	p.g.space.Notify(p.pos, msgs.EPlayerSpawned, p.info(), uint16(p.id))
+}
+
+// loginEvent has everything the client needs to start, or to start over after a resume
+func (p *Player) loginEvent() *msgs.EventPlayerLogin {
+	e := &msgs.EventPlayerLogin{
+		ID:          uint16(p.id),
+		Nick:        p.nick,
+		Role:        p.role,
+		MapW:        p.g.world.W,
+		MapH:        p.g.world.H,
+		Pos:         p.pos,
+		Dir:         p.dir,
+		Dead:        p.dead,
+		HP:          p.hp,
+		MaxHP:       p.maxHp,
+		MP:          p.mp,
+		MaxMP:       p.maxMp,
+		Speed:       uint8(p.speedPxXFrame),
+		Zones:       p.g.world.Zones,
+		ResumeToken: p.token,
+	}
+	for _, vp := range p.g.visible(p) {
+		e.VisiblePlayers = append(e.VisiblePlayers, vp)
+	}
+	return e
 }
 
 func (p *Player) Logout() {
+	delete(p.g.sessions, p.token)
 	p.obs.Nuke()
+	p.out.Close()
 	p.g.space.Unset(0, p.pos)
 	p.g.space.Notify(p.pos, msgs.EPlayerDespawned, uint16(p.id), uint16(p.id))
 }
 
+// Teleport takes the player anywhere, the ones around see it go away
+// and appear somewhere else, the player gets a new login.
+func (p *Player) Teleport(to typ.P) {
+	g := p.g
+	p.obs.Nuke()
+	g.space.Unset(0, p.pos)
+	g.space.Notify(p.pos, msgs.EPlayerDespawned, p.id, p.id)
+	p.pos = checkSpawn(g.space, to)
+	p.moves.Add(g.now(), p.pos, p.dir)
+	p.obs = grid.NewObserver(g.space, p.pos,
+		constants.GridViewportX, constants.GridViewportY, p.notify)
+	g.space.Set(0, p.pos, p.id)
+	p.Send(msgs.EPlayerLogin, p.loginEvent())
+	g.space.Notify(p.pos, msgs.EPlayerSpawned, p.info(), p.id)
+}
+
+func (p *Player) info() *msgs.EventNewPlayer {
+	return &msgs.EventNewPlayer{
+		ID:    p.id,
+		Nick:  p.nick,
+		Pos:   p.pos,
+		Dir:   p.dir,
+		Speed: uint8(p.speedPxXFrame),
+		Dead:  p.dead,
+	}
+}
+
 func (p *Player) TakeDamage(dmg int32) {
 	p.hp = p.hp - dmg
 	if p.hp <= 0 {
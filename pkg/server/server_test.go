@@ -0,0 +1,99 @@
+package server
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rywk/minigoao/pkg/constants"
+	"github.com/rywk/minigoao/pkg/constants/direction"
+	"github.com/rywk/minigoao/pkg/grid"
+	"github.com/rywk/minigoao/pkg/msgs"
+	"github.com/rywk/minigoao/pkg/pathfind"
+	"github.com/rywk/minigoao/pkg/typ"
+	"github.com/rywk/minigoao/pkg/world"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestGame is a game on the default map without the network,
+// its loop doesnt run, the tests call handle.
+func newTestGame(t *testing.T) *Game {
+	cfg := DefaultConfig
+	cfg.MapPath, cfg.BansPath, cfg.AuditPath = "", "", ""
+	wmap := world.Default()
+	space := grid.NewGrid(wmap.W, wmap.H, 2)
+	g := &Game{
+		cfg:          &cfg,
+		players:      []*Player{{id: 0}},
+		playersIndex: make([]uint16, 0),
+		world:        wmap,
+		space:        space,
+		paths:        pathfind.NewFinder(space, 256),
+		npcs:         make(map[uint16]*NPC),
+		sessions:     make(map[string]uint16),
+		recordings:   make(map[string]*recording),
+		parties:      make(map[uint16]*party),
+		requests:     make(map[request]time.Time),
+		mutes:        make(map[string]mute),
+		bans:         &bans{},
+		start:        time.Now(),
+		nextNPC:      NPCIDStart,
+		incomingData: make(chan IncomingMsg, 1000),
+	}
+	g.loadWorld()
+	return g
+}
+
+// newTestPlayer logs in a player on a pipe, the other end
+// reads and drops everything the player gets.
+func newTestPlayer(t *testing.T, g *Game, nick string) *Player {
+	s, c := net.Pipe()
+	t.Cleanup(func() { c.Close() })
+	go io.Copy(io.Discard, c)
+	p := &Player{
+		g:             g,
+		m:             msgs.New(s),
+		nick:          nick,
+		pos:           typ.P{X: constants.WorldX / 2, Y: constants.WorldY / 2},
+		out:           newOutQueue(),
+		dir:           direction.Front,
+		speedPxXFrame: 3,
+		speedXTile:    (constants.TileSize / 3) * AverageGameFrame,
+		hp:            372,
+		maxHp:         372,
+		mp:            2420,
+		maxMp:         2420,
+	}
+	g.online++
+	g.AddPlayer(p)
+	p.Login()
+	return p
+}
+
+func TestPlayerIDs(t *testing.T) {
+	g := newTestGame(t)
+	a := &Player{g: g}
+	b := &Player{g: g}
+	require.True(t, g.AddPlayer(a))
+	require.True(t, g.AddPlayer(b))
+	require.Equal(t, uint16(1), a.id)
+	require.Equal(t, uint16(2), b.id)
+
+	// the ids that are gone come back, the oldest first
+	g.RemovePlayer(b.id)
+	g.RemovePlayer(a.id)
+	c := &Player{g: g}
+	require.True(t, g.AddPlayer(c))
+	require.Equal(t, uint16(2), c.id)
+	require.Equal(t, c, g.player(2))
+
+	// never into the npc ids
+	g.players = append(g.players, make([]*Player, NPCIDStart-len(g.players))...)
+	g.freeIDs = g.freeIDs[:0]
+	require.False(t, g.AddPlayer(&Player{g: g}))
+	g.RemovePlayer(c.id)
+	d := &Player{g: g}
+	require.True(t, g.AddPlayer(d))
+	require.Equal(t, uint16(2), d.id)
+}
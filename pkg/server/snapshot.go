@@ -0,0 +1,94 @@
+package server
+
+import (
+	"github.com/rywk/minigoao/pkg/msgs"
+	"github.com/rywk/minigoao/pkg/typ"
+)
+
+// SnapshotHistory is how many sent snapshots we keep to diff against,
+// if the client acks one older than that it gets a full one.
+const SnapshotHistory = 32
+
+// snapshots is the state of a player that syncs with snapshots
+// instead of getting the move events.
+type snapshots struct {
+	seq     uint32
+	acked   uint32
+	history [SnapshotHistory]struct {
+		seq uint32
+		s   msgs.Snapshot
+	}
+}
+
+func (s *snapshots) base() (uint32, msgs.Snapshot) {
+	h := s.history[s.acked%SnapshotHistory]
+	if s.acked == 0 || h.seq != s.acked {
+		return 0, nil
+	}
+	return h.seq, h.s
+}
+
+func (s *snapshots) Ack(seq uint32) {
+	// a 0 ack is the client asking for a full snapshot
+	if seq == 0 || seq > s.acked {
+		s.acked = seq
+	}
+}
+
+// syncEvent is true for the events a snapshot replaces
+func syncEvent(e msgs.E) bool {
+	return e == msgs.EPlayerMoved
+}
+
+// sendSnapshots sends every player in snapshot mode what changed
+// in its viewport since the last snapshot it acked.
+func (g *Game) sendSnapshots() {
+	for _, id := range g.playersIndex {
+		p := g.player(id)
+		if p == nil || p.snap == nil || p.obs == nil || !p.dropped.IsZero() {
+			continue
+		}
+		cur := g.visible(p)
+		baseSeq, base := p.snap.base()
+		ev := cur.Delta(p.snap.seq+1, baseSeq, base)
+		if ev.Empty() {
+			continue
+		}
+		p.snap.seq++
+		h := &p.snap.history[p.snap.seq%SnapshotHistory]
+		h.seq, h.s = p.snap.seq, cur
+		p.Send(msgs.ESnapshot, ev)
+	}
+}
+
+// visible is everyone in the view of p, but p
+func (g *Game) visible(p *Player) msgs.Snapshot {
+	s := msgs.Snapshot{}
+	v := p.obs.View
+	for x := v.Min.X; x <= v.Max.X; x++ {
+		for y := v.Min.Y; y <= v.Max.Y; y++ {
+			id := g.space.GetSlot(0, typ.P{X: x, Y: y})
+			if id == 0 || id == p.id {
+				continue
+			}
+			if e := g.info(id); e != nil {
+				s[id] = *e
+			}
+		}
+	}
+	return s
+}
+
+// info is like visible, for a single id, nil if nobody has it
+func (g *Game) info(id uint16) *msgs.EventNewPlayer {
+	if id == 0 {
+		return nil
+	}
+	if n := g.npcs[id]; n != nil {
+		return n.info()
+	}
+	if p := g.player(id); p != nil && !p.invisible {
+		return p.info()
+	}
+	return nil
+}
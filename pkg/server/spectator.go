@@ -0,0 +1,95 @@
+package server
+
+import (
+	"log"
+
+	"github.com/rywk/minigoao/pkg/constants"
+	"github.com/rywk/minigoao/pkg/grid"
+	"github.com/rywk/minigoao/pkg/msgs"
+	"github.com/rywk/minigoao/pkg/typ"
+)
+
+// Spectators are players without a character, they have an observer
+// to get the events around their camera but nothing in the layer 0
+// of the grid, so nobody can see them, hit them or chase them.
+// They dont count as online and can only move the camera around.
+
+// spectatorEvent is true for what a spectator can send
+func spectatorEvent(e msgs.E) bool {
+	switch e {
+	case msgs.EPing, msgs.EMapChunkRequest, msgs.ECameraMove:
+		return true
+	}
+	return false
+}
+
+// spectatorStart is where the camera starts, the first
+// zone where you can fight or the middle of the map.
+func (g *Game) spectatorStart() typ.P {
+	for _, z := range g.world.Zones {
+		if !z.NoCombat {
+			return typ.P{X: (z.Rect.Min.X + z.Rect.Max.X) / 2, Y: (z.Rect.Min.Y + z.Rect.Max.Y) / 2}
+		}
+	}
+	return typ.P{X: g.world.W / 2, Y: g.world.H / 2}
+}
+
+func (p *Player) LoginSpectator() {
+	visible := p.watch(p.g.spectatorStart())
+	e := &msgs.EventPlayerLogin{
+		ID:        p.id,
+		Nick:      p.nick,
+		Role:      p.role,
+		MapW:      p.g.world.W,
+		MapH:      p.g.world.H,
+		Pos:       p.pos,
+		Zones:     p.g.world.Zones,
+		Spectator: true,
+	}
+	for _, vp := range visible {
+		e.VisiblePlayers = append(e.VisiblePlayers, vp)
+	}
+	p.Send(msgs.EPlayerLogin, e)
+	go p.HandleIncomingMessages(p.m)
+	go p.HandleOutgoingMessages(p.m, p.out)
+}
+
+// watch puts the observer of the spectator at pos, it returns everyone in its view
+func (p *Player) watch(pos typ.P) msgs.Snapshot {
+	visible := msgs.Snapshot{}
+	p.pos = pos
+	p.obs = grid.NewObserverRange(p.g.space, pos, constants.GridViewportX, constants.GridViewportY, func(t *grid.Tile) {
+		if vp := p.g.info(t.Layers[0]); vp != nil {
+			visible[vp.ID] = *vp
+		}
+	}, p.notify)
+	return visible
+}
+
+// moveCamera moves the view of a spectator, it can jump anywhere
+// when it starts following someone, so the observer is made again.
+func (g *Game) moveCamera(p *Player, pos typ.P) {
+	if pos.Out(g.space.Rect) || pos == p.pos {
+		return
+	}
+	before := g.visible(p)
+	p.obs.Nuke()
+	after := p.watch(pos)
+	for id := range before {
+		if _, ok := after[id]; !ok {
+			p.Send(msgs.EPlayerLeaveViewport, id)
+		}
+	}
+	for id, vp := range after {
+		if _, ok := before[id]; !ok {
+			p.Send(msgs.EPlayerEnterViewport, &vp)
+		}
+	}
+}
+
+// LogoutSpectator is Logout for spectators, they have nothing to resume
+func (p *Player) LogoutSpectator() {
+	p.obs.Nuke()
+	p.out.Close()
+	log.Printf("SPECTATOR LOG OUT: %v  [%v] [%v]\n", p.m.IP(), p.nick, p.id)
+}
@@ -71,7 +71,9 @@ var (
 	goVersion       = "go1.23.1"
 )
 
-func init() {
+// Load reads the files the page serves and gets wasm_exec.js,
+// the server calls it on start so importing the package doesnt.
+func Load() {
 	var err error
 	wasmFile, err := os.Open("./bin/" + mainWasm)
 	if err != nil {
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+
+	"github.com/rywk/minigoao/pkg/msgs"
+	"github.com/rywk/minigoao/pkg/typ"
+	"github.com/rywk/minigoao/pkg/world"
+)
+
+// loadZones replaces the zones of the map with the ones in a json file,
+// it's easier to write them by hand than editing the map.
+// A missing file keeps the zones the map has.
+func loadZones(path string, m *world.Map) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	zones := []msgs.Zone{}
+	if err := json.Unmarshal(data, &zones); err != nil {
+		return err
+	}
+	if err := m.CheckZones(zones); err != nil {
+		return fmt.Errorf("%v: %w", path, err)
+	}
+	log.Printf("loaded %v zones from %v\n", len(zones), path)
+	m.Zones = zones
+	return nil
+}
+
+// canAttack is false if any of the two is standing in a safe zone,
+// so you cant hit someone in the spawn from outside either.
+func (g *Game) canAttack(from, to typ.P) bool {
+	return !g.world.ZoneAt(from).Safe() && !g.world.ZoneAt(to).Safe()
+}
+
+func (g *Game) canCast(from, to typ.P, s *SpellProp) bool {
+	fz, tz := g.world.ZoneAt(from), g.world.ZoneAt(to)
+	if fz != nil && fz.NoSpells || tz != nil && tz.NoSpells {
+		return false
+	}
+	return !s.Offensive || g.canAttack(from, to)
+}
+
+func (g *Game) canUseItem(p *Player) bool {
+	z := g.world.ZoneAt(p.pos)
+	return z == nil || !z.NoItems
+}
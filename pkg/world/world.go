@@ -0,0 +1,366 @@
+package world
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/rywk/minigoao/pkg/constants"
+	"github.com/rywk/minigoao/pkg/constants/assets"
+	"github.com/rywk/minigoao/pkg/constants/npc"
+	"github.com/rywk/minigoao/pkg/msgs"
+	"github.com/rywk/minigoao/pkg/typ"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Side of the square chunks the map is streamed to the clients in, in tiles.
+const ChunkTiles = 16
+
+// Map is the editable description of the world, what the server loads
+// from the map file and streams to the clients in chunks.
+// Layers are indexed [y][x].
+type Map struct {
+	W, H    int32
+	Ground  [][]assets.Image
+	Stuff   [][]assets.Image
+	Blocked [][]bool
+	Spawns  []typ.P
+	Zones   []msgs.Zone
+	NPCs    []msgs.NPCSpawn
+}
+
+func New(w, h int32) *Map {
+	m := &Map{
+		W:       w,
+		H:       h,
+		Ground:  make([][]assets.Image, h),
+		Stuff:   make([][]assets.Image, h),
+		Blocked: make([][]bool, h),
+	}
+	for y := range m.Ground {
+		m.Ground[y] = make([]assets.Image, w)
+		m.Stuff[y] = make([]assets.Image, w)
+		m.Blocked[y] = make([]bool, w)
+	}
+	return m
+}
+
+// Default is the map we had hardcoded before having map files,
+// grass, shrooms on the edges and the two arenas.
+func Default() *Map {
+	m := New(constants.WorldX, constants.WorldY)
+	for y := int32(0); y < m.H; y++ {
+		for x := int32(0); x < m.W; x++ {
+			m.Ground[y][x] = assets.Grass
+			if x == 0 || y == 0 || x == m.W-1 || y == m.H-1 || (x%25 == 0 && y%25 == 0) {
+				m.Stuff[y][x] = assets.Shroom
+			}
+		}
+	}
+	arena1v1 := image.Rect(0, 0, 8, 8).Add(image.Point{X: 25, Y: 29})
+	arena2v2 := image.Rect(0, 0, 16, 12).Add(image.Point{X: 40, Y: 29})
+	for _, arena := range []image.Rectangle{arena1v1, arena2v2} {
+		for y := arena.Min.Y; y < arena.Max.Y; y++ {
+			m.Stuff[y][arena.Min.X] = assets.Shroom
+			m.Stuff[y][arena.Max.X] = assets.Shroom
+		}
+		for x := arena.Min.X; x < arena.Max.X; x++ {
+			m.Stuff[arena.Min.Y][x] = assets.Shroom
+			m.Stuff[arena.Max.Y][x] = assets.Shroom
+		}
+	}
+	// arena doors
+	m.Stuff[37][32] = assets.Nothing
+	m.Stuff[41][55] = assets.Nothing
+	m.Stuff[41][54] = assets.Nothing
+	m.Stuff[41][40] = assets.Nothing
+	m.Stuff[41][41] = assets.Nothing
+
+	for y := range m.Stuff {
+		for x, a := range m.Stuff[y] {
+			m.Blocked[y][x] = assets.IsSolid(a)
+		}
+	}
+	m.Zones = []msgs.Zone{
+		{
+			Name:     "Spawn",
+			Rect:     typ.Rect{Min: typ.P{X: 1, Y: 1}, Max: typ.P{X: 13, Y: 9}},
+			NoCombat: true,
+			Respawn:  &typ.P{X: 6, Y: 4},
+		},
+		{Name: "Arena 1v1", Rect: typ.Rect{Min: typ.P{X: 26, Y: 30}, Max: typ.P{X: 33, Y: 37}}},
+		{Name: "Arena 2v2", Rect: typ.Rect{Min: typ.P{X: 41, Y: 30}, Max: typ.P{X: 56, Y: 41}}},
+	}
+	m.NPCs = []msgs.NPCSpawn{
+		{Type: npc.Skeleton, Pos: typ.P{X: 20, Y: 70}},
+		{Type: npc.Skeleton, Pos: typ.P{X: 24, Y: 74}},
+		{Type: npc.Skeleton, Pos: typ.P{X: 70, Y: 70}},
+		{Type: npc.Skeleton, Pos: typ.P{X: 76, Y: 68}},
+		{Type: npc.DarkKnight, Pos: typ.P{X: 80, Y: 15}},
+	}
+	return m
+}
+
+func (m *Map) Rect() typ.Rect {
+	return typ.Rect{Max: typ.P{X: m.W, Y: m.H}}
+}
+
+func (m *Map) In(p typ.P) bool {
+	return p.In(m.Rect())
+}
+
+func (m *Map) Chunks() typ.P {
+	return typ.P{X: (m.W + ChunkTiles - 1) / ChunkTiles, Y: (m.H + ChunkTiles - 1) / ChunkTiles}
+}
+
+// ChunkRect is the rect of tiles covered by chunk cp, clipped to the map.
+func (m *Map) ChunkRect(cp typ.P) typ.Rect {
+	r := typ.Rect{
+		Min: typ.P{X: cp.X * ChunkTiles, Y: cp.Y * ChunkTiles},
+		Max: typ.P{X: cp.X*ChunkTiles + ChunkTiles, Y: cp.Y*ChunkTiles + ChunkTiles},
+	}
+	r.Max.X, r.Max.Y = min(r.Max.X, m.W), min(r.Max.Y, m.H)
+	return r
+}
+
+func ChunkOf(p typ.P) typ.P {
+	return typ.P{X: p.X / ChunkTiles, Y: p.Y / ChunkTiles}
+}
+
+func (m *Map) IsSpawn(p typ.P) bool {
+	for _, s := range m.Spawns {
+		if s == p {
+			return true
+		}
+	}
+	return false
+}
+
+// ZoneAt returns the zone p is in or nil, when zones overlap
+// the one defined last wins so small zones can go inside big ones.
+func (m *Map) ZoneAt(p typ.P) *msgs.Zone {
+	for i := len(m.Zones) - 1; i >= 0; i-- {
+		if p.In(m.Zones[i].Rect) {
+			return &m.Zones[i]
+		}
+	}
+	return nil
+}
+
+// Zone is the zone with that name, nil if there is none
+func (m *Map) Zone(name string) *msgs.Zone {
+	for i := range m.Zones {
+		if m.Zones[i].Name == name {
+			return &m.Zones[i]
+		}
+	}
+	return nil
+}
+
+func (m *Map) RespawnPoints() []typ.P {
+	ps := []typ.P{}
+	for _, z := range m.Zones {
+		if z.Respawn != nil && m.In(*z.Respawn) {
+			ps = append(ps, *z.Respawn)
+		}
+	}
+	return ps
+}
+
+// NPCAt returns the npc spawned on p, npc.None if there is none.
+func (m *Map) NPCAt(p typ.P) npc.Type {
+	for _, n := range m.NPCs {
+		if n.Pos == p {
+			return n.Type
+		}
+	}
+	return npc.None
+}
+
+func (m *Map) Tile(p typ.P) *msgs.EventMapTile {
+	return &msgs.EventMapTile{
+		Pos:     p,
+		Ground:  m.Ground[p.Y][p.X],
+		Stuff:   m.Stuff[p.Y][p.X],
+		Blocked: m.Blocked[p.Y][p.X],
+		Spawn:   m.IsSpawn(p),
+		NPC:     m.NPCAt(p),
+	}
+}
+
+// Chunk returns the data of chunk cp, layers are sent row by row.
+func (m *Map) Chunk(cp typ.P) *msgs.EventMapChunk {
+	r := m.ChunkRect(cp)
+	c := &msgs.EventMapChunk{
+		Chunk: cp,
+		Rect:  r,
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		c.Ground = append(c.Ground, m.Ground[y][r.Min.X:r.Max.X]...)
+		c.Stuff = append(c.Stuff, m.Stuff[y][r.Min.X:r.Max.X]...)
+		c.Blocked = append(c.Blocked, m.Blocked[y][r.Min.X:r.Max.X]...)
+	}
+	for _, s := range m.Spawns {
+		if s.In(r) {
+			c.Spawns = append(c.Spawns, s)
+		}
+	}
+	for _, n := range m.NPCs {
+		if n.Pos.In(r) {
+			c.NPCs = append(c.NPCs, n)
+		}
+	}
+	return c
+}
+
+var ErrBadEdit = errors.New("bad map edit")
+
+// Edit applies an editor operation on a tile.
+// Painting stuff also sets the tile as blocked if the asset is solid,
+// it can be changed after with MapEditBlock.
+func (m *Map) Edit(e *msgs.EventMapEdit) error {
+	if !m.In(e.Pos) {
+		return ErrBadEdit
+	}
+	p := e.Pos
+	switch e.Op {
+	case msgs.MapEditGround:
+		if !slices.Contains(assets.GroundTiles, e.Asset) {
+			return ErrBadEdit
+		}
+		m.Ground[p.Y][p.X] = e.Asset
+	case msgs.MapEditStuff:
+		if !slices.Contains(assets.StuffTiles, e.Asset) {
+			return ErrBadEdit
+		}
+		m.Stuff[p.Y][p.X] = e.Asset
+		m.Blocked[p.Y][p.X] = assets.IsSolid(e.Asset)
+	case msgs.MapEditBlock:
+		m.Blocked[p.Y][p.X] = !m.Blocked[p.Y][p.X]
+	case msgs.MapEditSpawn:
+		for i, s := range m.Spawns {
+			if s == p {
+				m.Spawns = append(m.Spawns[:i], m.Spawns[i+1:]...)
+				return nil
+			}
+		}
+		m.Spawns = append(m.Spawns, p)
+	case msgs.MapEditNPC:
+		// same type removes it, other type replaces it
+		t := npc.Type(e.Asset)
+		if t == npc.None || t >= npc.Len {
+			return ErrBadEdit
+		}
+		for i, n := range m.NPCs {
+			if n.Pos == p {
+				m.NPCs = append(m.NPCs[:i], m.NPCs[i+1:]...)
+				if n.Type == t {
+					return nil
+				}
+				break
+			}
+		}
+		m.NPCs = append(m.NPCs, msgs.NPCSpawn{Type: t, Pos: p})
+	default:
+		return ErrBadEdit
+	}
+	return nil
+}
+
+func (m *Map) Encode() ([]byte, error) {
+	return msgpack.Marshal(m)
+}
+
+func Decode(data []byte) (*Map, error) {
+	m := &Map{}
+	if err := msgpack.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	if err := m.check(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// check is what a map file needs for the server and the clients not
+// to break on it, a size that matches the layers, only assets the
+// editor can paint and everything in the map.
+func (m *Map) check() error {
+	if m.W <= 0 || m.H <= 0 {
+		return fmt.Errorf("bad map size %vx%v", m.W, m.H)
+	}
+	if int32(len(m.Ground)) != m.H || int32(len(m.Stuff)) != m.H || int32(len(m.Blocked)) != m.H {
+		return errors.New("map layers dont match the map size")
+	}
+	for y := int32(0); y < m.H; y++ {
+		if int32(len(m.Ground[y])) != m.W || int32(len(m.Stuff[y])) != m.W || int32(len(m.Blocked[y])) != m.W {
+			return fmt.Errorf("map row %v doesnt match the map width", y)
+		}
+		for x := int32(0); x < m.W; x++ {
+			if !slices.Contains(assets.GroundTiles, m.Ground[y][x]) {
+				return fmt.Errorf("ground at %v,%v is asset %v, it cant be painted", x, y, m.Ground[y][x])
+			}
+			if !slices.Contains(assets.StuffTiles, m.Stuff[y][x]) {
+				return fmt.Errorf("stuff at %v,%v is asset %v, it cant be painted", x, y, m.Stuff[y][x])
+			}
+		}
+	}
+	for _, s := range m.Spawns {
+		if !m.In(s) {
+			return fmt.Errorf("spawn %v is out of the map", s)
+		}
+	}
+	for _, n := range m.NPCs {
+		if n.Type == npc.None || n.Type >= npc.Len {
+			return fmt.Errorf("npc at %v has unknown type %v", n.Pos, n.Type)
+		}
+		if !m.In(n.Pos) {
+			return fmt.Errorf("npc %v is out of the map", n.Pos)
+		}
+	}
+	return m.CheckZones(m.Zones)
+}
+
+// CheckZones is an error if any of the zones is not all in the map
+func (m *Map) CheckZones(zones []msgs.Zone) error {
+	for _, z := range zones {
+		r := z.Rect
+		if r.Min.X < 0 || r.Min.Y < 0 || r.Max.X > m.W || r.Max.Y > m.H || r.Min.X >= r.Max.X || r.Min.Y >= r.Max.Y {
+			return fmt.Errorf("zone %v %v is out of the map", z.Name, r)
+		}
+		if z.Respawn != nil && !m.In(*z.Respawn) {
+			return fmt.Errorf("zone %v respawn %v is out of the map", z.Name, *z.Respawn)
+		}
+	}
+	return nil
+}
+
+func Load(path string) (*Map, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(data)
+}
+
+// WriteFile writes an encoded map, through a temp file so a crash
+// in the middle doesnt leave a broken map.
+func WriteFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,62 @@
+package world_test
+
+import (
+	"testing"
+
+	"github.com/rywk/minigoao/pkg/constants/assets"
+	"github.com/rywk/minigoao/pkg/constants/npc"
+	"github.com/rywk/minigoao/pkg/msgs"
+	"github.com/rywk/minigoao/pkg/typ"
+	"github.com/rywk/minigoao/pkg/world"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecode(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		spoil func(m *world.Map)
+	}{
+		{name: "default"},
+		{name: "short row", spoil: func(m *world.Map) { m.Stuff[3] = m.Stuff[3][:5] }},
+		{name: "marker on the ground", spoil: func(m *world.Map) { m.Ground[1][1] = assets.SolidBlocks }},
+		{name: "npc skin as stuff", spoil: func(m *world.Map) { m.Stuff[1][1] = assets.SkeletonBody }},
+		{name: "spawn out", spoil: func(m *world.Map) { m.Spawns = append(m.Spawns, typ.P{X: m.W, Y: 0}) }},
+		{name: "npc out", spoil: func(m *world.Map) {
+			m.NPCs = append(m.NPCs, msgs.NPCSpawn{Type: npc.Skeleton, Pos: typ.P{X: -1, Y: 2}})
+		}},
+		{name: "npc type", spoil: func(m *world.Map) {
+			m.NPCs = append(m.NPCs, msgs.NPCSpawn{Type: npc.Len, Pos: typ.P{X: 2, Y: 2}})
+		}},
+		{name: "zone out", spoil: func(m *world.Map) {
+			m.Zones = append(m.Zones, msgs.Zone{Name: "out", Rect: typ.Rect{Max: typ.P{X: m.W + 1, Y: 2}}})
+		}},
+	} {
+		m := world.Default()
+		if c.spoil != nil {
+			c.spoil(m)
+		}
+		data, err := m.Encode()
+		require.NoError(t, err, c.name)
+		_, err = world.Decode(data)
+		if c.spoil == nil {
+			require.NoError(t, err, c.name)
+		} else {
+			require.Error(t, err, c.name)
+		}
+	}
+}
+
+func TestEditAssets(t *testing.T) {
+	m := world.Default()
+	p := typ.P{X: 3, Y: 3}
+	for _, a := range assets.GroundTiles {
+		require.NoError(t, m.Edit(&msgs.EventMapEdit{Op: msgs.MapEditGround, Pos: p, Asset: a}))
+	}
+	for _, a := range assets.StuffTiles {
+		require.NoError(t, m.Edit(&msgs.EventMapEdit{Op: msgs.MapEditStuff, Pos: p, Asset: a}))
+	}
+	for _, a := range []assets.Image{assets.SolidBlocks, assets.SolidBlocksEnd, assets.DarkKnightHead, assets.NakedBody, assets.Len} {
+		require.ErrorIs(t, m.Edit(&msgs.EventMapEdit{Op: msgs.MapEditGround, Pos: p, Asset: a}), world.ErrBadEdit)
+		require.ErrorIs(t, m.Edit(&msgs.EventMapEdit{Op: msgs.MapEditStuff, Pos: p, Asset: a}), world.ErrBadEdit)
+	}
+}